@@ -0,0 +1,48 @@
+package anchor
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+// LoadKey loads the service key of the algorithm (RSA or ECC) from path. If the file does not exist, a new key is
+// generated and written there, readable only by the owner. An empty path generates a key that is lost on restart.
+func LoadKey(path string, algorithm string, logger *slog.Logger) (crypto.Signer, error) {
+	algorithm = strings.ToUpper(algorithm)
+	if path != "" {
+		privateKey, err := os.ReadFile(path)
+		if err == nil {
+			signer, err := crypto.SignerFromPrivateKey(algorithm, privateKey, logger)
+			if err != nil {
+				return nil, fmt.Errorf("service key %s: %w", path, err)
+			}
+			return signer, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	policy := crypto.DefaultKeyPolicy()
+	policy.Logger = logger
+	signer, err := policy.NewSigner(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return signer, nil
+	}
+	privateKey, err := crypto.MarshalPrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, privateKey, 0o600); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
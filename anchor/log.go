@@ -0,0 +1,237 @@
+// Package anchor anchors the transactions of all devices in an append-only Merkle tree. New transactions are
+// appended periodically and every extension of the tree is published as a tree head signed by a service key, so
+// that auditors can check single transactions with inclusion proofs and that the tree only grew with consistency
+// proofs, instead of verifying whole signature chains.
+package anchor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/merkle"
+)
+
+// ErrNotAnchored is returned for proofs of transactions that are not covered by a tree head yet.
+var ErrNotAnchored = errors.New("transaction not anchored yet")
+
+// ErrInvalidTreeSize is returned for consistency proofs between tree sizes that were not published.
+var ErrInvalidTreeSize = errors.New("invalid tree size")
+
+// Store holds the anchored transactions and the published tree heads, like persistence.InMemoryDB.
+type Store interface {
+	// PendingAnchors returns the transactions that are not anchored yet.
+	PendingAnchors() []domain.Transaction
+	// AppendAnchor appends transactions to the tree together with the tree head over all anchored transactions.
+	AppendAnchor(transactions []domain.Transaction, head domain.TreeHead) error
+	// Anchors returns the anchored transactions in tree order and the published tree heads.
+	Anchors() ([]domain.Transaction, []domain.TreeHead)
+}
+
+// Config configures a Log.
+type Config struct {
+	// Signer is the service key signing tree heads.
+	Signer crypto.Signer
+	// Clock is the time source of tree heads. A nil clock uses the system clock.
+	Clock domain.Clock
+	// Logger receives failures of periodic anchoring. A nil logger discards them.
+	Logger *slog.Logger
+}
+
+// InclusionProof proves that a transaction is the leaf at LeafIndex of the tree with TreeHead.
+type InclusionProof struct {
+	LeafIndex uint64
+	LeafHash  []byte
+	AuditPath [][]byte
+	TreeHead  domain.TreeHead
+}
+
+// Log is the anchoring tree over the transactions of a Store.
+type Log struct {
+	store  Store
+	signer crypto.Signer
+	clock  domain.Clock
+	logger *slog.Logger
+
+	mu     sync.RWMutex
+	tree   *merkle.Tree
+	leaves map[domain.AnchorRef]int
+	heads  []domain.TreeHead
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New rebuilds the tree from the transactions anchored in store and checks it against the last tree head.
+func New(store Store, config Config) (*Log, error) {
+	log := &Log{store: store, signer: config.Signer, clock: config.Clock, logger: config.Logger}
+	if log.clock == nil {
+		log.clock = domain.SystemClock{}
+	}
+	if log.logger == nil {
+		log.logger = logging.Discard()
+	}
+	if err := log.rebuild(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// rebuild loads the tree from the store. The caller must hold l.mu or be creating the log.
+func (l *Log) rebuild() error {
+	transactions, heads := l.store.Anchors()
+	l.tree = &merkle.Tree{}
+	l.leaves = make(map[domain.AnchorRef]int, len(transactions))
+	for _, transaction := range transactions {
+		l.append(transaction)
+	}
+	l.heads = heads
+	if len(heads) > 0 {
+		last := heads[len(heads)-1]
+		root, err := l.tree.RootAt(int(last.Size))
+		if err != nil || !bytes.Equal(root, last.RootHash) {
+			return fmt.Errorf("anchored transactions do not match tree head of size %d", last.Size)
+		}
+	}
+	return nil
+}
+
+func (l *Log) append(transaction domain.Transaction) {
+	l.leaves[domain.AnchorRef{DeviceId: transaction.DeviceId, Counter: transaction.Counter}] = l.tree.Size()
+	l.tree.Append(domain.AnchorLeaf(transaction))
+}
+
+// Anchor appends all transactions that are not anchored yet and publishes a new signed tree head. It returns false
+// and the current tree head, if any, if there was nothing to anchor.
+func (l *Log) Anchor() (domain.TreeHead, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pending := l.store.PendingAnchors()
+	if len(pending) == 0 {
+		head, _ := l.latest()
+		return head, false, nil
+	}
+
+	for _, transaction := range pending {
+		l.append(transaction)
+	}
+	root, _ := l.tree.RootAt(l.tree.Size())
+	head := domain.TreeHead{Size: uint64(l.tree.Size()), RootHash: root, Timestamp: l.clock.Now().UTC().Truncate(time.Millisecond)}
+	signature, err := l.signer.Sign(head.SignedData())
+	if err == nil {
+		head.Signature = signature
+		err = l.store.AppendAnchor(pending, head)
+	}
+	if err != nil {
+		// The tree already contains the pending transactions; drop them again.
+		if rebuildErr := l.rebuild(); rebuildErr != nil {
+			return domain.TreeHead{}, false, errors.Join(err, rebuildErr)
+		}
+		return domain.TreeHead{}, false, fmt.Errorf("anchoring %d transactions: %w", len(pending), err)
+	}
+	l.heads = append(l.heads, head)
+	return head, true, nil
+}
+
+// latest returns the last published tree head. The caller must hold l.mu.
+func (l *Log) latest() (domain.TreeHead, bool) {
+	if len(l.heads) == 0 {
+		return domain.TreeHead{}, false
+	}
+	return l.heads[len(l.heads)-1], true
+}
+
+// TreeHeads returns all published tree heads, the latest last.
+func (l *Log) TreeHeads() []domain.TreeHead {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	heads := make([]domain.TreeHead, len(l.heads))
+	copy(heads, l.heads)
+	return heads
+}
+
+// Signer returns the service key that signs tree heads.
+func (l *Log) Signer() crypto.Signer {
+	return l.signer
+}
+
+// InclusionProof proves the inclusion of a transaction in the latest tree head.
+func (l *Log) InclusionProof(transaction domain.Transaction) (InclusionProof, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	head, published := l.latest()
+	index, anchored := l.leaves[domain.AnchorRef{DeviceId: transaction.DeviceId, Counter: transaction.Counter}]
+	if !published || !anchored || uint64(index) >= head.Size {
+		return InclusionProof{}, ErrNotAnchored
+	}
+	leafHash, err := l.tree.LeafHashAt(index)
+	if err != nil {
+		return InclusionProof{}, err
+	}
+	if !bytes.Equal(leafHash, merkle.LeafHash(domain.AnchorLeaf(transaction))) {
+		return InclusionProof{}, fmt.Errorf("anchored leaf %d differs from the transaction", index)
+	}
+	auditPath, err := l.tree.InclusionProof(index, int(head.Size))
+	if err != nil {
+		return InclusionProof{}, err
+	}
+	return InclusionProof{LeafIndex: uint64(index), LeafHash: leafHash, AuditPath: auditPath, TreeHead: head}, nil
+}
+
+// ConsistencyProof proves that the tree head of size extends the tree head of oldSize. Both must be published.
+func (l *Log) ConsistencyProof(oldSize uint64, size uint64) ([][]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if !l.published(oldSize) || !l.published(size) || oldSize > size {
+		return nil, fmt.Errorf("%w: no tree heads of sizes %d and %d", ErrInvalidTreeSize, oldSize, size)
+	}
+	return l.tree.ConsistencyProof(int(oldSize), int(size))
+}
+
+// published reports whether there is a tree head of the given size. The caller must hold l.mu.
+func (l *Log) published(size uint64) bool {
+	for _, head := range l.heads {
+		if head.Size == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Run anchors new transactions every interval until Close is called.
+func (l *Log) Run(interval time.Duration) {
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, _, err := l.Anchor(); err != nil {
+					l.logger.Error("Anchoring transactions failed", "error", err)
+				}
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops periodic anchoring and anchors the transactions signed since the last run.
+func (l *Log) Close() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop = nil
+	}
+	_, _, err := l.Anchor()
+	return err
+}
@@ -0,0 +1,131 @@
+package anchor
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/merkle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+func createDevice(t *testing.T, db *persistence.InMemoryDB) *domain.SignatureDevice {
+	signer, _ := crypto.SignerFactory("ECC")
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "anchored", signer, domain.WithJournal(db))
+	if err := db.Set(device); err != nil {
+		t.Fatal(err)
+	}
+	return device
+}
+
+func verifyInclusion(t *testing.T, log *Log, transaction domain.Transaction) {
+	t.Helper()
+	proof, err := log.InclusionProof(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafHash := merkle.LeafHash(domain.AnchorLeaf(transaction))
+	if err := merkle.VerifyInclusion(leafHash, proof.LeafIndex, proof.TreeHead.Size, proof.AuditPath, proof.TreeHead.RootHash); err != nil {
+		t.Error("Inclusion proof of transaction", transaction.Counter, "does not verify:", err)
+	}
+}
+
+func TestLog_AnchorsTransactionsOfAllDevices(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	signer, _ := crypto.SignerFactory("ECC")
+	log, err := New(db, Config{Signer: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := createDevice(t, db), createDevice(t, db)
+	first.SignData([]byte("a"))
+	second.SignData([]byte("b"))
+	first.SignData([]byte("c"))
+
+	head, anchored, err := log.Anchor()
+	if err != nil || !anchored || head.Size != 3 {
+		t.Fatal("Expected a tree head over 3 transactions, got", head.Size, anchored, err)
+	}
+	if !signer.VerifySignature(head.SignedData(), head.Signature) {
+		t.Error("Tree head must be signed by the service key.")
+	}
+	transaction, _ := db.GetTransaction(domain.DefaultOrganizationId, first.Id, 1)
+	verifyInclusion(t, log, transaction)
+
+	second.SignData([]byte("d"))
+	if _, err := log.InclusionProof(domain.Transaction{DeviceId: second.Id, Counter: 1}); !errors.Is(err, ErrNotAnchored) {
+		t.Error("Transactions signed after the last tree head must not be anchored, got", err)
+	}
+	next, anchored, err := log.Anchor()
+	if err != nil || !anchored || next.Size != 4 {
+		t.Fatal("Expected a tree head over 4 transactions, got", next.Size, anchored, err)
+	}
+	proof, err := log.ConsistencyProof(head.Size, next.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := merkle.VerifyConsistency(head.Size, next.Size, head.RootHash, next.RootHash, proof); err != nil {
+		t.Error("Consistency proof does not verify:", err)
+	}
+	if _, err := log.ConsistencyProof(2, 4); !errors.Is(err, ErrInvalidTreeSize) {
+		t.Error("Consistency proofs must be between published tree heads, got", err)
+	}
+
+	if _, anchored, _ := log.Anchor(); anchored {
+		t.Error("Anchoring without new transactions must not publish a tree head.")
+	}
+	if len(log.TreeHeads()) != 2 {
+		t.Error("Expected 2 tree heads, got", len(log.TreeHeads()))
+	}
+}
+
+func TestLog_RebuildsFromLog(t *testing.T) {
+	dir := t.TempDir()
+	db, err := persistence.OpenInMemoryDB(persistence.WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := LoadKey(filepath.Join(dir, "anchor.pem"), "ECC", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, _ := New(db, Config{Signer: signer})
+	device := createDevice(t, db)
+	device.SignData([]byte("before snapshot"))
+	log.Anchor()
+	if err := db.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	device.SignData([]byte("after snapshot"))
+	head, _, _ := log.Anchor()
+
+	// The first database is not closed, as if the process crashed.
+	replayed, err := persistence.OpenInMemoryDB(persistence.WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+	reloadedSigner, err := LoadKey(filepath.Join(dir, "anchor.pem"), "ECC", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := New(replayed, Config{Signer: reloadedSigner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	heads := rebuilt.TreeHeads()
+	if len(heads) != 2 || !bytes.Equal(heads[1].RootHash, head.RootHash) {
+		t.Fatal("Tree heads must be restored, got", heads)
+	}
+	if !reloadedSigner.VerifySignature(heads[1].SignedData(), heads[1].Signature) {
+		t.Error("Service key must be reloaded from its file.")
+	}
+	transaction, _ := replayed.GetTransaction(domain.DefaultOrganizationId, device.Id, 0)
+	verifyInclusion(t, rebuilt, transaction)
+	if _, anchored, _ := rebuilt.Anchor(); anchored {
+		t.Error("Restored transactions must not be anchored twice.")
+	}
+}
@@ -0,0 +1,281 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/auth"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// APIKeyRequest holds the scope of an API key to be created or updated.
+// OrganizationId defaults to the organization of the requesting key; only operators may set another one.
+type APIKeyRequest struct {
+	OrganizationId *uuid.UUID  `json:"organization_id,omitempty"`
+	Name           string      `json:"name"`
+	Permissions    []string    `json:"permissions"`
+	AllDevices     bool        `json:"all_devices"`
+	Devices        []uuid.UUID `json:"devices"`
+}
+
+// APIKeyResponse describes an API key. Secret is only set in the response to its creation.
+type APIKeyResponse struct {
+	Id             uuid.UUID   `json:"id"`
+	OrganizationId uuid.UUID   `json:"organization_id"`
+	Name           string      `json:"name"`
+	Permissions    []string    `json:"permissions"`
+	AllDevices     bool        `json:"all_devices"`
+	Devices        []uuid.UUID `json:"devices"`
+	CreatedAt      time.Time   `json:"created_at"`
+	Secret         string      `json:"secret,omitempty"`
+}
+
+// APIKeys handles listing (GET) and creation (POST) of API keys of an organization.
+// Operators may list the keys of another organization with the organization_id query parameter.
+func (s *Server) APIKeys(response http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		organization := organizationId(request)
+		if query := request.URL.Query().Get("organization_id"); query != "" && isOperator(request) {
+			id, err := uuid.Parse(query)
+			if err != nil {
+				http.Error(response, "Invalid organization ID", http.StatusBadRequest)
+				return
+			}
+			organization = id
+		}
+
+		var keysResponse []APIKeyResponse
+		for _, key := range s.keys.GetAll(organization) {
+			keysResponse = append(keysResponse, newAPIKeyResponse(key))
+		}
+		WriteAPIResponse(response, http.StatusOK, keysResponse)
+	case http.MethodPost:
+		requestData, permissions, ok := decodeAPIKeyRequest(response, request)
+		if !ok {
+			return
+		}
+		organization := organizationId(request)
+		if requestData.OrganizationId != nil && *requestData.OrganizationId != organization {
+			if !isOperator(request) {
+				s.audit(request, organization, ActionAPIKeyCreate, "", audit.OutcomeDenied)
+				WriteErrorResponse(response, http.StatusForbidden, []string{
+					"Only operators may create API keys for other organizations.",
+				})
+				return
+			}
+			organization = *requestData.OrganizationId
+		}
+		if !s.checkKeyScope(response, organization, requestData.Devices) {
+			return
+		}
+
+		key, secret, err := s.keys.Create(auth.APIKey{
+			OrganizationId: organization,
+			Name:           requestData.Name,
+			Permissions:    permissions,
+			AllDevices:     requestData.AllDevices,
+			DeviceIds:      requestData.Devices,
+		})
+		if err != nil {
+			s.requestLogger(request).Error("Creating API key failed", "error", err)
+			s.audit(request, organization, ActionAPIKeyCreate, "", audit.OutcomeFailure)
+			WriteInternalError(response)
+			return
+		}
+		s.audit(request, organization, ActionAPIKeyCreate, apiKeyTarget(key.Id), audit.OutcomeSuccess)
+		keyResponse := newAPIKeyResponse(key)
+		keyResponse.Secret = secret
+		WriteAPIResponse(response, http.StatusOK, keyResponse)
+	default:
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+	}
+}
+
+// APIKey handles retrieval (GET), update of the scope (PUT) and revocation (DELETE) of one API key.
+// Keys of other organizations are reported as not found, unless the request is made by an operator.
+func (s *Server) APIKey(response http.ResponseWriter, request *http.Request) {
+	id, err := uuid.Parse(strings.TrimPrefix(request.URL.Path, "/api/v0/admin/keys/"))
+	if err != nil {
+		http.Error(response, "Invalid key ID", http.StatusBadRequest)
+		return
+	}
+	key, exists := s.keys.Get(id)
+	if !exists || (key.OrganizationId != organizationId(request) && !isOperator(request)) {
+		writeKeyNotFound(response)
+		return
+	}
+
+	switch request.Method {
+	case http.MethodGet:
+		WriteAPIResponse(response, http.StatusOK, newAPIKeyResponse(key))
+	case http.MethodPut:
+		requestData, permissions, ok := decodeAPIKeyRequest(response, request)
+		if !ok {
+			return
+		}
+		if !s.checkKeyScope(response, key.OrganizationId, requestData.Devices) {
+			return
+		}
+		updated, err := s.keys.Update(id, permissions, requestData.AllDevices, requestData.Devices)
+		if errors.Is(err, auth.ErrKeyNotFound) {
+			writeKeyNotFound(response)
+			return
+		}
+		if err != nil {
+			s.requestLogger(request).Error("Updating API key failed", "api_key_id", id, "error", err)
+			s.audit(request, key.OrganizationId, ActionAPIKeyUpdate, apiKeyTarget(id), audit.OutcomeFailure)
+			WriteInternalError(response)
+			return
+		}
+		s.audit(request, updated.OrganizationId, ActionAPIKeyUpdate, apiKeyTarget(updated.Id), audit.OutcomeSuccess)
+		WriteAPIResponse(response, http.StatusOK, newAPIKeyResponse(updated))
+	case http.MethodDelete:
+		err := s.keys.Delete(id)
+		if errors.Is(err, auth.ErrKeyNotFound) {
+			writeKeyNotFound(response)
+			return
+		}
+		if err != nil {
+			s.requestLogger(request).Error("Revoking API key failed", "api_key_id", id, "error", err)
+			s.audit(request, key.OrganizationId, ActionAPIKeyDelete, apiKeyTarget(id), audit.OutcomeFailure)
+			WriteInternalError(response)
+			return
+		}
+		s.audit(request, key.OrganizationId, ActionAPIKeyDelete, apiKeyTarget(key.Id), audit.OutcomeSuccess)
+		response.WriteHeader(http.StatusNoContent)
+	default:
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+	}
+}
+
+func decodeAPIKeyRequest(response http.ResponseWriter, request *http.Request) (APIKeyRequest, []auth.Permission, bool) {
+	var requestData APIKeyRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestData); err != nil {
+		http.Error(response, "Failed to decode Request", http.StatusBadRequest)
+		return requestData, nil, false
+	}
+
+	var permissions []auth.Permission
+	for _, name := range requestData.Permissions {
+		permission, err := auth.ParsePermission(name)
+		if err != nil {
+			WriteErrorResponse(response, http.StatusBadRequest, []string{err.Error()})
+			return requestData, nil, false
+		}
+		permissions = append(permissions, permission)
+	}
+	if len(permissions) == 0 {
+		WriteErrorResponse(response, http.StatusBadRequest, []string{"At least one permission is required."})
+		return requestData, nil, false
+	}
+	return requestData, permissions, true
+}
+
+// checkKeyScope verifies that the organization exists and owns all devices an API key is scoped to.
+func (s *Server) checkKeyScope(response http.ResponseWriter, organization uuid.UUID, deviceIds []uuid.UUID) bool {
+	if _, exists := s.db.GetOrganization(organization); !exists {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"No organization found under provided id.",
+		})
+		return false
+	}
+	for _, deviceId := range deviceIds {
+		if _, exists := s.db.Get(organization, deviceId); !exists {
+			WriteErrorResponse(response, http.StatusBadRequest, []string{
+				"No device " + deviceId.String() + " found in organization.",
+			})
+			return false
+		}
+	}
+	return true
+}
+
+func newAPIKeyResponse(key *auth.APIKey) APIKeyResponse {
+	permissions := make([]string, 0, len(key.Permissions))
+	for _, permission := range key.Permissions {
+		permissions = append(permissions, string(permission))
+	}
+	return APIKeyResponse{
+		Id:             key.Id,
+		OrganizationId: key.OrganizationId,
+		Name:           key.Name,
+		Permissions:    permissions,
+		AllDevices:     key.AllDevices,
+		Devices:        key.DeviceIds,
+		CreatedAt:      key.CreatedAt,
+	}
+}
+
+// apiKeyTarget names an API key as target of audited actions.
+func apiKeyTarget(id uuid.UUID) string {
+	return "api_key:" + id.String()
+}
+
+func writeKeyNotFound(response http.ResponseWriter) {
+	WriteErrorResponse(response, http.StatusNotFound, []string{
+		"No API key found under provided id.",
+	})
+}
+
+// OrganizationRequest holds the data needed to create an organization.
+type OrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+// OrganizationResponse describes an organization.
+type OrganizationResponse struct {
+	Id   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+// Organizations handles listing (GET) and creation (POST) of organizations. Only operators may manage organizations.
+func (s *Server) Organizations(response http.ResponseWriter, request *http.Request) {
+	if !isOperator(request) {
+		WriteErrorResponse(response, http.StatusForbidden, []string{
+			"Only operators may manage organizations.",
+		})
+		return
+	}
+
+	switch request.Method {
+	case http.MethodGet:
+		var organizationsResponse []OrganizationResponse
+		for _, organization := range s.db.GetOrganizations() {
+			organizationsResponse = append(organizationsResponse, OrganizationResponse{organization.Id, organization.Name})
+		}
+		WriteAPIResponse(response, http.StatusOK, organizationsResponse)
+	case http.MethodPost:
+		var requestData OrganizationRequest
+		if err := json.NewDecoder(request.Body).Decode(&requestData); err != nil {
+			http.Error(response, "Failed to decode Request", http.StatusBadRequest)
+			return
+		}
+		if requestData.Name == "" {
+			WriteErrorResponse(response, http.StatusBadRequest, []string{"Organization name is required."})
+			return
+		}
+		organization := domain.NewOrganization(requestData.Name)
+		if err := s.db.SetOrganization(organization); err != nil {
+			s.requestLogger(request).Error("Storing organization failed", "error", err)
+			s.audit(request, organizationId(request), ActionOrganizationCreate, "", audit.OutcomeFailure)
+			WriteInternalError(response)
+			return
+		}
+		s.audit(request, organizationId(request), ActionOrganizationCreate, "organization:"+organization.Id.String(), audit.OutcomeSuccess)
+		WriteAPIResponse(response, http.StatusOK, OrganizationResponse{organization.Id, organization.Name})
+	default:
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+	}
+}
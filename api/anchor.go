@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/anchor"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// WithAnchoring serves inclusion and consistency proofs of the anchoring log. The log is closed on shutdown,
+// which anchors the transactions signed since its last run.
+func WithAnchoring(log *anchor.Log) Option {
+	return func(s *Server) {
+		s.anchors = log
+		s.RegisterOnShutdown(func(ctx context.Context) error {
+			return log.Close()
+		})
+	}
+}
+
+// TreeHeadResponse is a root of the anchoring tree over the first Size anchored transactions. Signature is the
+// signature of the service key over tree-head:v1:<size>:<timestamp>:<base64 root hash>.
+type TreeHeadResponse struct {
+	Size      uint64    `json:"size"`
+	RootHash  []byte    `json:"root_hash"`
+	Timestamp time.Time `json:"timestamp"`
+	Signature []byte    `json:"signature"`
+}
+
+func newTreeHeadResponse(head domain.TreeHead) TreeHeadResponse {
+	return TreeHeadResponse{Size: head.Size, RootHash: head.RootHash, Timestamp: head.Timestamp, Signature: head.Signature}
+}
+
+// AnchorsResponse lists the published tree heads, the latest last, with the service key that signed them.
+type AnchorsResponse struct {
+	Algorithm string             `json:"algorithm"`
+	PublicKey string             `json:"public_key"`
+	TreeHeads []TreeHeadResponse `json:"tree_heads"`
+}
+
+// InclusionProofResponse proves that a transaction is the leaf at LeafIndex of the tree with TreeHead.
+// LeafHash is the hash of the leaf of the transaction, see domain.AnchorLeaf.
+type InclusionProofResponse struct {
+	LeafIndex uint64           `json:"leaf_index"`
+	LeafHash  []byte           `json:"leaf_hash"`
+	AuditPath [][]byte         `json:"audit_path"`
+	TreeHead  TreeHeadResponse `json:"tree_head"`
+}
+
+// ConsistencyProofResponse proves that the tree of size Second extends the tree of size First.
+type ConsistencyProofResponse struct {
+	First  uint64   `json:"first"`
+	Second uint64   `json:"second"`
+	Proof  [][]byte `json:"proof"`
+}
+
+// writeAnchoringDisabled responds to proof requests of a server without anchoring.
+func writeAnchoringDisabled(response http.ResponseWriter) {
+	WriteErrorResponse(response, http.StatusNotFound, []string{
+		"Anchoring is not enabled.",
+	})
+}
+
+// Anchors handles a request for the published tree heads and the service key.
+func (s *Server) Anchors(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(s.anchors.Signer().GetPublicKey())
+	if err != nil {
+		s.requestLogger(request).Error("Encoding service key failed", "error", err)
+		WriteInternalError(response)
+		return
+	}
+	anchorsResponse := AnchorsResponse{
+		Algorithm: s.anchors.Signer().GetAlgorithm(),
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+		TreeHeads: []TreeHeadResponse{},
+	}
+	for _, head := range s.anchors.TreeHeads() {
+		anchorsResponse.TreeHeads = append(anchorsResponse.TreeHeads, newTreeHeadResponse(head))
+	}
+	WriteAPIResponse(response, http.StatusOK, anchorsResponse)
+}
+
+// ConsistencyProof handles a request for the proof that the tree head of size second extends the one of size
+// first. Without second, the latest tree head is used.
+func (s *Server) ConsistencyProof(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	query := request.URL.Query()
+	first, err := strconv.ParseUint(query.Get("first"), 10, 64)
+	if err != nil {
+		WriteErrorResponse(response, http.StatusBadRequest, []string{"Invalid tree size first."})
+		return
+	}
+	var second uint64
+	if query.Has("second") {
+		if second, err = strconv.ParseUint(query.Get("second"), 10, 64); err != nil {
+			WriteErrorResponse(response, http.StatusBadRequest, []string{"Invalid tree size second."})
+			return
+		}
+	} else if heads := s.anchors.TreeHeads(); len(heads) > 0 {
+		second = heads[len(heads)-1].Size
+	}
+
+	proof, err := s.anchors.ConsistencyProof(first, second)
+	if errors.Is(err, anchor.ErrInvalidTreeSize) {
+		WriteErrorResponse(response, http.StatusNotFound, []string{"No tree heads of the given sizes."})
+		return
+	}
+	if err != nil {
+		s.requestLogger(request).Error("Creating consistency proof failed", "error", err)
+		WriteInternalError(response)
+		return
+	}
+	WriteAPIResponse(response, http.StatusOK, ConsistencyProofResponse{First: first, Second: second, Proof: proof})
+}
+
+// InclusionProof handles a request for the proof that a transaction of a device is included in the latest
+// tree head.
+func (s *Server) InclusionProof(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+	if s.anchors == nil {
+		writeAnchoringDisabled(response)
+		return
+	}
+
+	id, counter, err := parseTransactionPath(request.URL.Path, "/proof")
+	if err != nil {
+		http.Error(response, "Invalid device ID or counter", http.StatusBadRequest)
+		return
+	}
+	device, exists := s.db.Get(organizationId(request), id)
+	if !exists {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"No device found under provided id.",
+		})
+		return
+	}
+	if !canAccessDevice(request, device.Id) {
+		writeDeviceForbidden(response)
+		return
+	}
+	transaction, exists := s.db.GetTransaction(device.OrganizationId, device.Id, counter)
+	if !exists {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"No transaction found under provided counter.",
+		})
+		return
+	}
+
+	proof, err := s.anchors.InclusionProof(transaction)
+	if errors.Is(err, anchor.ErrNotAnchored) {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"Transaction is not anchored yet, please retry after the next anchoring.",
+		})
+		return
+	}
+	if err != nil {
+		s.requestLogger(request).Error("Creating inclusion proof failed", "device_id", device.Id, "counter", counter, "error", err)
+		WriteInternalError(response)
+		return
+	}
+	WriteAPIResponse(response, http.StatusOK, InclusionProofResponse{
+		LeafIndex: proof.LeafIndex,
+		LeafHash:  proof.LeafHash,
+		AuditPath: proof.AuditPath,
+		TreeHead:  newTreeHeadResponse(proof.TreeHead),
+	})
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/anchor"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/merkle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+func TestAnchor_InclusionAndConsistencyProofs(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	signer, _ := crypto.SignerFactory("ECC")
+	anchors, err := anchor.New(db, anchor.Config{Signer: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewServer(":8080", db, WithAnchoring(anchors)).Handler())
+	defer ts.Close()
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC"}, &deviceResponse)
+	for _, data := range []string{"a", "b", "c"} {
+		sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: deviceResponse.Id, Data: data}, nil)
+	}
+	proofURL := func(counter int) string {
+		return ts.URL + "/api/v0/devices/" + deviceResponse.Id.String() + "/transactions/" + strconv.Itoa(counter) + "/proof"
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodGet, proofURL(0), "", nil, nil); code != http.StatusNotFound {
+		t.Error("Transactions must not be proven before they are anchored, got", code)
+	}
+	first, _, _ := anchors.Anchor()
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: deviceResponse.Id, Data: "d"}, nil)
+	anchors.Anchor()
+
+	var proof InclusionProofResponse
+	if code := sendAuthenticatedRequest(t, http.MethodGet, proofURL(1), "", nil, &proof); code != http.StatusOK {
+		t.Fatal("Expected an inclusion proof, got", code)
+	}
+	transaction, _ := db.GetTransaction(domain.DefaultOrganizationId, deviceResponse.Id, 1)
+	leafHash := merkle.LeafHash(domain.AnchorLeaf(transaction))
+	if err := merkle.VerifyInclusion(leafHash, proof.LeafIndex, proof.TreeHead.Size, proof.AuditPath, proof.TreeHead.RootHash); err != nil {
+		t.Error("Inclusion proof does not verify:", err)
+	}
+	head := domain.TreeHead{Size: proof.TreeHead.Size, RootHash: proof.TreeHead.RootHash, Timestamp: proof.TreeHead.Timestamp}
+	if proof.TreeHead.Size != 4 || !signer.VerifySignature(head.SignedData(), proof.TreeHead.Signature) {
+		t.Error("Proof must be against the latest signed tree head, got size", proof.TreeHead.Size)
+	}
+
+	var anchorsResponse AnchorsResponse
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/anchors", "", nil, &anchorsResponse)
+	if len(anchorsResponse.TreeHeads) != 2 || anchorsResponse.Algorithm != "ECC" || anchorsResponse.PublicKey == "" {
+		t.Fatal("Expected 2 tree heads with the service key, got", anchorsResponse)
+	}
+	var consistency ConsistencyProofResponse
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/anchors/consistency?first=3", "", nil, &consistency)
+	latest := anchorsResponse.TreeHeads[1]
+	if err := merkle.VerifyConsistency(consistency.First, consistency.Second, first.RootHash, latest.RootHash, consistency.Proof); err != nil {
+		t.Error("Consistency proof does not verify:", err)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/anchors/consistency?first=2&second=4", "", nil, nil); code != http.StatusNotFound {
+		t.Error("Consistency proofs must be between published tree heads, got", code)
+	}
+}
+
+func TestAnchor_Disabled(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	ts := httptest.NewServer(NewServer(":8080", db).Handler())
+	defer ts.Close()
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC"}, &deviceResponse)
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: deviceResponse.Id, Data: "a"}, nil)
+	proofURL := ts.URL + "/api/v0/devices/" + deviceResponse.Id.String() + "/transactions/0/proof"
+	if code := sendAuthenticatedRequest(t, http.MethodGet, proofURL, "", nil, nil); code != http.StatusNotFound {
+		t.Error("Proofs must not be found without anchoring, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/anchors", "", nil, nil); code != http.StatusNotFound {
+		t.Error("Tree heads must not be found without anchoring, got", code)
+	}
+}
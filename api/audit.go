@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/auth"
+	"github.com/google/uuid"
+)
+
+// Audited actions.
+const (
+	ActionDeviceCreate            = "device.create"
+	ActionDeviceSign              = "device.sign"
+	ActionDeviceExport            = "device.export"
+	ActionDeviceBackup            = "device.backup"
+	ActionDeviceRestore           = "device.restore"
+	ActionDeviceCertify           = "device.certify"
+	ActionDeviceAttachCertificate = "device.attach_certificate"
+	ActionDeviceRotate            = "device.rotate"
+	ActionDeviceSuspend           = "device.suspend"
+	ActionDeviceResume            = "device.resume"
+	ActionAPIKeyCreate            = "api_key.create"
+	ActionAPIKeyUpdate            = "api_key.update"
+	ActionAPIKeyDelete            = "api_key.delete"
+	ActionOrganizationCreate      = "organization.create"
+)
+
+// WithAuditLog records all mutating requests in log instead of a log private to the server.
+func WithAuditLog(log *audit.Log) Option {
+	return func(s *Server) {
+		s.auditLog = log
+	}
+}
+
+// audit appends an entry for an action of the request to the audit chain of the organization.
+func (s *Server) audit(request *http.Request, organization uuid.UUID, action string, target string, outcome string) {
+	_, err := s.auditLog.Append(audit.Entry{
+		OrganizationId: organization,
+		Actor:          actor(request),
+		Action:         action,
+		Target:         target,
+		Outcome:        outcome,
+		RequestId:      RequestID(request.Context()),
+	})
+	if err != nil {
+		s.requestLogger(request).Error("Recording audit entry failed", "action", action, "target", target, "outcome", outcome, "error", err)
+	}
+}
+
+// actor identifies who made a request: the API key, otherwise the verified client certificate.
+func actor(request *http.Request) string {
+	if key, ok := auth.FromContext(request.Context()); ok {
+		return "api_key:" + key.Id.String()
+	}
+	if subject, ok := ClientSubject(request.Context()); ok {
+		return "certificate:" + subject.String()
+	}
+	return "anonymous"
+}
+
+// AuditLog handles queries of the audit log of an organization. Entries can be filtered with the
+// action, target, since, until (RFC 3339) and limit query parameters. Operators may select another
+// organization with organization_id. An unfiltered result is a complete chain that can be checked with audit.Verify.
+func (s *Server) AuditLog(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	query := request.URL.Query()
+	organization := organizationId(request)
+	filter := audit.Filter{
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+	}
+
+	var errs []string
+	if value := query.Get("organization_id"); value != "" && isOperator(request) {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			errs = append(errs, "Invalid organization_id.")
+		}
+		organization = id
+	}
+	if value := query.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			errs = append(errs, "Invalid since, expected RFC 3339 timestamp.")
+		}
+		filter.Since = since
+	}
+	if value := query.Get("until"); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			errs = append(errs, "Invalid until, expected RFC 3339 timestamp.")
+		}
+		filter.Until = until
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			errs = append(errs, "Invalid limit.")
+		}
+		filter.Limit = limit
+	}
+	if len(errs) > 0 {
+		WriteErrorResponse(response, http.StatusBadRequest, errs)
+		return
+	}
+
+	entries := s.auditLog.Query(organization, filter)
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+	WriteAPIResponse(response, http.StatusOK, entries)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+)
+
+func TestAudit_RecordsMutatingRequests(t *testing.T) {
+	ts := initAuthenticatedServer(t)
+	tenantKey := createOrganizationKey(t, ts.URL, "Tenant A", "create", "sign", "read", "admin")
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", tenantKey.Secret, SignatureDeviceRequest{Algorithm: "ECC", Label: "A"}, &device)
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", tenantKey.Secret, SignDataRequest{Id: device.Id, Data: "data"}, nil)
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices", tenantKey.Secret, nil, nil)
+
+	var entries []audit.Entry
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/audit", tenantKey.Secret, nil, &entries); code != http.StatusOK {
+		t.Fatal("Audit log query failed, got", code)
+	}
+	if len(entries) != 3 {
+		t.Fatal("Expected entries for key creation, device creation and signing only, got", len(entries))
+	}
+	if entries[0].Action != ActionAPIKeyCreate || entries[0].Target != "api_key:"+tenantKey.Id.String() {
+		t.Error("Key creation by the operator must be audited in the tenant's chain.")
+	}
+	if entries[1].Action != ActionDeviceCreate || entries[2].Action != ActionDeviceSign {
+		t.Error("Wrong actions recorded:", entries[1].Action, entries[2].Action)
+	}
+	for _, entry := range entries[1:] {
+		if entry.Actor != "api_key:"+tenantKey.Id.String() || entry.Target != "device:"+device.Id.String() ||
+			entry.Outcome != audit.OutcomeSuccess || entry.RequestId == "" {
+			t.Error("Audit entry incomplete:", entry)
+		}
+	}
+	if err := audit.Verify(entries); err != nil {
+		t.Error("Audit chain must verify:", err)
+	}
+
+	var signs []audit.Entry
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/audit?action=device.sign", tenantKey.Secret, nil, &signs)
+	if len(signs) != 1 {
+		t.Error("Audit query filter failed.")
+	}
+
+	var operatorEntries []audit.Entry
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/audit", adminSecret, nil, &operatorEntries)
+	for _, entry := range operatorEntries {
+		if entry.Target == "device:"+device.Id.String() {
+			t.Error("Audit entries of tenants must not appear in the operator's chain.")
+		}
+	}
+	if len(operatorEntries) != 1 || operatorEntries[0].Action != ActionOrganizationCreate {
+		t.Error("Operator actions must be audited in the default organization.")
+	}
+}
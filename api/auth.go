@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/auth"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// WithAuthentication requires every request except the health check to carry an API key from keys.
+func WithAuthentication(keys *auth.KeyStore) Option {
+	return func(s *Server) {
+		s.keys = keys
+	}
+}
+
+// authenticate rejects requests without a valid API key granting the permission
+// before they reach the handler. The key is available to the handler through auth.FromContext.
+// Without a configured KeyStore all requests are passed through.
+func (s *Server) authenticate(permission auth.Permission, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if s.keys == nil {
+			handler(response, request)
+			return
+		}
+
+		secret := apiKeyFromRequest(request)
+		key, ok := s.keys.Authenticate(secret)
+		if secret == "" || !ok {
+			response.Header().Set("WWW-Authenticate", `Bearer realm="signing-service"`)
+			WriteErrorResponse(response, http.StatusUnauthorized, []string{
+				"Missing or invalid API key.",
+			})
+			return
+		}
+		if !key.HasPermission(permission) {
+			WriteErrorResponse(response, http.StatusForbidden, []string{
+				"API key lacks permission " + string(permission) + ".",
+			})
+			return
+		}
+
+		handler(response, request.WithContext(auth.NewContext(request.Context(), key)))
+	})
+}
+
+// apiKeyFromRequest reads the API key from the Authorization bearer token or the X-API-Key header.
+func apiKeyFromRequest(request *http.Request) string {
+	if header := request.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return request.Header.Get("X-API-Key")
+}
+
+// canAccessDevice reports whether the API key of the request is scoped to the device.
+// Requests to a server without authentication may access every device.
+func canAccessDevice(request *http.Request, id uuid.UUID) bool {
+	key, ok := auth.FromContext(request.Context())
+	return !ok || key.CanAccessDevice(id)
+}
+
+// organizationId returns the tenant of the request, which is the organization of its API key.
+// Requests to a server without authentication belong to the default organization.
+func organizationId(request *http.Request) uuid.UUID {
+	if key, ok := auth.FromContext(request.Context()); ok {
+		return key.OrganizationId
+	}
+	return domain.DefaultOrganizationId
+}
+
+// isOperator reports whether the request is made with an admin key of the default organization,
+// which may manage all organizations and their API keys.
+func isOperator(request *http.Request) bool {
+	key, ok := auth.FromContext(request.Context())
+	return ok && key.OrganizationId == domain.DefaultOrganizationId && key.HasPermission(auth.PermissionAdmin)
+}
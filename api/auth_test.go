@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/auth"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+)
+
+const adminSecret = "admin-secret-for-tests"
+
+func initAuthenticatedServer(t *testing.T, options ...Option) *httptest.Server {
+	keys := auth.NewKeyStore()
+	if _, err := keys.Add(auth.APIKey{Permissions: []auth.Permission{auth.PermissionAdmin}}, adminSecret); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(":8080", persistence.GetInMemoryDB(), append(options, WithAuthentication(keys))...)
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func sendAuthenticatedRequest(t *testing.T, method string, url string, secret string, body interface{}, data interface{}) int {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest(method, url, bytes.NewBuffer(payload))
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if data != nil {
+		var response Response
+		_ = json.NewDecoder(res.Body).Decode(&response)
+		dataBytes, _ := json.Marshal(response.Data)
+		_ = json.Unmarshal(dataBytes, data)
+	}
+	return res.StatusCode
+}
+
+func TestAuth_RejectsMissingCredentials(t *testing.T) {
+	ts := initAuthenticatedServer(t)
+
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices", "", nil, nil); code != http.StatusUnauthorized {
+		t.Error("Requests without API key must be rejected, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices", "wrong", nil, nil); code != http.StatusUnauthorized {
+		t.Error("Requests with invalid API key must be rejected, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/health", "", nil, nil); code != http.StatusOK {
+		t.Error("Health check must not require authentication, got", code)
+	}
+}
+
+func TestAuth_ScopedKeys(t *testing.T) {
+	ts := initAuthenticatedServer(t)
+
+	var otherDevice SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", adminSecret, SignatureDeviceRequest{Algorithm: "ECC", Label: "other"}, &otherDevice)
+
+	var terminalKey APIKeyResponse
+	code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/admin/keys", adminSecret, APIKeyRequest{
+		Name:        "terminal",
+		Permissions: []string{"create", "sign", "read"},
+	}, &terminalKey)
+	if code != http.StatusOK || terminalKey.Secret == "" {
+		t.Fatal("Admin must be able to create API keys, got", code)
+	}
+
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/admin/keys", terminalKey.Secret, nil, nil); code != http.StatusForbidden {
+		t.Error("Admin endpoints require admin permission, got", code)
+	}
+
+	var ownDevice SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", terminalKey.Secret, SignatureDeviceRequest{Algorithm: "ECC", Label: "own"}, &ownDevice)
+
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", terminalKey.Secret, SignDataRequest{Id: ownDevice.Id, Data: "data"}, nil); code != http.StatusOK {
+		t.Error("Keys must be able to sign with devices they created, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", terminalKey.Secret, SignDataRequest{Id: otherDevice.Id, Data: "data"}, nil); code != http.StatusForbidden {
+		t.Error("Keys must not sign with devices outside their scope, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices/"+otherDevice.Id.String(), terminalKey.Secret, nil, nil); code != http.StatusForbidden {
+		t.Error("Keys must not read devices outside their scope, got", code)
+	}
+
+	var devices []SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices", terminalKey.Secret, nil, &devices)
+	if len(devices) != 1 || devices[0].Id != ownDevice.Id {
+		t.Error("Device list must only contain devices in scope.")
+	}
+
+	var readOnlyKey APIKeyResponse
+	sendAuthenticatedRequest(t, http.MethodPut, ts.URL+"/api/v0/admin/keys/"+terminalKey.Id.String(), adminSecret, APIKeyRequest{
+		Permissions: []string{"read"},
+		Devices:     []uuid.UUID{ownDevice.Id},
+	}, &readOnlyKey)
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", terminalKey.Secret, SignDataRequest{Id: ownDevice.Id, Data: "data"}, nil); code != http.StatusForbidden {
+		t.Error("Keys without sign permission must not sign, got", code)
+	}
+
+	if code := sendAuthenticatedRequest(t, http.MethodDelete, ts.URL+"/api/v0/admin/keys/"+terminalKey.Id.String(), adminSecret, nil, nil); code != http.StatusNoContent {
+		t.Error("Admin must be able to revoke keys, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices", terminalKey.Secret, nil, nil); code != http.StatusUnauthorized {
+		t.Error("Revoked keys must be rejected, got", code)
+	}
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/backup"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+)
+
+// WithBackupKey enables the backup and restore endpoints. Bundles are sealed with key,
+// so every instance a device is migrated between must be configured with the same key.
+func WithBackupKey(key []byte) Option {
+	return func(s *Server) {
+		s.backupKey = key
+	}
+}
+
+// BackupDevice handles a request for a sealed bundle of a device at path /api/v0/admin/devices/{id}/backup.
+// Operators may select a device of another organization with organization_id. The bundle moves the device: it is
+// marked as migrated and refuses to sign, so that the counters in the bundle are only used by the instance it is
+// restored on.
+func (s *Server) BackupDevice(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	path := strings.TrimPrefix(request.URL.Path, "/api/v0/admin/devices/")
+	if !strings.HasSuffix(path, "/backup") {
+		http.NotFound(response, request)
+		return
+	}
+	id, err := uuid.Parse(strings.TrimSuffix(path, "/backup"))
+	if err != nil {
+		http.Error(response, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+	organization := organizationId(request)
+	if value := request.URL.Query().Get("organization_id"); value != "" && isOperator(request) {
+		if organization, err = uuid.Parse(value); err != nil {
+			http.Error(response, "Invalid organization ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	device, exists := s.db.Get(organization, id)
+	if !exists {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"No device found under provided id.",
+		})
+		return
+	}
+	if !canAccessDevice(request, device.Id) {
+		s.audit(request, device.OrganizationId, ActionDeviceBackup, deviceTarget(device.Id), audit.OutcomeDenied)
+		writeDeviceForbidden(response)
+		return
+	}
+
+	// The committed state is read before the journal, which always covers it.
+	state, err := s.db.DeviceState(device.Id)
+	var bundle *backup.Bundle
+	if err == nil {
+		var journal []domain.Transaction
+		for _, transaction := range s.db.GetTransactions(device.OrganizationId, device.Id) {
+			if transaction.Counter < state.Counter {
+				journal = append(journal, transaction)
+			}
+		}
+		bundle, err = backup.Seal(device, state, journal, s.backupKey, time.Now())
+	}
+	if err != nil {
+		s.requestLogger(request).Error("Sealing device backup failed", "device", device, "error", err)
+		s.audit(request, device.OrganizationId, ActionDeviceBackup, deviceTarget(device.Id), audit.OutcomeFailure)
+		WriteInternalError(response)
+		return
+	}
+	// Migrating fails if the device signed since its state was read, so the bundle always holds the final state.
+	if err := s.db.MigrateDevice(device.Id, state.Version); err != nil {
+		s.audit(request, device.OrganizationId, ActionDeviceBackup, deviceTarget(device.Id), audit.OutcomeFailure)
+		switch {
+		case errors.Is(err, domain.ErrMigrated):
+			WriteErrorResponse(response, http.StatusConflict, []string{
+				"Device has already been migrated to another instance.",
+			})
+		case errors.Is(err, domain.ErrVersionConflict):
+			WriteErrorResponse(response, http.StatusConflict, []string{
+				"Device is busy, please retry.",
+			})
+		case errors.Is(err, domain.ErrSuspended):
+			WriteErrorResponse(response, http.StatusConflict, []string{
+				"Device is suspended.",
+			})
+		default:
+			s.requestLogger(request).Error("Migrating device failed", "device", device, "error", err)
+			WriteInternalError(response)
+		}
+		return
+	}
+	s.audit(request, device.OrganizationId, ActionDeviceBackup, deviceTarget(device.Id), audit.OutcomeSuccess)
+	WriteAPIResponse(response, http.StatusOK, bundle)
+}
+
+var errOtherKey = errors.New("device exists with another key")
+
+// RestoreDevice handles a request to import a sealed bundle. A device that does not exist yet is created
+// with the identity, key pair, state and journal of the bundle. An existing device, e.g. one migrated away before,
+// is moved forward to the state of the bundle and its journal is continued with the transactions signed meanwhile.
+// Bundles that do not advance the counter beyond the device and all bundles imported before are stale and
+// rejected, so counters are never reused, as are bundles whose journal does not reach their state.
+func (s *Server) RestoreDevice(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	var bundle backup.Bundle
+	if err := json.NewDecoder(request.Body).Decode(&bundle); err != nil {
+		http.Error(response, "Failed to decode Request", http.StatusBadRequest)
+		return
+	}
+	if bundle.OrganizationId != organizationId(request) && !isOperator(request) {
+		s.audit(request, organizationId(request), ActionDeviceRestore, deviceTarget(bundle.DeviceId), audit.OutcomeDenied)
+		WriteErrorResponse(response, http.StatusForbidden, []string{
+			"Only operators may restore devices of other organizations.",
+		})
+		return
+	}
+	if _, exists := s.db.GetOrganization(bundle.OrganizationId); !exists {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"No organization found under provided id.",
+		})
+		return
+	}
+
+	restored, journal, err := backup.Open(&bundle, s.backupKey, s.logger, s.deviceOptions()...)
+	if err != nil {
+		s.audit(request, bundle.OrganizationId, ActionDeviceRestore, deviceTarget(bundle.DeviceId), audit.OutcomeFailure)
+		WriteErrorResponse(response, http.StatusBadRequest, []string{err.Error()})
+		return
+	}
+
+	device, exists := s.db.Get(restored.OrganizationId, restored.Id)
+	switch {
+	case exists && !backup.SameKey(device, restored):
+		err = errOtherKey
+	default:
+		device = restored
+		if err = s.db.ImportDevice(device, journal); err == nil && !exists {
+			s.metrics.DeviceAdded(device.Algorithm())
+			s.certifyRestored(request, device)
+		}
+	}
+	if err != nil {
+		s.audit(request, bundle.OrganizationId, ActionDeviceRestore, deviceTarget(bundle.DeviceId), audit.OutcomeFailure)
+		status := http.StatusConflict
+		if !errors.Is(err, domain.ErrStaleState) && !errors.Is(err, domain.ErrBrokenChain) &&
+			!errors.Is(err, persistence.ErrExists) && !errors.Is(err, errOtherKey) {
+			status = http.StatusInternalServerError
+		}
+		WriteErrorResponse(response, status, []string{err.Error()})
+		return
+	}
+	s.audit(request, device.OrganizationId, ActionDeviceRestore, deviceTarget(device.Id), audit.OutcomeSuccess)
+
+	WriteAPIResponse(response, http.StatusOK, s.signatureDeviceResponse(device))
+}
+
+// certifyRestored certifies a device imported from a bundle. A failure does not fail the restore, as an admin can
+// issue the certificate again.
+func (s *Server) certifyRestored(request *http.Request, device *domain.SignatureDevice) {
+	if s.authority == nil {
+		return
+	}
+	if _, err := s.certify(request, device); err != nil {
+		s.requestLogger(request).Warn("Certifying restored device failed", "device", device, "error", err)
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/backup"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+func TestBackup_MigrateDevice(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, backup.KeySize)
+	source := initAuthenticatedServer(t, WithBackupKey(key))
+	target := initAuthenticatedServer(t, WithBackupKey(key))
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, source.URL+"/api/v0/new", adminSecret, SignatureDeviceRequest{Algorithm: "ECC", Label: "migrated"}, &device)
+	var lastSigned SignDataResponse
+	for _, data := range []string{"first", "second"} {
+		sendAuthenticatedRequest(t, http.MethodPost, source.URL+"/api/v0/sign", adminSecret, SignDataRequest{Id: device.Id, Data: data}, &lastSigned)
+	}
+
+	var bundle backup.Bundle
+	backupURL := source.URL + "/api/v0/admin/devices/" + device.Id.String() + "/backup"
+	if code := sendAuthenticatedRequest(t, http.MethodPost, backupURL, adminSecret, nil, &bundle); code != http.StatusOK {
+		t.Fatal("Backup failed, got", code)
+	}
+	if bundle.DeviceId != device.Id || bundle.SignatureCounter != 2 {
+		t.Error("Bundle header incomplete:", bundle.DeviceId, bundle.SignatureCounter)
+	}
+	if bytes.Contains(bundle.Ciphertext, []byte("PRIVATE")) {
+		t.Error("Key material must be encrypted.")
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, source.URL+"/api/v0/sign", adminSecret, SignDataRequest{Id: device.Id, Data: "late"}, nil); code != http.StatusConflict {
+		t.Error("Migrated device must not sign on the source, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, backupURL, adminSecret, nil, nil); code != http.StatusConflict {
+		t.Error("Migrated device must not be backed up again, got", code)
+	}
+
+	var restored SignatureDeviceResponse
+	restoreURL := target.URL + "/api/v0/admin/devices/restore"
+	if code := sendAuthenticatedRequest(t, http.MethodPost, restoreURL, adminSecret, bundle, &restored); code != http.StatusOK {
+		t.Fatal("Restore failed, got", code)
+	}
+	if restored.Id != device.Id || restored.Label != "migrated" {
+		t.Error("Restored device must keep its identity.")
+	}
+
+	var signed SignDataResponse
+	sendAuthenticatedRequest(t, http.MethodPost, target.URL+"/api/v0/sign", adminSecret, SignDataRequest{Id: device.Id, Data: "third"}, &signed)
+	securedData, err := domain.ParseSecuredData([]byte(signed.SignedData))
+	if err != nil || securedData.Counter != 2 || string(securedData.Data) != "third" || !bytes.Equal(securedData.LastSig, lastSigned.Signature) {
+		t.Error("Restored device must continue the counter and the signature chain, got", signed.SignedData)
+	}
+
+	if code := sendAuthenticatedRequest(t, http.MethodGet, target.URL+"/api/v0/devices/"+device.Id.String()+"/transactions/0", adminSecret, nil, nil); code != http.StatusOK {
+		t.Error("Restored device must bring its journal, got", code)
+	}
+
+	if code := sendAuthenticatedRequest(t, http.MethodPost, restoreURL, adminSecret, bundle, nil); code != http.StatusConflict {
+		t.Error("Restoring a stale bundle must be rejected, got", code)
+	}
+
+	// Moving the device back continues the journal of the source with the transactions signed on the target.
+	var returned backup.Bundle
+	sendAuthenticatedRequest(t, http.MethodPost, target.URL+"/api/v0/admin/devices/"+device.Id.String()+"/backup", adminSecret, nil, &returned)
+	if code := sendAuthenticatedRequest(t, http.MethodPost, source.URL+"/api/v0/admin/devices/restore", adminSecret, returned, nil); code != http.StatusOK {
+		t.Fatal("Restoring a migrated device must succeed, got", code)
+	}
+	var transaction TransactionResponse
+	sendAuthenticatedRequest(t, http.MethodGet, source.URL+"/api/v0/devices/"+device.Id.String()+"/transactions/2", adminSecret, nil, &transaction)
+	if !bytes.Equal(transaction.Signature, signed.Signature) {
+		t.Error("Journal of the source must hold the transactions signed on the target, got", transaction)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, source.URL+"/api/v0/sign", adminSecret, SignDataRequest{Id: device.Id, Data: "fourth"}, nil); code != http.StatusOK {
+		t.Error("Device must sign again after it was restored, got", code)
+	}
+
+	other := initAuthenticatedServer(t, WithBackupKey(bytes.Repeat([]byte{8}, backup.KeySize)))
+	if code := sendAuthenticatedRequest(t, http.MethodPost, other.URL+"/api/v0/admin/devices/restore", adminSecret, bundle, nil); code != http.StatusBadRequest {
+		t.Error("Bundles sealed with another key must be rejected, got", code)
+	}
+}
+
+func TestBackup_TenantIsolation(t *testing.T) {
+	ts := initAuthenticatedServer(t, WithBackupKey(bytes.Repeat([]byte{7}, backup.KeySize)))
+	keyA := createOrganizationKey(t, ts.URL, "Tenant A", "create", "admin")
+	keyB := createOrganizationKey(t, ts.URL, "Tenant B", "admin")
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", keyA.Secret, SignatureDeviceRequest{Algorithm: "RSA", Label: "A"}, &device)
+
+	backupURL := ts.URL + "/api/v0/admin/devices/" + device.Id.String() + "/backup"
+	if code := sendAuthenticatedRequest(t, http.MethodPost, backupURL, keyB.Secret, nil, nil); code != http.StatusNotFound {
+		t.Error("Backing up a device of another tenant must return 404, got", code)
+	}
+
+	var bundle backup.Bundle
+	sendAuthenticatedRequest(t, http.MethodPost, backupURL, keyA.Secret, nil, &bundle)
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/admin/devices/restore", keyB.Secret, bundle, nil); code != http.StatusForbidden {
+		t.Error("Restoring a device into another tenant must be forbidden, got", code)
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/google/uuid"
+)
+
+// MaxBatchSize limits the number of items signed by one batch request.
+const MaxBatchSize = 1000
+
+// SignBatchRequest is a request for signing several data items in order. All items are encoded with DataEncoding,
+// see SignDataRequest.
+type SignBatchRequest struct {
+	Data         []string `json:"data"`
+	DataEncoding string   `json:"data_encoding,omitempty"`
+}
+
+// SignBatch handles requests for signing an ordered list of data items with one device. The items get consecutive
+// counters and chained signatures, and are signed without other signatures of the device in between. If any of
+// them cannot be signed or stored, none is and the device keeps its state.
+func (s *Server) SignBatch(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	id, err := uuid.Parse(strings.TrimSuffix(strings.TrimPrefix(request.URL.Path, "/api/v0/devices/"), "/sign/batch"))
+	if err != nil {
+		http.Error(response, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+	var requestData SignBatchRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestData); err != nil {
+		http.Error(response, "Failed to decode Request", http.StatusBadRequest)
+		return
+	}
+	if len(requestData.Data) == 0 || len(requestData.Data) > MaxBatchSize {
+		WriteErrorResponse(response, http.StatusBadRequest, []string{
+			fmt.Sprintf("A batch must contain between 1 and %d items.", MaxBatchSize),
+		})
+		return
+	}
+	items := make([][]byte, 0, len(requestData.Data))
+	for i, item := range requestData.Data {
+		rawData, err := decodeData(requestData.DataEncoding, item)
+		if err != nil {
+			WriteErrorResponse(response, http.StatusBadRequest, []string{fmt.Sprintf("Invalid data at %d: %v", i, err)})
+			return
+		}
+		items = append(items, rawData)
+	}
+
+	signatureDevice, exists := s.db.Get(organizationId(request), id)
+	if !exists {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"No device found under provided id.",
+		})
+		return
+	}
+	if !canAccessDevice(request, signatureDevice.Id) {
+		s.audit(request, signatureDevice.OrganizationId, ActionDeviceSign, deviceTarget(signatureDevice.Id), audit.OutcomeDenied)
+		writeDeviceForbidden(response)
+		return
+	}
+
+	body, _ := json.Marshal(requestData)
+	fencingToken, owned := s.acquireDevice(response, request, signatureDevice.Id, body)
+	if !owned {
+		return
+	}
+
+	startedAt := s.clock.Now()
+	signStart := time.Now()
+	transactions, err := signatureDevice.SignBatch(items, fencingToken)
+	s.metrics.ObserveSign(signatureDevice.Algorithm(), err, time.Since(signStart))
+	finishedAt := s.clock.Now()
+	if err != nil {
+		s.requestLogger(request).Error("Signing batch failed", "device_id", signatureDevice.Id, "items", len(items), "error", err)
+		s.audit(request, signatureDevice.OrganizationId, ActionDeviceSign, deviceTarget(signatureDevice.Id), audit.OutcomeFailure)
+		writeSignError(response, err)
+		return
+	}
+	s.audit(request, signatureDevice.OrganizationId, ActionDeviceSign, deviceTarget(signatureDevice.Id), audit.OutcomeSuccess)
+
+	// A single token covers the whole batch through the Merkle root of its signatures.
+	timestamp := s.timestamp(request, transactions)
+	signedDataResponses := make([]SignDataResponse, 0, len(transactions))
+	for _, transaction := range transactions {
+		signedDataResponses = append(signedDataResponses,
+			newSignDataResponse(requestData.DataEncoding, transaction, timestamp, startedAt, finishedAt))
+	}
+	WriteAPIResponse(response, http.StatusOK, signedDataResponses)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+func TestServer_SignBatch(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	ts := httptest.NewServer(NewServer(":8080", db).Handler())
+	defer ts.Close()
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC", Label: ""}, &deviceResponse)
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: deviceResponse.Id, Data: "single"}, nil)
+
+	var signed []SignDataResponse
+	batchURL := ts.URL + "/api/v0/devices/" + deviceResponse.Id.String() + "/sign/batch"
+	code := sendAuthenticatedRequest(t, http.MethodPost, batchURL, "", SignBatchRequest{Data: []string{"a", "b", "c"}}, &signed)
+	if code != http.StatusOK || len(signed) != 3 {
+		t.Fatal("Batch must be signed, got", code, len(signed))
+	}
+
+	device, _ := db.Get(domain.DefaultOrganizationId, deviceResponse.Id)
+	transactions := db.GetTransactions(domain.DefaultOrganizationId, deviceResponse.Id)
+	for i, item := range []string{"a", "b", "c"} {
+		transaction := transactions[i+1]
+		securedData, _ := domain.ParseSecuredData([]byte(signed[i].SignedData))
+		if securedData.Counter != uint64(i+1) || string(securedData.Data) != item || signed[i].SignedData != string(transaction.SignedData) {
+			t.Error("Item", i, "must be signed with consecutive counter", i+1, "got", signed[i].SignedData)
+		}
+		if !bytes.Equal(signed[i].Signature, transaction.Signature) || !device.Signer.VerifySignature(transaction.SignedData, transaction.Signature) {
+			t.Error("Signature of item", i, "does not verify.")
+		}
+	}
+	if device.SignatureCounter != 4 || !bytes.Equal(device.LastSig, signed[2].Signature) {
+		t.Error("Device must continue after the batch, counter is", device.SignatureCounter)
+	}
+}
+
+func TestServer_SignBatchIsAllOrNothing(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	ts := httptest.NewServer(NewServer(":8080", db).Handler())
+	defer ts.Close()
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC", Label: ""}, &deviceResponse)
+	batchURL := ts.URL + "/api/v0/devices/" + deviceResponse.Id.String() + "/sign/batch"
+
+	if code := sendAuthenticatedRequest(t, http.MethodPost, batchURL, "", SignBatchRequest{}, nil); code != http.StatusBadRequest {
+		t.Error("Empty batch must be rejected, got", code)
+	}
+
+	db.Close()
+	if code := sendAuthenticatedRequest(t, http.MethodPost, batchURL, "", SignBatchRequest{Data: []string{"a", "b"}}, nil); code != http.StatusInternalServerError {
+		t.Error("Batch must fail if it cannot be stored, got", code)
+	}
+	device, _ := db.Get(domain.DefaultOrganizationId, deviceResponse.Id)
+	if device.SignatureCounter != 0 || len(db.GetTransactions(domain.DefaultOrganizationId, deviceResponse.Id)) != 0 {
+		t.Error("A failed batch must not advance the device.")
+	}
+}
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// WithCertificateAuthority certifies the key of every new or restored device with authority and lets admins issue
+// certificates again.
+func WithCertificateAuthority(authority *ca.Authority) Option {
+	return func(s *Server) {
+		s.authority = authority
+	}
+}
+
+// errNotCertified is returned for the certificate chain of a device without certificate.
+var errNotCertified = errors.New("device has no certificate")
+
+// CertificateChainResponse holds the certificate of a device. Chain is PEM encoded, the certificate of the device
+// first, followed by the certificates of its issuers. KeyVersion is the version of the certified device key, which
+// names it in the key ids of JWS and JWKS.
+type CertificateChainResponse struct {
+	SerialNumber string    `json:"serial_number"`
+	KeyVersion   int       `json:"key_version"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	Chain        string    `json:"chain"`
+}
+
+// currentChain returns the certificate chain of a device if it still certifies the current key: a chain attached
+// with AttachCertificate while it is valid, and a chain issued by the service while its certificate authority
+// certifies it. Chains of an earlier authority, e.g. an ephemeral root of a previous run, are not current.
+func (s *Server) currentChain(device *domain.SignatureDevice) ([][]byte, error) {
+	stored, exists := s.db.GetCertificateChain(device.OrganizationId, device.Id)
+	if !exists {
+		return nil, errNotCertified
+	}
+	chain, err := parseChain(stored.Chain)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Attached {
+		if ca.CheckChain(chain, device, s.clock.Now()) != nil {
+			return nil, errNotCertified
+		}
+		return stored.Chain, nil
+	}
+	if s.authority == nil || !s.authority.Certifies(chain[0], device) {
+		return nil, errNotCertified
+	}
+	return stored.Chain, nil
+}
+
+// hasIssuedCertificate reports whether the current certificate chain of a device was issued by the certificate
+// authority of the server.
+func (s *Server) hasIssuedCertificate(device *domain.SignatureDevice) bool {
+	stored, exists := s.db.GetCertificateChain(device.OrganizationId, device.Id)
+	if !exists || stored.Attached {
+		return false
+	}
+	_, err := s.currentChain(device)
+	return err == nil
+}
+
+// certify issues a certificate for the current key of a device with the certificate authority of the server and
+// stores it as the chain of the device.
+func (s *Server) certify(request *http.Request, device *domain.SignatureDevice) ([][]byte, error) {
+	chain, err := s.authority.Issue(device)
+	if err == nil {
+		err = s.db.SetCertificateChain(device.Id, domain.CertificateChain{Chain: chain})
+	}
+	if err != nil {
+		s.audit(request, device.OrganizationId, ActionDeviceCertify, deviceTarget(device.Id), audit.OutcomeFailure)
+		return nil, err
+	}
+	s.audit(request, device.OrganizationId, ActionDeviceCertify, deviceTarget(device.Id), audit.OutcomeSuccess)
+	return chain, nil
+}
+
+// parseChain parses a DER encoded certificate chain.
+func parseChain(der [][]byte) ([]*x509.Certificate, error) {
+	if len(der) == 0 {
+		return nil, errNotCertified
+	}
+	chain := make([]*x509.Certificate, 0, len(der))
+	for _, certificate := range der {
+		parsed, err := x509.ParseCertificate(certificate)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, parsed)
+	}
+	return chain, nil
+}
+
+// CertificateRequestRequest selects the subject of a certificate signing request. CommonName and SerialNumber
+// default to the label and the id of the device; Country is a two-letter ISO 3166 code.
+type CertificateRequestRequest struct {
+	CommonName         string `json:"common_name,omitempty"`
+	SerialNumber       string `json:"serial_number,omitempty"`
+	Organization       string `json:"organization,omitempty"`
+	OrganizationalUnit string `json:"organizational_unit,omitempty"`
+	Locality           string `json:"locality,omitempty"`
+	Province           string `json:"province,omitempty"`
+	Country            string `json:"country,omitempty"`
+}
+
+// subject returns the subject of the request for a device.
+func (r CertificateRequestRequest) subject(device *domain.SignatureDevice) pkix.Name {
+	subject := ca.Subject(device)
+	if r.CommonName != "" {
+		subject.CommonName = r.CommonName
+	}
+	if r.SerialNumber != "" {
+		subject.SerialNumber = r.SerialNumber
+	}
+	for _, attribute := range []struct {
+		value  string
+		values *[]string
+	}{
+		{r.Organization, &subject.Organization},
+		{r.OrganizationalUnit, &subject.OrganizationalUnit},
+		{r.Locality, &subject.Locality},
+		{r.Province, &subject.Province},
+		{r.Country, &subject.Country},
+	} {
+		if attribute.value != "" {
+			*attribute.values = []string{attribute.value}
+		}
+	}
+	return subject
+}
+
+// CertificateRequestResponse holds a PEM encoded PKCS #10 certificate signing request.
+type CertificateRequestResponse struct {
+	CSR string `json:"csr"`
+}
+
+// AttachCertificateRequest holds the PEM encoded certificate chain of a device, its own certificate first,
+// optionally followed by the certificates of its issuers. Replace must be set to replace a current certificate
+// issued by the certificate authority of the server.
+type AttachCertificateRequest struct {
+	Chain   string `json:"chain"`
+	Replace bool   `json:"replace,omitempty"`
+}
+
+// newCertificateChainResponse describes a DER encoded certificate chain of a device.
+func newCertificateChainResponse(device *domain.SignatureDevice, chain [][]byte) (CertificateChainResponse, error) {
+	certificate, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return CertificateChainResponse{}, err
+	}
+	keyVersion := device.KeyVersion()
+	for version := keyVersion; version >= 1; version-- {
+		publicKey, _ := device.PublicKey(version)
+		if equal, ok := publicKey.(interface{ Equal(crypto.PublicKey) bool }); ok && equal.Equal(certificate.PublicKey) {
+			keyVersion = version
+			break
+		}
+	}
+	var encoded strings.Builder
+	for _, der := range chain {
+		encoded.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	}
+	return CertificateChainResponse{
+		SerialNumber: certificate.SerialNumber.Text(16),
+		KeyVersion:   keyVersion,
+		NotBefore:    certificate.NotBefore,
+		NotAfter:     certificate.NotAfter,
+		Chain:        encoded.String(),
+	}, nil
+}
+
+// pathDevice looks up the device of a request to /api/v0/devices/{id}/<suffix> and checks that the API key may
+// access it. If not, an error response is written and false returned.
+func (s *Server) pathDevice(response http.ResponseWriter, request *http.Request, suffix string) (*domain.SignatureDevice, bool) {
+	id, err := uuid.Parse(strings.TrimSuffix(strings.TrimPrefix(request.URL.Path, "/api/v0/devices/"), suffix))
+	if err != nil {
+		http.Error(response, "Invalid device ID", http.StatusBadRequest)
+		return nil, false
+	}
+	device, exists := s.db.Get(organizationId(request), id)
+	if !exists {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"No device found under provided id.",
+		})
+		return nil, false
+	}
+	if !canAccessDevice(request, device.Id) {
+		writeDeviceForbidden(response)
+		return nil, false
+	}
+	return device, true
+}
+
+// CertificateChain handles a request for the current certificate chain of a device at
+// /api/v0/devices/{id}/certificate.
+func (s *Server) CertificateChain(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+	device, ok := s.pathDevice(response, request, "/certificate")
+	if !ok {
+		return
+	}
+
+	chain, err := s.currentChain(device)
+	if errors.Is(err, errNotCertified) {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"No valid certificate found for the device.",
+		})
+		return
+	}
+	var chainResponse CertificateChainResponse
+	if err == nil {
+		chainResponse, err = newCertificateChainResponse(device, chain)
+	}
+	if err != nil {
+		s.requestLogger(request).Error("Reading certificate chain failed", "device", device, "error", err)
+		WriteInternalError(response)
+		return
+	}
+	WriteAPIResponse(response, http.StatusOK, chainResponse)
+}
+
+// IssueCertificate handles a request to issue a new certificate for a device with the certificate authority of the
+// server at /api/v0/devices/{id}/certificate, e.g. after the certificate expired or the authority changed. It
+// replaces any chain the device had.
+func (s *Server) IssueCertificate(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+	device, ok := s.pathDevice(response, request, "/certificate")
+	if !ok {
+		return
+	}
+
+	chain, err := s.certify(request, device)
+	var chainResponse CertificateChainResponse
+	if err == nil {
+		chainResponse, err = newCertificateChainResponse(device, chain)
+	}
+	if err != nil {
+		s.requestLogger(request).Error("Certifying device failed", "device", device, "error", err)
+		WriteInternalError(response)
+		return
+	}
+	WriteAPIResponse(response, http.StatusOK, chainResponse)
+}
+
+// CertificateRequest handles a request for a certificate signing request of a device at
+// /api/v0/devices/{id}/csr. The request is signed by the private key of the device.
+func (s *Server) CertificateRequest(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	// All subject fields are optional, so an empty body is accepted.
+	var requestData CertificateRequestRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestData); err != nil && err != io.EOF {
+		http.Error(response, "Failed to decode Request", http.StatusBadRequest)
+		return
+	}
+	if requestData.Country != "" && len(requestData.Country) != 2 {
+		WriteErrorResponse(response, http.StatusBadRequest, []string{"Country must be a two-letter code."})
+		return
+	}
+	device, ok := s.pathDevice(response, request, "/csr")
+	if !ok {
+		return
+	}
+
+	csr, err := ca.NewRequest(device, requestData.subject(device))
+	if err != nil {
+		s.requestLogger(request).Error("Creating certificate request failed", "device", device, "error", err)
+		WriteInternalError(response)
+		return
+	}
+	WriteAPIResponse(response, http.StatusOK, CertificateRequestResponse{
+		CSR: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+	})
+}
+
+// AttachCertificate handles a request to replace the certificate chain of a device with one issued elsewhere, e.g.
+// for a certificate signing request, at /api/v0/devices/{id}/certificate. The certificate must be for the key of
+// the device, and the chain valid and in order. The chain is not checked against a trust anchor, so only admins
+// may attach one, and a current certificate of the service is only replaced on explicit request.
+func (s *Server) AttachCertificate(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPut {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	var requestData AttachCertificateRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestData); err != nil {
+		http.Error(response, "Failed to decode Request", http.StatusBadRequest)
+		return
+	}
+	device, ok := s.pathDevice(response, request, "/certificate")
+	if !ok {
+		return
+	}
+
+	var chain []*x509.Certificate
+	var der [][]byte
+	rest := []byte(requestData.Chain)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if block.Type != "CERTIFICATE" || err != nil {
+			WriteErrorResponse(response, http.StatusBadRequest, []string{"Chain must only hold PEM encoded certificates."})
+			return
+		}
+		chain = append(chain, certificate)
+		der = append(der, block.Bytes)
+	}
+	if err := ca.CheckChain(chain, device, s.clock.Now()); err != nil {
+		s.audit(request, device.OrganizationId, ActionDeviceAttachCertificate, deviceTarget(device.Id), audit.OutcomeFailure)
+		WriteErrorResponse(response, http.StatusUnprocessableEntity, []string{err.Error()})
+		return
+	}
+	if !requestData.Replace && s.hasIssuedCertificate(device) {
+		s.audit(request, device.OrganizationId, ActionDeviceAttachCertificate, deviceTarget(device.Id), audit.OutcomeFailure)
+		WriteErrorResponse(response, http.StatusConflict, []string{
+			"Device has a current certificate of the service certificate authority, set replace to replace it.",
+		})
+		return
+	}
+
+	if err := s.db.SetCertificateChain(device.Id, domain.CertificateChain{Chain: der, Attached: true}); err != nil {
+		s.requestLogger(request).Error("Storing certificate chain failed", "device", device, "error", err)
+		s.audit(request, device.OrganizationId, ActionDeviceAttachCertificate, deviceTarget(device.Id), audit.OutcomeFailure)
+		WriteInternalError(response)
+		return
+	}
+	s.audit(request, device.OrganizationId, ActionDeviceAttachCertificate, deviceTarget(device.Id), audit.OutcomeSuccess)
+
+	chainResponse, err := newCertificateChainResponse(device, der)
+	if err != nil {
+		WriteInternalError(response)
+		return
+	}
+	WriteAPIResponse(response, http.StatusOK, chainResponse)
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+func decodeChain(t *testing.T, encoded string) []*x509.Certificate {
+	t.Helper()
+	var chain []*x509.Certificate
+	rest := []byte(encoded)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, certificate)
+	}
+	return chain
+}
+
+func TestCertificate_DevicesAreCertified(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	authority, err := ca.Load("", "", ca.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewServer(":8080", db, WithCertificateAuthority(authority)).Handler())
+	defer ts.Close()
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "RSA", Label: "till"}, &deviceResponse)
+	device, _ := db.Get(domain.DefaultOrganizationId, deviceResponse.Id)
+	certificateURL := ts.URL + "/api/v0/devices/" + deviceResponse.Id.String() + "/certificate"
+
+	var issued CertificateChainResponse
+	if code := sendAuthenticatedRequest(t, http.MethodGet, certificateURL, "", nil, &issued); code != http.StatusOK {
+		t.Fatal("Expected the certificate chain, got", code)
+	}
+	chain := decodeChain(t, issued.Chain)
+	if len(chain) != 2 || !chain[1].Equal(authority.Chain()[0]) {
+		t.Fatal("Chain must continue with the CA certificate, got", len(chain), "certificates")
+	}
+	if !authority.Certifies(chain[0], device) || chain[0].Subject.SerialNumber != device.Id.String() {
+		t.Error("Certificate must certify the device key, got subject", chain[0].Subject)
+	}
+
+	var again CertificateChainResponse
+	sendAuthenticatedRequest(t, http.MethodGet, certificateURL, "", nil, &again)
+	if again.SerialNumber != issued.SerialNumber {
+		t.Error("A valid certificate must not be issued again")
+	}
+
+	// Certificates of another key, as left behind by a key rotation, or of a root of an earlier run are not
+	// current, and reading them does not issue new ones.
+	other := domain.NewSignatureDevice(domain.DefaultOrganizationId, "other", crypto.NewECCSigner())
+	stale, _ := authority.Issue(other)
+	earlier, _ := ca.Load("", "", ca.Config{})
+	previousRoot, _ := earlier.Issue(device)
+	for _, chain := range [][][]byte{stale, previousRoot} {
+		if err := db.SetCertificateChain(device.Id, domain.CertificateChain{Chain: chain}); err != nil {
+			t.Fatal(err)
+		}
+		if code := sendAuthenticatedRequest(t, http.MethodGet, certificateURL, "", nil, nil); code != http.StatusNotFound {
+			t.Error("Certificate that is not current must not be served, got", code)
+		}
+	}
+
+	var reissued CertificateChainResponse
+	if code := sendAuthenticatedRequest(t, http.MethodPost, certificateURL, "", nil, &reissued); code != http.StatusOK {
+		t.Fatal("Expected the certificate to be issued again, got", code)
+	}
+	if chain := decodeChain(t, reissued.Chain); len(chain) == 0 || !authority.Certifies(chain[0], device) {
+		t.Error("Certificate must be issued again for the current key")
+	}
+}
+
+func TestCertificate_NotCertifiedWithoutAuthority(t *testing.T) {
+	ts := httptest.NewServer(NewServer(":8080", persistence.GetInMemoryDB()).Handler())
+	defer ts.Close()
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC"}, &deviceResponse)
+	certificateURL := ts.URL + "/api/v0/devices/" + deviceResponse.Id.String() + "/certificate"
+	if code := sendAuthenticatedRequest(t, http.MethodGet, certificateURL, "", nil, nil); code != http.StatusNotFound {
+		t.Error("Devices must not have certificates without authority, got", code)
+	}
+}
+
+// certifyRequest issues a certificate for a PEM encoded certificate signing request with an external root.
+func certifyRequest(t *testing.T, csr string) string {
+	t.Helper()
+	root, key, err := ca.NewRoot("External CA", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode([]byte(csr))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		t.Fatal("Expected a PEM encoded certificate request, got", csr)
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := request.CheckSignature(); err != nil {
+		t.Fatal("Certificate request must be signed by the device key:", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      request.Subject,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root, request.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}))
+}
+
+func TestCertificate_AttachesCertificateForRequest(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	ts := httptest.NewServer(NewServer(":8080", db).Handler())
+	defer ts.Close()
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC", Label: "till"}, &deviceResponse)
+	deviceURL := ts.URL + "/api/v0/devices/" + deviceResponse.Id.String()
+
+	var csr CertificateRequestResponse
+	subject := CertificateRequestRequest{Organization: "Example GmbH", Country: "DE"}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, deviceURL+"/csr", "", subject, &csr); code != http.StatusOK {
+		t.Fatal("Expected a certificate request, got", code)
+	}
+	chain := certifyRequest(t, csr.CSR)
+	attached := decodeChain(t, chain)
+	if attached[0].Subject.CommonName != "till" || attached[0].Subject.Organization[0] != "Example GmbH" || attached[0].Subject.Country[0] != "DE" {
+		t.Error("Subject must hold the device label and the requested fields, got", attached[0].Subject)
+	}
+
+	if code := sendAuthenticatedRequest(t, http.MethodPut, deviceURL+"/certificate", "", AttachCertificateRequest{Chain: chain}, nil); code != http.StatusOK {
+		t.Fatal("Expected the certificate to be attached, got", code)
+	}
+	var stored CertificateChainResponse
+	sendAuthenticatedRequest(t, http.MethodGet, deviceURL+"/certificate", "", nil, &stored)
+	if stored.Chain != chain || stored.SerialNumber != "2a" {
+		t.Error("Attached chain must be served, got", stored)
+	}
+
+	var other SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC"}, &other)
+	otherURL := ts.URL + "/api/v0/devices/" + other.Id.String() + "/certificate"
+	if code := sendAuthenticatedRequest(t, http.MethodPut, otherURL, "", AttachCertificateRequest{Chain: chain}, nil); code != http.StatusUnprocessableEntity {
+		t.Error("Certificate of another key must be rejected, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, deviceURL+"/csr", "", CertificateRequestRequest{Country: "Germany"}, nil); code != http.StatusBadRequest {
+		t.Error("Invalid country must be rejected, got", code)
+	}
+}
+
+func TestCertificate_AttachRequiresAdminAndReplace(t *testing.T) {
+	authority, err := ca.Load("", "", ca.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := initAuthenticatedServer(t, WithCertificateAuthority(authority))
+	var creatorKey APIKeyResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/admin/keys", adminSecret, APIKeyRequest{
+		Permissions: []string{"create", "sign", "read"},
+		AllDevices:  true,
+	}, &creatorKey)
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", creatorKey.Secret, SignatureDeviceRequest{Algorithm: "ECC", Label: "till"}, &deviceResponse)
+	deviceURL := ts.URL + "/api/v0/devices/" + deviceResponse.Id.String()
+	var issued CertificateChainResponse
+	sendAuthenticatedRequest(t, http.MethodGet, deviceURL+"/certificate", creatorKey.Secret, nil, &issued)
+	var csr CertificateRequestResponse
+	sendAuthenticatedRequest(t, http.MethodPost, deviceURL+"/csr", creatorKey.Secret, CertificateRequestRequest{}, &csr)
+	chain := certifyRequest(t, csr.CSR)
+
+	if code := sendAuthenticatedRequest(t, http.MethodPut, deviceURL+"/certificate", creatorKey.Secret, AttachCertificateRequest{Chain: chain, Replace: true}, nil); code != http.StatusForbidden {
+		t.Error("Attaching must require the admin permission, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPut, deviceURL+"/certificate", adminSecret, AttachCertificateRequest{Chain: chain}, nil); code != http.StatusConflict {
+		t.Error("Certificate of the service must only be replaced on request, got", code)
+	}
+	var current CertificateChainResponse
+	sendAuthenticatedRequest(t, http.MethodGet, deviceURL+"/certificate", adminSecret, nil, &current)
+	if current.SerialNumber != issued.SerialNumber {
+		t.Error("Rejected chain must not replace the certificate, got", current.SerialNumber)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPut, deviceURL+"/certificate", adminSecret, AttachCertificateRequest{Chain: chain, Replace: true}, nil); code != http.StatusOK {
+		t.Error("Certificate must be replaced on request, got", code)
+	}
+
+	var attachments []audit.Entry
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/audit?action="+ActionDeviceAttachCertificate, adminSecret, nil, &attachments)
+	if len(attachments) != 2 || attachments[0].Outcome != audit.OutcomeFailure || attachments[1].Outcome != audit.OutcomeSuccess {
+		t.Error("Attaching must be audited as its own action, got", attachments)
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/cms"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/jose"
+)
+
+// Formats of the signature in sign responses.
+const (
+	SignatureFormatRaw = "raw"
+	SignatureFormatCMS = "cms"
+	SignatureFormatJWS = "jws"
+)
+
+// checkSignatureFormat validates the signature format of a sign request, raw by default.
+func checkSignatureFormat(format string) error {
+	switch format {
+	case "", SignatureFormatRaw, SignatureFormatCMS, SignatureFormatJWS:
+		return nil
+	default:
+		return fmt.Errorf("unknown signature_format %q, expected raw, cms or jws", format)
+	}
+}
+
+// checkDeviceSignatureFormat returns an error if the device cannot sign in the signature format, so that requests
+// are rejected before a transaction is created.
+func checkDeviceSignatureFormat(device *domain.SignatureDevice, format string) error {
+	signer, _ := device.Key()
+	var err error
+	switch format {
+	case SignatureFormatCMS:
+		err = cms.CheckSigner(signer)
+	case SignatureFormatJWS:
+		err = jose.CheckSigner(signer)
+	}
+	if err != nil {
+		return fmt.Errorf("device cannot sign signature_format %s: %w", format, err)
+	}
+	return nil
+}
+
+// detachedSignature wraps a transaction in detached CMS SignedData over its signed data, signed by the device at
+// signingTime. It includes the current certificate chain of the device, if it has one, and otherwise identifies the
+// device by its key identifier.
+func (s *Server) detachedSignature(request *http.Request, device *domain.SignatureDevice, transaction domain.Transaction, signingTime time.Time) ([]byte, error) {
+	var chain []*x509.Certificate
+	der, err := s.currentChain(device)
+	if err == nil {
+		chain, err = parseChain(der)
+	}
+	if err != nil && err != errNotCertified {
+		s.requestLogger(request).Warn("Reading certificate chain failed, identifying device by key", "device", device, "error", err)
+	}
+	signer, _ := device.Key()
+	return cms.SignDetached(transaction.SignedData, signer, chain, signingTime)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+func TestServer_SignCMS(t *testing.T) {
+	authority, err := ca.Load("", "", ca.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewServer(":8080", persistence.GetInMemoryDB(), WithCertificateAuthority(authority)).Handler())
+	defer ts.Close()
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC"}, &device)
+
+	var signed SignDataResponse
+	code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "receipt", SignatureFormat: SignatureFormatCMS}, &signed)
+	if code != http.StatusOK || len(signed.CMS) == 0 {
+		t.Fatal("Expected a CMS signature, got", code)
+	}
+	var contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(signed.CMS, &contentInfo); err != nil || !contentInfo.ContentType.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}) {
+		t.Fatal("CMS signature must be SignedData, got", contentInfo.ContentType, err)
+	}
+	var certificate CertificateChainResponse
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices/"+device.Id.String()+"/certificate", "", nil, &certificate)
+	for _, issued := range decodeChain(t, certificate.Chain) {
+		if !bytes.Contains(contentInfo.Content.Bytes, issued.Raw) {
+			t.Error("CMS signature must include the certificate chain of the device")
+		}
+	}
+
+	var raw SignDataResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "receipt"}, &raw)
+	if raw.CMS != nil {
+		t.Error("CMS signature must only be returned on request")
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "receipt", SignatureFormat: "xml"}, nil); code != http.StatusBadRequest {
+		t.Error("Unknown signature format must be rejected, got", code)
+	}
+}
@@ -3,10 +3,13 @@ package api
 import (
 	"crypto"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
-	crypto2 "github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/auth"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
 	"github.com/google/uuid"
 )
@@ -17,24 +20,89 @@ type SignatureDeviceResponse struct {
 	Label     string           `json:"label"`
 	Algorithm string           `json:"algorithm"`
 	PublicKey crypto.PublicKey `json:"publicKey"`
+	// DataFormat is the version of the secured data format the device signs.
+	DataFormat string `json:"data_format"`
+	// KeyVersion is the version of the current key, see RotateDeviceKey.
+	KeyVersion int `json:"key_version"`
+	// Suspended tells whether the device is suspended, see SuspendDevice.
+	Suspended bool `json:"suspended"`
+}
+
+// signatureDeviceResponse describes a device with its current key.
+func (s *Server) signatureDeviceResponse(device *domain.SignatureDevice) SignatureDeviceResponse {
+	signer, keyVersion := device.Key()
+	return SignatureDeviceResponse{
+		Id:         device.Id,
+		Label:      device.Label,
+		Algorithm:  signer.GetAlgorithm(),
+		PublicKey:  signer.GetPublicKey(),
+		DataFormat: string(device.DataFormat),
+		KeyVersion: keyVersion,
+		Suspended:  s.db.Suspended(device.Id),
+	}
 }
 
 // SignatureDeviceRequest is a request with data needed for signature device creation. Label is optional.
+// DataFormat selects the secured data format (v1, v2 or v3), v3 by default.
 type SignatureDeviceRequest struct {
-	Algorithm string `json:"algorithm"`
-	Label     string `json:"label"`
+	Algorithm  string `json:"algorithm"`
+	Label      string `json:"label"`
+	DataFormat string `json:"data_format,omitempty"`
 }
 
-// SignDataRequest is a request for data signing.
+// SignDataRequest is a request for data signing. DataEncoding tells how Data is encoded (utf8, base64 or hex),
+// utf8 by default. SignatureFormat cms additionally returns the signature as detached CMS SignedData, jws as
+// compact JWS.
 type SignDataRequest struct {
-	Id   uuid.UUID `json:"id"`
-	Data string    `json:"data"`
+	Id              uuid.UUID `json:"id"`
+	Data            string    `json:"data"`
+	DataEncoding    string    `json:"data_encoding,omitempty"`
+	SignatureFormat string    `json:"signature_format,omitempty"`
 }
 
-// SignDataResponse holds a signed data.
+// SignDataResponse holds a signed data. SignedData is encoded like the data of the request.
+// Timestamp is the signed time contained in SignedData, if the data format of the device has one.
+// StartedAt and FinishedAt are when the server started and finished processing the signature.
+// TimestampToken is the RFC 3161 token of a time-stamp authority, if the server has one configured.
+// CMS is the DER encoded detached CMS SignedData over the signed data, JWS a compact JWS of the transaction signed
+// by the device, if requested. Warnings tell why a requested format is missing although the data was signed.
 type SignDataResponse struct {
-	Signature  []byte `json:"signature"`
-	SignedData string `json:"signed_data"`
+	Signature      []byte     `json:"signature"`
+	SignedData     string     `json:"signed_data"`
+	Timestamp      *time.Time `json:"timestamp,omitempty"`
+	StartedAt      time.Time  `json:"started_at"`
+	FinishedAt     time.Time  `json:"finished_at"`
+	TimestampToken []byte     `json:"timestamp_token,omitempty"`
+	CMS            []byte     `json:"cms,omitempty"`
+	JWS            string     `json:"jws,omitempty"`
+	Warnings       []string   `json:"warnings,omitempty"`
+}
+
+// newSignDataResponse describes a transaction signed between startedAt and finishedAt.
+func newSignDataResponse(encoding string, transaction domain.Transaction, timestamp *domain.Timestamp, startedAt time.Time, finishedAt time.Time) SignDataResponse {
+	signDataResponse := SignDataResponse{
+		Signature:  transaction.Signature,
+		SignedData: encodeData(encoding, transaction.SignedData),
+		StartedAt:  startedAt.UTC(),
+		FinishedAt: finishedAt.UTC(),
+	}
+	if timestamp != nil {
+		signDataResponse.TimestampToken = timestamp.Token
+	}
+	if securedData, err := domain.ParseSecuredData(transaction.SignedData); err == nil && !securedData.Time.IsZero() {
+		signDataResponse.Timestamp = &securedData.Time
+	}
+	return signDataResponse
+}
+
+// deviceOptions injects the journal and the observability dependencies of the server into signature devices.
+func (s *Server) deviceOptions() []domain.DeviceOption {
+	return []domain.DeviceOption{
+		domain.WithJournal(s.db),
+		domain.WithClock(s.clock),
+		domain.WithObserver(s.metrics),
+		domain.WithLogger(s.logger),
+	}
 }
 
 // CreateSignatureDevice handles a request for new signature device creation.
@@ -53,21 +121,56 @@ func (s *Server) CreateSignatureDevice(response http.ResponseWriter, request *ht
 		http.Error(response, "Failed to decode Request", http.StatusBadRequest)
 		return
 	}
-	signer, err := crypto2.SignerFactory(requestData.Algorithm)
+	dataFormat, err := domain.ParseDataFormat(requestData.DataFormat)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	keyGenerationStart := time.Now()
+	signer, err := s.keyPolicy.NewSigner(requestData.Algorithm)
 	if err != nil {
+		s.audit(request, organizationId(request), ActionDeviceCreate, "", audit.OutcomeFailure)
 		http.Error(response, err.Error(), http.StatusBadRequest)
 		return
 	}
-	newSignatureDevice := domain.NewSignatureDevice(requestData.Label, signer)
-	s.db.Set(newSignatureDevice.Id, newSignatureDevice)
+	s.metrics.ObserveKeyGeneration(signer.GetAlgorithm(), time.Since(keyGenerationStart))
 
-	newDeviceResponse := SignatureDeviceResponse{
-		Id:        newSignatureDevice.Id,
-		Label:     newSignatureDevice.Label,
-		Algorithm: newSignatureDevice.Signer.GetAlgorithm(),
-		PublicKey: newSignatureDevice.Signer.GetPublicKey(),
+	options := append(s.deviceOptions(), domain.WithDataFormat(dataFormat))
+	newSignatureDevice := domain.NewSignatureDevice(organizationId(request), requestData.Label, signer, options...)
+	if err := s.db.Set(newSignatureDevice); err != nil {
+		s.requestLogger(request).Error("Storing device failed", "device", newSignatureDevice, "error", err)
+		s.audit(request, newSignatureDevice.OrganizationId, ActionDeviceCreate, deviceTarget(newSignatureDevice.Id), audit.OutcomeFailure)
+		WriteInternalError(response)
+		return
 	}
-	WriteAPIResponse(response, http.StatusOK, newDeviceResponse)
+	s.metrics.DeviceAdded(signer.GetAlgorithm())
+	if s.ownership != nil {
+		// The creating replica owns the device first.
+		if _, err := s.db.AcquireLease(newSignatureDevice.Id, s.ownership.ReplicaId, s.ownership.Address, s.ownership.LeaseTTL); err != nil {
+			s.requestLogger(request).Warn("Acquiring lease of new device failed", "device", newSignatureDevice, "error", err)
+		}
+	}
+
+	// A key scoped to specific devices gets access to the devices it creates.
+	if key, ok := auth.FromContext(request.Context()); ok && !key.CanAccessDevice(newSignatureDevice.Id) {
+		if err := s.keys.GrantDevice(key.Id, newSignatureDevice.Id); err != nil {
+			s.requestLogger(request).Error("Granting device to API key failed", "device", newSignatureDevice, "error", err)
+			s.audit(request, newSignatureDevice.OrganizationId, ActionDeviceCreate, deviceTarget(newSignatureDevice.Id), audit.OutcomeFailure)
+			WriteInternalError(response)
+			return
+		}
+	}
+	if s.authority != nil {
+		if _, err := s.certify(request, newSignatureDevice); err != nil {
+			s.requestLogger(request).Error("Certifying device failed", "device", newSignatureDevice, "error", err)
+			s.audit(request, newSignatureDevice.OrganizationId, ActionDeviceCreate, deviceTarget(newSignatureDevice.Id), audit.OutcomeFailure)
+			WriteInternalError(response)
+			return
+		}
+	}
+	s.audit(request, newSignatureDevice.OrganizationId, ActionDeviceCreate, deviceTarget(newSignatureDevice.Id), audit.OutcomeSuccess)
+
+	WriteAPIResponse(response, http.StatusOK, s.signatureDeviceResponse(newSignatureDevice))
 }
 
 // SignData handles request for signing the data. It parses request for the data and id of a signature device.
@@ -85,26 +188,74 @@ func (s *Server) SignData(response http.ResponseWriter, request *http.Request) {
 		http.Error(response, "Failed to decode Request", http.StatusBadRequest)
 		return
 	}
+	rawData, err := decodeData(requestData.DataEncoding, requestData.Data)
+	if err != nil {
+		WriteErrorResponse(response, http.StatusBadRequest, []string{"Invalid data: " + err.Error()})
+		return
+	}
+	if err := checkSignatureFormat(requestData.SignatureFormat); err != nil {
+		WriteErrorResponse(response, http.StatusBadRequest, []string{err.Error()})
+		return
+	}
 
-	signatureDevice, exists := s.db.Get(requestData.Id)
+	signatureDevice, exists := s.db.Get(organizationId(request), requestData.Id)
 	if !exists {
 		WriteErrorResponse(response, http.StatusNotFound, []string{
 			"No device found under provided id.",
 		})
+		return
+	}
+	if !canAccessDevice(request, signatureDevice.Id) {
+		s.audit(request, signatureDevice.OrganizationId, ActionDeviceSign, deviceTarget(signatureDevice.Id), audit.OutcomeDenied)
+		writeDeviceForbidden(response)
+		return
+	}
+
+	if err := checkDeviceSignatureFormat(signatureDevice, requestData.SignatureFormat); err != nil {
+		WriteErrorResponse(response, http.StatusUnprocessableEntity, []string{err.Error()})
+		return
+	}
+
+	body, _ := json.Marshal(requestData)
+	fencingToken, owned := s.acquireDevice(response, request, signatureDevice.Id, body)
+	if !owned {
+		return
 	}
 
-	data, signature, err := signatureDevice.SignData([]byte(requestData.Data))
+	startedAt := s.clock.Now()
+	signStart := time.Now()
+	transaction, err := signatureDevice.SignDataFenced(rawData, fencingToken)
+	s.metrics.ObserveSign(signatureDevice.Algorithm(), err, time.Since(signStart))
+	finishedAt := s.clock.Now()
 
 	if err != nil {
-		WriteErrorResponse(response, http.StatusInternalServerError, []string{
-			"Signing data failed",
-		})
+		s.requestLogger(request).Error("Signing data failed", "device_id", signatureDevice.Id, "error", err)
+		s.audit(request, signatureDevice.OrganizationId, ActionDeviceSign, deviceTarget(signatureDevice.Id), audit.OutcomeFailure)
+		writeSignError(response, err)
 		return
 	}
 
-	signedDataResponse := SignDataResponse{
-		signature,
-		string(data),
+	s.audit(request, signatureDevice.OrganizationId, ActionDeviceSign, deviceTarget(signatureDevice.Id), audit.OutcomeSuccess)
+
+	timestamp := s.timestamp(request, []domain.Transaction{transaction})
+	signedDataResponse := newSignDataResponse(requestData.DataEncoding, transaction, timestamp, startedAt, finishedAt)
+	// The transaction is committed, so it is returned even if it cannot be wrapped in the requested format.
+	switch requestData.SignatureFormat {
+	case SignatureFormatCMS:
+		// The signing time is the one in the signed data, if the data format of the device has one.
+		signingTime := finishedAt
+		if signedDataResponse.Timestamp != nil {
+			signingTime = *signedDataResponse.Timestamp
+		}
+		if signedDataResponse.CMS, err = s.detachedSignature(request, signatureDevice, transaction, signingTime); err != nil {
+			s.requestLogger(request).Error("Creating CMS signature failed", "device_id", signatureDevice.Id, "counter", transaction.Counter, "error", err)
+			signedDataResponse.Warnings = append(signedDataResponse.Warnings, "Data was signed, but creating the CMS signature failed.")
+		}
+	case SignatureFormatJWS:
+		if signedDataResponse.JWS, err = webSignature(signatureDevice, requestData.DataEncoding, transaction); err != nil {
+			s.requestLogger(request).Error("Creating JWS failed", "device_id", signatureDevice.Id, "counter", transaction.Counter, "error", err)
+			signedDataResponse.Warnings = append(signedDataResponse.Warnings, "Data was signed, but creating the JWS failed.")
+		}
 	}
 
 	WriteAPIResponse(response, http.StatusOK, signedDataResponse)
@@ -119,21 +270,73 @@ func (s *Server) GetDevices(response http.ResponseWriter, request *http.Request)
 		return
 	}
 
-	allDevices := s.db.GetAll()
+	allDevices := s.db.GetAll(organizationId(request))
 
 	var allDevicesResponse []SignatureDeviceResponse
 	for _, device := range allDevices {
-		allDevicesResponse = append(allDevicesResponse, SignatureDeviceResponse{
-			Id:        device.Id,
-			Label:     device.Label,
-			Algorithm: device.Signer.GetAlgorithm(),
-			PublicKey: device.Signer.GetPublicKey(),
-		})
+		if !canAccessDevice(request, device.Id) {
+			continue
+		}
+		allDevicesResponse = append(allDevicesResponse, s.signatureDeviceResponse(device))
 	}
 
 	WriteAPIResponse(response, http.StatusOK, allDevicesResponse)
 }
 
+// deviceRoutes dispatches requests for a device and its sub-resources below /api/v0/devices/.
+func (s *Server) deviceRoutes() http.Handler {
+	getDevice := s.authenticate(auth.PermissionRead, s.GetDevice)
+	exportDevice := s.authenticate(auth.PermissionSign, s.ExportDevice)
+	signBatch := s.authenticate(auth.PermissionSign, s.SignBatch)
+	getTransaction := s.authenticate(auth.PermissionRead, s.GetTransaction)
+	inclusionProof := s.authenticate(auth.PermissionRead, s.InclusionProof)
+	certificateChain := s.authenticate(auth.PermissionRead, s.CertificateChain)
+	issueCertificate := s.authenticate(auth.PermissionAdmin, s.IssueCertificate)
+	attachCertificate := s.authenticate(auth.PermissionAdmin, s.AttachCertificate)
+	certificateRequest := s.authenticate(auth.PermissionSign, s.CertificateRequest)
+	rotateKey := s.authenticate(auth.PermissionAdmin, s.RotateDeviceKey)
+	suspendDevice := s.authenticate(auth.PermissionAdmin, s.SuspendDevice)
+	resumeDevice := s.authenticate(auth.PermissionAdmin, s.ResumeDevice)
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		switch {
+		case strings.Contains(request.URL.Path, "/transactions/") && strings.HasSuffix(request.URL.Path, "/proof"):
+			inclusionProof.ServeHTTP(response, request)
+			return
+		case strings.Contains(request.URL.Path, "/transactions/"):
+			getTransaction.ServeHTTP(response, request)
+			return
+		case strings.HasSuffix(request.URL.Path, "/export"):
+			exportDevice.ServeHTTP(response, request)
+			return
+		case strings.HasSuffix(request.URL.Path, "/sign/batch"):
+			signBatch.ServeHTTP(response, request)
+			return
+		case strings.HasSuffix(request.URL.Path, "/certificate") && request.Method == http.MethodPost && s.authority != nil:
+			issueCertificate.ServeHTTP(response, request)
+			return
+		case strings.HasSuffix(request.URL.Path, "/certificate") && request.Method == http.MethodPut:
+			attachCertificate.ServeHTTP(response, request)
+			return
+		case strings.HasSuffix(request.URL.Path, "/certificate"):
+			certificateChain.ServeHTTP(response, request)
+			return
+		case strings.HasSuffix(request.URL.Path, "/csr"):
+			certificateRequest.ServeHTTP(response, request)
+			return
+		case strings.HasSuffix(request.URL.Path, "/rotate"):
+			rotateKey.ServeHTTP(response, request)
+			return
+		case strings.HasSuffix(request.URL.Path, "/suspend"):
+			suspendDevice.ServeHTTP(response, request)
+			return
+		case strings.HasSuffix(request.URL.Path, "/resume"):
+			resumeDevice.ServeHTTP(response, request)
+			return
+		}
+		getDevice.ServeHTTP(response, request)
+	})
+}
+
 // GetDevice handles a request for one specific device.
 func (s *Server) GetDevice(response http.ResponseWriter, request *http.Request) {
 	if request.Method != http.MethodGet {
@@ -151,19 +354,54 @@ func (s *Server) GetDevice(response http.ResponseWriter, request *http.Request)
 		return
 	}
 
-	device, exists := s.db.Get(id)
+	device, exists := s.db.Get(organizationId(request), id)
 	if !exists {
 		WriteErrorResponse(response, http.StatusNotFound, []string{
 			"No device found under provided id.",
 		})
+		return
+	}
+	if !canAccessDevice(request, device.Id) {
+		writeDeviceForbidden(response)
+		return
 	}
 
-	deviceResponse := SignatureDeviceResponse{
-		Id:        device.Id,
-		Label:     device.Label,
-		Algorithm: device.Signer.GetAlgorithm(),
-		PublicKey: device.Signer.GetPublicKey(),
+	WriteAPIResponse(response, http.StatusOK, s.signatureDeviceResponse(device))
+}
+
+func writeDeviceForbidden(response http.ResponseWriter) {
+	WriteErrorResponse(response, http.StatusForbidden, []string{
+		"API key is not authorized for this device.",
+	})
+}
+
+// writeSignError responds to a failed signature. Conflicts with other replicas can be retried, signatures of
+// migrated or suspended devices cannot.
+func writeSignError(response http.ResponseWriter, err error) {
+	if errors.Is(err, domain.ErrVersionConflict) || errors.Is(err, domain.ErrFenced) {
+		WriteErrorResponse(response, http.StatusConflict, []string{
+			"Device is busy, please retry.",
+		})
+		return
 	}
+	if errors.Is(err, domain.ErrMigrated) {
+		WriteErrorResponse(response, http.StatusConflict, []string{
+			"Device has been migrated to another instance.",
+		})
+		return
+	}
+	if errors.Is(err, domain.ErrSuspended) {
+		WriteErrorResponse(response, http.StatusConflict, []string{
+			"Device is suspended.",
+		})
+		return
+	}
+	WriteErrorResponse(response, http.StatusInternalServerError, []string{
+		"Signing data failed",
+	})
+}
 
-	WriteAPIResponse(response, http.StatusOK, deviceResponse)
+// deviceTarget names a device as target of audited actions.
+func deviceTarget(id uuid.UUID) string {
+	return "device:" + id.String()
 }
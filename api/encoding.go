@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// Encodings of the data in sign requests.
+const (
+	EncodingUTF8   = "utf8"
+	EncodingBase64 = "base64"
+	EncodingHex    = "hex"
+)
+
+// decodeData returns the bytes to be signed from request data in the given encoding, utf8 by default.
+func decodeData(encoding string, data string) ([]byte, error) {
+	switch encoding {
+	case "", EncodingUTF8:
+		return []byte(data), nil
+	case EncodingBase64:
+		return base64.StdEncoding.DecodeString(data)
+	case EncodingHex:
+		return hex.DecodeString(data)
+	default:
+		return nil, fmt.Errorf("unknown data_encoding %q, expected utf8, base64 or hex", encoding)
+	}
+}
+
+// encodeData encodes signed data for a response in the encoding of the request data, so that binary data
+// survives the JSON response.
+func encodeData(encoding string, data []byte) string {
+	switch encoding {
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(data)
+	case EncodingHex:
+		return hex.EncodeToString(data)
+	default:
+		return string(data)
+	}
+}
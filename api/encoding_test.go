@@ -0,0 +1,54 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+func TestServer_SignBinaryData(t *testing.T) {
+	ts := httptest.NewServer(NewServer(":8080", persistence.GetInMemoryDB()).Handler())
+	defer ts.Close()
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC", DataFormat: "v2"}, &device)
+	if device.DataFormat != "v2" {
+		t.Fatal("Device must use the selected data format, got", device.DataFormat)
+	}
+
+	receipt := []byte{0x00, '_', 0xff, 0x10}
+	for encoding, encode := range map[string]func([]byte) string{
+		EncodingBase64: base64.StdEncoding.EncodeToString,
+		EncodingHex:    hex.EncodeToString,
+	} {
+		var signed SignDataResponse
+		code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: encode(receipt), DataEncoding: encoding}, &signed)
+		if code != http.StatusOK {
+			t.Fatal("Signing", encoding, "data failed with", code)
+		}
+		securedData, err := decodeData(encoding, signed.SignedData)
+		if err != nil {
+			t.Fatal("Signed data must be encoded like the request data:", err)
+		}
+		parsed, err := domain.ParseSecuredData(securedData)
+		if err != nil || !bytes.Equal(parsed.Data, receipt) {
+			t.Error("Signed data must contain the decoded receipt, got", parsed, err)
+		}
+	}
+
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "zz", DataEncoding: EncodingHex}, nil); code != http.StatusBadRequest {
+		t.Error("Invalid hex must be rejected, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "a", DataEncoding: "utf16"}, nil); code != http.StatusBadRequest {
+		t.Error("Unknown encoding must be rejected, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC", DataFormat: "v9"}, nil); code != http.StatusBadRequest {
+		t.Error("Unknown data format must be rejected, got", code)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/export"
+	"github.com/google/uuid"
+)
+
+// ExportDevice handles a request for an archive of a device and its transaction journal.
+// The archive format is selected with the format query parameter (tar or zip, default tar).
+// The manifest of the archive is signed with the device key, so exporting requires the sign permission.
+func (s *Server) ExportDevice(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	id, err := uuid.Parse(strings.TrimSuffix(strings.TrimPrefix(request.URL.Path, "/api/v0/devices/"), "/export"))
+	if err != nil {
+		http.Error(response, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+	format, err := export.ParseFormat(request.URL.Query().Get("format"))
+	if err != nil {
+		WriteErrorResponse(response, http.StatusBadRequest, []string{err.Error()})
+		return
+	}
+
+	device, exists := s.db.Get(organizationId(request), id)
+	if !exists {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"No device found under provided id.",
+		})
+		return
+	}
+	if !canAccessDevice(request, device.Id) {
+		s.audit(request, device.OrganizationId, ActionDeviceExport, deviceTarget(device.Id), audit.OutcomeDenied)
+		writeDeviceForbidden(response)
+		return
+	}
+
+	// The archive is built completely before anything is sent, so that failures still produce an error response.
+	// Transactions are journaled before the state advances, so reading the state first ensures the journal holds
+	// every transaction it counts.
+	var archive bytes.Buffer
+	state := device.State()
+	transactions := s.db.GetTransactions(device.OrganizationId, device.Id)
+	if err := export.Write(&archive, format, device, state, transactions, time.Now()); err != nil {
+		s.requestLogger(request).Error("Exporting device failed", "device", device, "error", err)
+		s.audit(request, device.OrganizationId, ActionDeviceExport, deviceTarget(device.Id), audit.OutcomeFailure)
+		WriteInternalError(response)
+		return
+	}
+	s.audit(request, device.OrganizationId, ActionDeviceExport, deviceTarget(device.Id), audit.OutcomeSuccess)
+
+	response.Header().Set("Content-Type", format.ContentType())
+	response.Header().Set("Content-Disposition", `attachment; filename="device-`+device.Id.String()+"."+string(format)+`"`)
+	response.WriteHeader(http.StatusOK)
+	response.Write(archive.Bytes())
+}
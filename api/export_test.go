@@ -0,0 +1,70 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/export"
+)
+
+func TestExport_DeviceArchive(t *testing.T) {
+	ts := initAuthenticatedServer(t)
+	keyA := createOrganizationKey(t, ts.URL, "Tenant A", "create", "sign", "read", "admin")
+	keyB := createOrganizationKey(t, ts.URL, "Tenant B", "sign", "read")
+	var readerKey APIKeyResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/admin/keys", keyA.Secret, APIKeyRequest{
+		Permissions: []string{"read"},
+		AllDevices:  true,
+	}, &readerKey)
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", keyA.Secret, SignatureDeviceRequest{Algorithm: "RSA", Label: "A"}, &device)
+	for _, data := range []string{"first", "second", "third"} {
+		sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", keyA.Secret, SignDataRequest{Id: device.Id, Data: data}, nil)
+	}
+
+	exportURL := ts.URL + "/api/v0/devices/" + device.Id.String() + "/export"
+	if code := sendAuthenticatedRequest(t, http.MethodGet, exportURL, readerKey.Secret, nil, nil); code != http.StatusForbidden {
+		t.Error("Exporting must require the sign permission, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodGet, exportURL, keyB.Secret, nil, nil); code != http.StatusNotFound {
+		t.Error("Exporting a device of another tenant must return 404, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodGet, exportURL+"?format=rar", keyA.Secret, nil, nil); code != http.StatusBadRequest {
+		t.Error("Unknown export format must be rejected, got", code)
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, exportURL, nil)
+	request.Header.Set("Authorization", "Bearer "+keyA.Secret)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "application/x-tar" {
+		t.Fatal("Export failed, got", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	archive, _ := io.ReadAll(response.Body)
+	reader := tar.NewReader(bytes.NewReader(archive))
+	files := make(map[string][]byte)
+	for header, err := reader.Next(); err == nil; header, err = reader.Next() {
+		files[header.Name], _ = io.ReadAll(reader)
+	}
+	if bytes.Count(files[export.TransactionsJSONLFile], []byte("\n")) != 3 {
+		t.Error("Archive must contain all transactions of the device.")
+	}
+	if len(files[export.ManifestSignatureFile]) == 0 {
+		t.Error("Archive must contain the manifest signature.")
+	}
+
+	var entries []audit.Entry
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/audit?action="+ActionDeviceExport, keyA.Secret, nil, &entries)
+	if len(entries) != 1 || entries[0].Outcome != audit.OutcomeSuccess {
+		t.Error("Export must be audited, got", entries)
+	}
+}
@@ -1,6 +1,18 @@
 package api
 
-import "net/http"
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
 
 type HealthResponse struct {
 	Status  string `json:"status"`
@@ -17,9 +29,200 @@ func (s *Server) Health(response http.ResponseWriter, request *http.Request) {
 	}
 
 	health := HealthResponse{
-		Status:  "pass",
-		Version: "v0",
+		Status:  HealthPass,
+		Version: version(),
 	}
 
 	WriteAPIResponse(response, http.StatusOK, health)
 }
+
+// Health statuses as defined by the health check response format draft (draft-inadarei-api-health-check).
+const (
+	HealthPass = "pass"
+	HealthFail = "fail"
+	HealthWarn = "warn"
+)
+
+// HealthCheckResponse is a health check response following draft-inadarei-api-health-check.
+type HealthCheckResponse struct {
+	Status      string                         `json:"status"`
+	Version     string                         `json:"version,omitempty"`
+	ReleaseId   string                         `json:"releaseId,omitempty"`
+	Description string                         `json:"description,omitempty"`
+	Checks      map[string][]HealthCheckResult `json:"checks,omitempty"`
+}
+
+// HealthCheckResult is the result of a single check of a component.
+type HealthCheckResult struct {
+	ComponentId   string  `json:"componentId,omitempty"`
+	ComponentType string  `json:"componentType,omitempty"`
+	Status        string  `json:"status"`
+	ObservedValue float64 `json:"observedValue"`
+	ObservedUnit  string  `json:"observedUnit"`
+	Time          string  `json:"time"`
+	Output        string  `json:"output,omitempty"`
+}
+
+// readinessTimeout bounds the duration of all readiness checks together.
+const readinessTimeout = 5 * time.Second
+
+// healthCheck checks a component the service depends on.
+type healthCheck struct {
+	name          string
+	componentId   string
+	componentType string
+	check         func(ctx context.Context) error
+}
+
+// Liveness reports whether the process is able to serve requests at all. It does not check dependencies.
+func (s *Server) Liveness(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	writeHealthResponse(response, HealthCheckResponse{
+		Status:      HealthPass,
+		Version:     version(),
+		ReleaseId:   revision(),
+		Description: "signing service liveness",
+	})
+}
+
+// Readiness reports whether the service can handle traffic: the storage must be reachable,
+// the key store must be available and every allowed algorithm must complete a sign/verify round trip.
+func (s *Server) Readiness(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), readinessTimeout)
+	defer cancel()
+
+	health := HealthCheckResponse{
+		Status:      HealthPass,
+		Version:     version(),
+		ReleaseId:   revision(),
+		Description: "signing service readiness",
+		Checks:      make(map[string][]HealthCheckResult),
+	}
+	for _, check := range s.healthChecks() {
+		start := time.Now()
+		err := check.check(ctx)
+		result := HealthCheckResult{
+			ComponentId:   check.componentId,
+			ComponentType: check.componentType,
+			Status:        HealthPass,
+			ObservedValue: float64(time.Since(start).Microseconds()) / 1000,
+			ObservedUnit:  "ms",
+			Time:          start.UTC().Format(time.RFC3339),
+		}
+		if err != nil {
+			result.Status = HealthFail
+			result.Output = err.Error()
+			health.Status = HealthFail
+			s.requestLogger(request).Warn("Readiness check failed", "check", check.name, "error", err)
+		}
+		health.Checks[check.name] = append(health.Checks[check.name], result)
+	}
+
+	writeHealthResponse(response, health)
+}
+
+// healthChecks lists the readiness checks of all dependencies.
+func (s *Server) healthChecks() []healthCheck {
+	checks := []healthCheck{
+		{"storage:connectivity", "", "datastore", s.db.Ping},
+		{"keystore:availability", "", "component", checkKeyStore},
+	}
+	for _, algorithm := range s.keyPolicy.Algorithms {
+		algorithm := algorithm
+		checks = append(checks, healthCheck{"signer:roundtrip", algorithm, "component", func(ctx context.Context) error {
+			return s.probeSigners.roundTrip(s.keyPolicy, algorithm)
+		}})
+	}
+	return checks
+}
+
+// checkKeyStore verifies that the source of key material is available. Keys are generated from
+// the operating system's random number generator and kept in memory, so there is no external key store.
+func checkKeyStore(ctx context.Context) error {
+	if _, err := io.ReadFull(rand.Reader, make([]byte, 32)); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// probeSigners holds one signer per algorithm for sign/verify round trips.
+// The keys are generated once, as RSA key generation is too slow to repeat on every readiness check.
+type probeSigners struct {
+	mu      sync.Mutex
+	signers map[string]crypto.Signer
+}
+
+func (p *probeSigners) roundTrip(policy crypto.KeyPolicy, algorithm string) error {
+	p.mu.Lock()
+	signer, ok := p.signers[algorithm]
+	if !ok {
+		var err error
+		if signer, err = policy.NewSigner(algorithm); err != nil {
+			p.mu.Unlock()
+			return err
+		}
+		if p.signers == nil {
+			p.signers = make(map[string]crypto.Signer)
+		}
+		p.signers[algorithm] = signer
+	}
+	p.mu.Unlock()
+
+	probe := []byte("readiness probe " + time.Now().UTC().Format(time.RFC3339Nano))
+	signature, err := signer.Sign(probe)
+	if err != nil {
+		return err
+	}
+	if !signer.VerifySignature(probe, signature) {
+		return errors.New("signature of probe could not be verified")
+	}
+	return nil
+}
+
+func writeHealthResponse(response http.ResponseWriter, health HealthCheckResponse) {
+	code := http.StatusOK
+	if health.Status == HealthFail {
+		code = http.StatusServiceUnavailable
+	}
+
+	response.Header().Set("Content-Type", "application/health+json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(code)
+	_ = json.NewEncoder(response).Encode(health)
+}
+
+// version returns the module version the binary was built from.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+	return info.Main.Version
+}
+
+// revision returns the VCS revision the binary was built from, if known.
+func revision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+func getHealth(t *testing.T, url string) (int, HealthCheckResponse) {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != "application/health+json" {
+		t.Error("Health responses must use the application/health+json media type.")
+	}
+
+	var health HealthCheckResponse
+	_ = json.NewDecoder(res.Body).Decode(&health)
+	return res.StatusCode, health
+}
+
+func TestHealth_Liveness(t *testing.T) {
+	server := NewServer(":8080", persistence.GetInMemoryDB())
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	code, health := getHealth(t, ts.URL+"/api/v0/health/live")
+	if code != http.StatusOK || health.Status != HealthPass || health.Version == "" {
+		t.Error("Liveness check failed:", code, health)
+	}
+}
+
+func TestHealth_Readiness(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	server := NewServer(":8080", db)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	code, health := getHealth(t, ts.URL+"/api/v0/health/ready")
+	if code != http.StatusOK || health.Status != HealthPass {
+		t.Fatal("Readiness check failed:", code, health)
+	}
+	for _, name := range []string{"storage:connectivity", "keystore:availability"} {
+		if len(health.Checks[name]) != 1 || health.Checks[name][0].Status != HealthPass {
+			t.Error("Missing or failed check", name)
+		}
+	}
+	roundTrips := health.Checks["signer:roundtrip"]
+	if len(roundTrips) != 2 || roundTrips[0].ComponentId != "RSA" || roundTrips[1].ComponentId != "ECC" {
+		t.Error("Expected a sign/verify round trip per algorithm, got", roundTrips)
+	}
+
+	db.Close()
+	code, health = getHealth(t, ts.URL+"/api/v0/health/ready")
+	if code != http.StatusServiceUnavailable || health.Status != HealthFail {
+		t.Error("Readiness must fail when storage is unavailable, got", code)
+	}
+	if health.Checks["storage:connectivity"][0].Output == "" {
+		t.Error("Failed checks must report their error.")
+	}
+}
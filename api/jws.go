@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/jose"
+	"github.com/google/uuid"
+)
+
+// keyId names a key version of a device as <device id>:<key version>. The version starts at 1 and increases with
+// every rotation of the device key.
+func keyId(device *domain.SignatureDevice, version int) string {
+	return device.Id.String() + ":" + strconv.Itoa(version)
+}
+
+// WebSignaturePayload is the payload of the JWS of a transaction. Data is encoded like the data of the sign
+// request, PreviousSignature is the last signature of the device the transaction is chained to, and IssuedAt the
+// signed time in seconds since the epoch, if the data format of the device has one.
+type WebSignaturePayload struct {
+	DeviceId          uuid.UUID `json:"sub"`
+	Counter           uint64    `json:"counter"`
+	Data              string    `json:"data"`
+	PreviousSignature []byte    `json:"previous_signature"`
+	IssuedAt          int64     `json:"iat,omitempty"`
+}
+
+// webSignature signs a compact JWS of a transaction with the key of the device. The JWS is a second signature
+// beside the one of the transaction: it covers the same secured data, but is neither counted nor chained, nor
+// stored in the journal. RSA keys sign RS256 and ECC keys ES256, ES384 or ES512; PS256 and EdDSA are not supported.
+func webSignature(device *domain.SignatureDevice, encoding string, transaction domain.Transaction) (string, error) {
+	securedData, err := domain.ParseSecuredData(transaction.SignedData)
+	if err != nil {
+		return "", err
+	}
+	payload := WebSignaturePayload{
+		DeviceId:          device.Id,
+		Counter:           securedData.Counter,
+		Data:              encodeData(encoding, securedData.Data),
+		PreviousSignature: securedData.LastSig,
+	}
+	if !securedData.Time.IsZero() {
+		payload.IssuedAt = securedData.Time.Unix()
+	}
+	signer, version := device.Key()
+	return jose.Sign(signer, keyId(device, version), payload)
+}
+
+// JWKS handles a request for the public keys of all devices the API key may access as JSON Web Key Set, to verify
+// the JWS returned by the sign endpoint. Retired keys of rotated devices are published as well, so that earlier JWS
+// remain verifiable.
+func (s *Server) JWKS(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	keySet := jose.JWKS{Keys: []jose.JWK{}}
+	for _, device := range s.db.GetAll(organizationId(request)) {
+		if !canAccessDevice(request, device.Id) {
+			continue
+		}
+		for version := 1; ; version++ {
+			publicKey, ok := device.PublicKey(version)
+			if !ok {
+				break
+			}
+			jwk, err := jose.NewJWK(publicKey, keyId(device, version))
+			if err != nil {
+				s.requestLogger(request).Warn("Publishing device key failed", "device", device, "key_version", version, "error", err)
+				continue
+			}
+			keySet.Keys = append(keySet.Keys, jwk)
+		}
+	}
+
+	response.Header().Set("Content-Type", jose.ContentType)
+	response.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(response).Encode(keySet)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/jose"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+func TestServer_SignJWS(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	ts := httptest.NewServer(NewServer(":8080", db).Handler())
+	defer ts.Close()
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC", DataFormat: "v3"}, &deviceResponse)
+	device, _ := db.Get(domain.DefaultOrganizationId, deviceResponse.Id)
+
+	// The first signature of a device is chained to its id.
+	previousSignature := device.Id[:]
+	for counter := uint64(0); counter < 2; counter++ {
+		var signed SignDataResponse
+		code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "receipt", SignatureFormat: SignatureFormatJWS}, &signed)
+		if code != http.StatusOK || signed.JWS == "" {
+			t.Fatal("Expected a JWS, got", code)
+		}
+		encoded, err := jose.Verify(signed.JWS, device.Signer.GetPublicKey())
+		if err != nil {
+			t.Fatal("JWS must verify with the device key:", err)
+		}
+		var payload WebSignaturePayload
+		json.Unmarshal(encoded, &payload)
+		if payload.DeviceId != device.Id || payload.Counter != counter || payload.Data != "receipt" || payload.IssuedAt != signed.Timestamp.Unix() {
+			t.Error("Payload must describe the transaction, got", payload)
+		}
+		if !bytes.Equal(payload.PreviousSignature, previousSignature) {
+			t.Error("Payload must hold the previous signature of the device")
+		}
+		previousSignature = signed.Signature
+	}
+
+	var other SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "RSA"}, &other)
+	response, err := http.Get(ts.URL + "/api/v0/jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var keySet jose.JWKS
+	if err := json.NewDecoder(response.Body).Decode(&keySet); err != nil || response.Header.Get("Content-Type") != jose.ContentType {
+		t.Fatal("Expected a JSON Web Key Set, got", response.Header.Get("Content-Type"), err)
+	}
+	algorithms := map[string]string{}
+	for _, key := range keySet.Keys {
+		algorithms[key.KeyId] = key.Algorithm
+	}
+	if algorithms[device.Id.String()+":1"] != jose.ES384 || algorithms[other.Id.String()+":1"] != jose.RS256 {
+		t.Error("Key set must publish all device keys by device id and key version, got", algorithms)
+	}
+}
+
+// opaqueSigner hides the private key of a signer, like a signer backed by a hardware module would.
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+func TestServer_SignJWSUnsupportedSigner(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	ts := httptest.NewServer(NewServer(":8080", db).Handler())
+	defer ts.Close()
+
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "hsm", opaqueSigner{crypto.NewECCSigner()})
+	if err := db.Insert(device); err != nil {
+		t.Fatal(err)
+	}
+	code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "receipt", SignatureFormat: SignatureFormatJWS}, nil)
+	if code != http.StatusUnprocessableEntity {
+		t.Error("JWS of a signer without key must be rejected, got", code)
+	}
+	if stored, _ := db.Get(domain.DefaultOrganizationId, device.Id); stored.State().Counter != 0 {
+		t.Error("Rejected request must not sign, got counter", stored.State().Counter)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the correlation id of a request. An id sent by the client is propagated,
+// otherwise a new one is generated. It is always echoed in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client supplied request ids to keep them from flooding the logs.
+const maxRequestIDLength = 128
+
+// WithLogger sets the logger for access logs and server errors.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+		s.httpServer.ErrorLog = slog.NewLogLogger(logger.Handler(), slog.LevelWarn)
+	}
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the correlation id of the request the context belongs to.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns the server logger annotated with the correlation id of the request.
+func (s *Server) requestLogger(request *http.Request) *slog.Logger {
+	return s.logger.With("request_id", RequestID(request.Context()))
+}
+
+// withRequestLogging assigns a correlation id to every request and writes an access log entry once it is handled.
+// Only metadata is logged: request and response bodies may contain data to be signed and are never logged.
+func (s *Server) withRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+
+		requestID := request.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		response.Header().Set(RequestIDHeader, requestID)
+		request = request.WithContext(context.WithValue(request.Context(), requestIDKey{}, requestID))
+
+		recorder := &responseRecorder{ResponseWriter: response, status: http.StatusOK}
+		next.ServeHTTP(recorder, request)
+
+		s.logger.LogAttrs(request.Context(), slog.LevelInfo, "Request handled",
+			slog.String("request_id", requestID),
+			slog.String("method", request.Method),
+			slog.String("path", request.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Int("bytes", recorder.bytes),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote_addr", request.RemoteAddr),
+		)
+	})
+}
+
+// validRequestID accepts short ids of printable ASCII characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// responseRecorder remembers the status code and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the original ResponseWriter.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
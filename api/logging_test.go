@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+func TestLogging_RequestID(t *testing.T) {
+	var logs bytes.Buffer
+	logger, _ := logging.New(&logs, "debug", "json")
+	server := NewServer(":8080", persistence.GetInMemoryDB(), WithLogger(logger))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/v0/health", nil)
+	req.Header.Set(RequestIDHeader, "client-chosen-id")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Header.Get(RequestIDHeader) != "client-chosen-id" {
+		t.Error("Request id must be propagated to the response.")
+	}
+
+	res, err = http.Get(ts.URL + "/api/v0/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated := res.Header.Get(RequestIDHeader)
+	if generated == "" {
+		t.Error("Request id must be generated if none is sent.")
+	}
+
+	var entry map[string]interface{}
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("Expected one access log entry per request, got", len(lines))
+	}
+	_ = json.Unmarshal([]byte(lines[0]), &entry)
+	if entry["request_id"] != "client-chosen-id" || entry["status"] != float64(http.StatusOK) || entry["path"] != "/api/v0/health" {
+		t.Error("Access log entry incomplete:", lines[0])
+	}
+	_ = json.Unmarshal([]byte(lines[1]), &entry)
+	if entry["request_id"] != generated {
+		t.Error("Generated request id not logged.")
+	}
+}
+
+func TestLogging_NoSensitiveData(t *testing.T) {
+	var logs bytes.Buffer
+	logger, _ := logging.New(&logs, "debug", "text")
+	policy := crypto.DefaultKeyPolicy()
+	policy.Logger = logger
+	db := persistence.GetInMemoryDB()
+	server := NewServer(":8080", db, WithLogger(logger), WithKeyPolicy(policy))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	secretData := "receipt-4711-total-99.90-EUR"
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "RSA", Label: "Device1"}, &device)
+	var signed SignDataResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: secretData}, &signed)
+
+	stored, _ := db.Get(domain.DefaultOrganizationId, device.Id)
+	stored.Signer.VerifySignature([]byte("x"), []byte("not a signature"))
+	logger.Info("Device state", "device", stored, "signer", stored.Signer)
+
+	output := logs.String()
+	if !strings.Contains(output, "Data signed") || !strings.Contains(output, "Signature verification failed") {
+		t.Fatal("Expected debug logs from domain and crypto layers, got:", output)
+	}
+	if strings.Contains(output, secretData) {
+		t.Error("Data to be signed must not be logged.")
+	}
+	if strings.Contains(output, string(signed.Signature)) {
+		t.Error("Signatures must not be logged.")
+	}
+	publicKey, _ := json.Marshal(device.PublicKey)
+	modulus := strings.Split(strings.Split(string(publicKey), `"N":`)[1], ",")[0]
+	if strings.Contains(output, modulus) {
+		t.Error("Key material must not be logged.")
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+func TestMetrics_Endpoint(t *testing.T) {
+	server := NewServer(":8080", persistence.GetInMemoryDB())
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC", Label: "Device1"}, &device)
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "data"}, nil)
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices/not-a-uuid", "", nil, nil)
+
+	res, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	exposition := string(body)
+
+	for _, expected := range []string{
+		`signing_sign_requests_total{algorithm="ECC",outcome="success"} 1`,
+		`signing_sign_duration_seconds_count{algorithm="ECC"} 1`,
+		`signing_key_generation_duration_seconds_count{algorithm="ECC"} 1`,
+		`signing_devices{algorithm="ECC"} 1`,
+		`signing_device_lock_wait_seconds_count{algorithm="ECC"} 1`,
+		`signing_http_requests_total{code="200",method="POST",route="/api/v0/sign"} 1`,
+		`signing_http_requests_total{code="400",method="GET",route="/api/v0/devices/"} 1`,
+	} {
+		if !strings.Contains(exposition, expected) {
+			t.Error("Metrics do not contain", expected)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// ForwardedByHeader names the replica that forwarded a request. Forwarded requests are never forwarded again.
+const ForwardedByHeader = "X-Forwarded-By-Replica"
+
+// Ownership configures device leases for replicas sharing a repository. A replica only signs with devices it
+// holds a lease on. Requests for devices leased by another replica are forwarded to it, or redirected if Redirect
+// is set. A lease is renewed with every signature and taken over by another replica once it expires,
+// e.g. because its owner died.
+type Ownership struct {
+	// ReplicaId identifies this replica in leases.
+	ReplicaId string
+	// Address is the base URL under which other replicas and clients reach this replica.
+	Address string
+	// LeaseTTL is how long a lease stays valid without being renewed.
+	LeaseTTL time.Duration
+	// Redirect answers requests for devices owned elsewhere with 307 instead of forwarding them.
+	Redirect bool
+	// Client forwards requests to other replicas. Defaults to a client with a 10 second timeout.
+	Client *http.Client
+}
+
+// WithOwnership makes the Server coordinate devices with other replicas through leases in the repository.
+// Leases held by the replica are released on Shutdown.
+func WithOwnership(ownership Ownership) Option {
+	return func(s *Server) {
+		if ownership.Client == nil {
+			ownership.Client = &http.Client{Timeout: 10 * time.Second}
+		}
+		s.ownership = &ownership
+		s.RegisterOnShutdown(func(ctx context.Context) error {
+			return s.db.ReleaseLeases(ownership.ReplicaId)
+		})
+	}
+}
+
+// acquireDevice makes sure this replica owns the device before it signs. It returns the fencing token to sign
+// with, or false if the request was forwarded, redirected or answered with an error. Body is the request body
+// to forward. Without ownership every replica may sign and the token is 0.
+func (s *Server) acquireDevice(response http.ResponseWriter, request *http.Request, deviceId uuid.UUID, body []byte) (uint64, bool) {
+	if s.ownership == nil {
+		return 0, true
+	}
+	lease, err := s.db.AcquireLease(deviceId, s.ownership.ReplicaId, s.ownership.Address, s.ownership.LeaseTTL)
+	if errors.Is(err, domain.ErrLeaseHeld) {
+		s.forward(response, request, lease, body)
+		return 0, false
+	}
+	if err != nil {
+		s.requestLogger(request).Error("Acquiring device lease failed", "device_id", deviceId, "error", err)
+		WriteInternalError(response)
+		return 0, false
+	}
+	return lease.Token, true
+}
+
+// forward passes a request on to the replica owning the device, or redirects the client there.
+func (s *Server) forward(response http.ResponseWriter, request *http.Request, lease domain.Lease, body []byte) {
+	target := lease.Address + request.URL.RequestURI()
+	logger := s.requestLogger(request).With("device_id", lease.DeviceId, "owner", lease.Owner)
+	if request.Header.Get(ForwardedByHeader) != "" {
+		logger.Warn("Forwarded request reached a replica that does not own the device")
+		writeOwnerUnavailable(response, lease)
+		return
+	}
+	if s.ownership.Redirect {
+		http.Redirect(response, request, target, http.StatusTemporaryRedirect)
+		return
+	}
+
+	forwarded, err := http.NewRequestWithContext(request.Context(), request.Method, target, bytes.NewReader(body))
+	if err != nil {
+		WriteInternalError(response)
+		return
+	}
+	for _, header := range []string{"Authorization", "X-API-Key", "Content-Type"} {
+		if value := request.Header.Get(header); value != "" {
+			forwarded.Header.Set(header, value)
+		}
+	}
+	forwarded.Header.Set(RequestIDHeader, RequestID(request.Context()))
+	forwarded.Header.Set(ForwardedByHeader, s.ownership.ReplicaId)
+
+	ownerResponse, err := s.ownership.Client.Do(forwarded)
+	if err != nil {
+		logger.Warn("Forwarding request to device owner failed", "error", err)
+		writeOwnerUnavailable(response, lease)
+		return
+	}
+	defer ownerResponse.Body.Close()
+	logger.Debug("Request forwarded to device owner")
+	if contentType := ownerResponse.Header.Get("Content-Type"); contentType != "" {
+		response.Header().Set("Content-Type", contentType)
+	}
+	response.WriteHeader(ownerResponse.StatusCode)
+	io.Copy(response, ownerResponse.Body)
+}
+
+// writeOwnerUnavailable asks the client to retry once the lease of the unreachable owner expired.
+func writeOwnerUnavailable(response http.ResponseWriter, lease domain.Lease) {
+	retryAfter := int(time.Until(lease.ExpiresAt).Seconds()) + 1
+	response.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	WriteErrorResponse(response, http.StatusServiceUnavailable, []string{
+		"The replica owning the device is unavailable, please retry.",
+	})
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+// initReplica starts a server that shares db with other replicas.
+func initReplica(t *testing.T, db *persistence.InMemoryDB, replicaId string, redirect bool) *httptest.Server {
+	ts := httptest.NewUnstartedServer(nil)
+	server := NewServer(":8080", db, WithOwnership(Ownership{
+		ReplicaId: replicaId,
+		Address:   "http://" + ts.Listener.Addr().String(),
+		LeaseTTL:  200 * time.Millisecond,
+		Redirect:  redirect,
+	}))
+	ts.Config.Handler = server.Handler()
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestOwnership_ForwardsToOwner(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	replicaA := initReplica(t, db, "a", false)
+	replicaB := initReplica(t, db, "b", false)
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, replicaA.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC", Label: ""}, &device)
+
+	for i, replica := range []*httptest.Server{replicaB, replicaA, replicaB} {
+		var signed SignDataResponse
+		code := sendAuthenticatedRequest(t, http.MethodPost, replica.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "data"}, &signed)
+		if code != http.StatusOK || signed.SignedData == "" {
+			t.Fatal("Signing through any replica must succeed, got", code)
+		}
+		if transactions := db.GetTransactions(domain.DefaultOrganizationId, device.Id); transactions[i].FencingToken != 1 {
+			t.Error("All signatures must be created by the owner with its lease, got token", transactions[i].FencingToken)
+		}
+	}
+}
+
+func TestOwnership_RedirectsToOwner(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	replicaA := initReplica(t, db, "a", false)
+	replicaB := initReplica(t, db, "b", true)
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, replicaA.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC", Label: ""}, &device)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	body, _ := json.Marshal(SignDataRequest{Id: device.Id, Data: "data"})
+	res, err := client.Post(replicaB.URL+"/api/v0/sign", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTemporaryRedirect || res.Header.Get("Location") != replicaA.URL+"/api/v0/sign" {
+		t.Error("Request must be redirected to the owner, got", res.StatusCode, res.Header.Get("Location"))
+	}
+}
+
+func TestOwnership_TakesOverLeaseOfDeadReplica(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	replicaA := initReplica(t, db, "a", false)
+	replicaB := initReplica(t, db, "b", false)
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, replicaA.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC", Label: ""}, &device)
+	sendAuthenticatedRequest(t, http.MethodPost, replicaA.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "data"}, nil)
+	replicaA.Close()
+
+	if code := sendAuthenticatedRequest(t, http.MethodPost, replicaB.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "data"}, nil); code != http.StatusServiceUnavailable {
+		t.Error("Device must be unavailable until the lease of the dead owner expired, got", code)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if code := sendAuthenticatedRequest(t, http.MethodPost, replicaB.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "data"}, nil); code != http.StatusOK {
+		t.Fatal("Replica must take over the expired lease, got", code)
+	}
+	transactions := db.GetTransactions(domain.DefaultOrganizationId, device.Id)
+	if len(transactions) != 2 || transactions[1].Counter != 1 || transactions[1].FencingToken != 2 {
+		t.Error("Takeover must continue the chain with a new fencing token, got", transactions)
+	}
+}
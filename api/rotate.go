@@ -0,0 +1,70 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// RotateDeviceKeyResponse describes a device after its key was rotated. Certificate is the certificate the
+// certificate authority of the server issued for the new key, if the server has one.
+type RotateDeviceKeyResponse struct {
+	SignatureDeviceResponse
+	Certificate *CertificateChainResponse `json:"certificate,omitempty"`
+	Warnings    []string                  `json:"warnings,omitempty"`
+}
+
+// RotateDeviceKey handles a request to replace the key of a device by a new one of the same algorithm at
+// /api/v0/devices/{id}/rotate. The key version increases by one; the previous key signs no more transactions but
+// remains published to verify those it signed. Chains certifying the previous key are no longer current, so the
+// certificate authority of the server, if any, certifies the new key right away.
+func (s *Server) RotateDeviceKey(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+	device, ok := s.pathDevice(response, request, "/rotate")
+	if !ok {
+		return
+	}
+	if _, owned := s.acquireDevice(response, request, device.Id, nil); !owned {
+		return
+	}
+
+	signer, err := s.keyPolicy.NewSigner(device.Algorithm())
+	if err == nil {
+		_, err = device.RotateKey(signer)
+	}
+	if err != nil {
+		s.requestLogger(request).Error("Rotating device key failed", "device", device, "error", err)
+		s.audit(request, device.OrganizationId, ActionDeviceRotate, deviceTarget(device.Id), audit.OutcomeFailure)
+		if errors.Is(err, domain.ErrMigrated) {
+			writeSignError(response, err)
+			return
+		}
+		WriteInternalError(response)
+		return
+	}
+	s.audit(request, device.OrganizationId, ActionDeviceRotate, deviceTarget(device.Id), audit.OutcomeSuccess)
+
+	rotateResponse := RotateDeviceKeyResponse{SignatureDeviceResponse: s.signatureDeviceResponse(device)}
+	if s.authority != nil {
+		// The key is rotated either way; a failed certification can be retried with IssueCertificate.
+		chain, err := s.certify(request, device)
+		var chainResponse CertificateChainResponse
+		if err == nil {
+			chainResponse, err = newCertificateChainResponse(device, chain)
+		}
+		if err != nil {
+			s.requestLogger(request).Error("Certifying rotated device key failed", "device", device, "error", err)
+			rotateResponse.Warnings = append(rotateResponse.Warnings, "Key was rotated, but certifying the new key failed.")
+		} else {
+			rotateResponse.Certificate = &chainResponse
+		}
+	}
+	WriteAPIResponse(response, http.StatusOK, rotateResponse)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/jose"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+// jwsKeyId returns the key id in the protected header of a compact JWS.
+func jwsKeyId(t *testing.T, jws string) string {
+	t.Helper()
+	encoded, _ := base64.RawURLEncoding.DecodeString(strings.Split(jws, ".")[0])
+	var header struct {
+		KeyId string `json:"kid"`
+	}
+	if err := json.Unmarshal(encoded, &header); err != nil {
+		t.Fatal(err)
+	}
+	return header.KeyId
+}
+
+func TestRotate_NewKeyIsCertifiedAndPublished(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	authority, err := ca.Load("", "", ca.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewServer(":8080", db, WithCertificateAuthority(authority)).Handler())
+	defer ts.Close()
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC"}, &deviceResponse)
+	device, _ := db.Get(domain.DefaultOrganizationId, deviceResponse.Id)
+	previousKey := device.Signer.GetPublicKey()
+	var before SignDataResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "before", SignatureFormat: SignatureFormatJWS}, &before)
+	if deviceResponse.KeyVersion != 1 || jwsKeyId(t, before.JWS) != device.Id.String()+":1" {
+		t.Fatal("New device must sign with key version 1, got", deviceResponse.KeyVersion, jwsKeyId(t, before.JWS))
+	}
+
+	deviceURL := ts.URL + "/api/v0/devices/" + device.Id.String()
+	var rotated RotateDeviceKeyResponse
+	if code := sendAuthenticatedRequest(t, http.MethodPost, deviceURL+"/rotate", "", nil, &rotated); code != http.StatusOK {
+		t.Fatal("Expected the key to be rotated, got", code)
+	}
+	if rotated.KeyVersion != 2 || rotated.Algorithm != "ECC" {
+		t.Fatal("Rotation must bump the key version and keep the algorithm, got", rotated.SignatureDeviceResponse)
+	}
+	if rotated.Certificate == nil || rotated.Certificate.KeyVersion != 2 {
+		t.Fatal("Rotation must certify the new key, got", rotated.Certificate, rotated.Warnings)
+	}
+	if chain := decodeChain(t, rotated.Certificate.Chain); !authority.Certifies(chain[0], device) {
+		t.Error("Certificate must be issued for the new key")
+	}
+	var current CertificateChainResponse
+	sendAuthenticatedRequest(t, http.MethodGet, deviceURL+"/certificate", "", nil, &current)
+	if current.SerialNumber != rotated.Certificate.SerialNumber || current.KeyVersion != 2 {
+		t.Error("Chain endpoint must serve the certificate of the new key, got", current)
+	}
+
+	var after SignDataResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "after", SignatureFormat: SignatureFormatJWS}, &after)
+	if jwsKeyId(t, after.JWS) != device.Id.String()+":2" {
+		t.Error("Rotated device must sign with key version 2, got", jwsKeyId(t, after.JWS))
+	}
+
+	response, err := http.Get(ts.URL + "/api/v0/jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var keySet jose.JWKS
+	json.NewDecoder(response.Body).Decode(&keySet)
+	published := map[string]bool{}
+	for _, key := range keySet.Keys {
+		published[key.KeyId] = true
+	}
+	if !published[device.Id.String()+":1"] || !published[device.Id.String()+":2"] {
+		t.Fatal("Key set must publish the retired and the current key, got", published)
+	}
+	if _, err := jose.Verify(before.JWS, previousKey); err != nil {
+		t.Error("Signatures of the retired key must still verify:", err)
+	}
+	if _, err := jose.Verify(after.JWS, device.Signer.GetPublicKey()); err != nil {
+		t.Error("Signatures after the rotation must verify with the new key:", err)
+	}
+	if err := domain.VerifyChain(device.Id, device, db.GetTransactions(device.OrganizationId, device.Id)); err != nil {
+		t.Error("Journal must verify across the rotation:", err)
+	}
+}
@@ -1,9 +1,25 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
-	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/anchor"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/auth"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/metrics"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
 )
 
 // Response is the generic API response container.
@@ -20,27 +36,173 @@ type ErrorResponse struct {
 type Server struct {
 	listenAddress string
 	db            *persistence.InMemoryDB
+	keyPolicy     crypto.KeyPolicy
+	keys          *auth.KeyStore
+	metrics       *metrics.Metrics
+	auditLog      *audit.Log
+	logger        *slog.Logger
+	backupKey     []byte
+	ownership     *Ownership
+	clock         domain.Clock
+	timestamps    TimestampAuthority
+	anchors       *anchor.Log
+	authority     *ca.Authority
+	probeSigners  probeSigners
+	httpServer    *http.Server
+
+	hooksMu       sync.Mutex
+	shutdownHooks []func(context.Context) error
+}
+
+// Option customizes a Server created by NewServer.
+type Option func(*Server)
+
+// WithKeyPolicy sets the policy used to generate keys for new signature devices.
+func WithKeyPolicy(policy crypto.KeyPolicy) Option {
+	return func(s *Server) {
+		s.keyPolicy = policy
+	}
+}
+
+// WithTimeouts sets the read, write and idle timeouts of the underlying http.Server.
+func WithTimeouts(read, write, idle time.Duration) Option {
+	return func(s *Server) {
+		s.httpServer.ReadTimeout = read
+		s.httpServer.ReadHeaderTimeout = read
+		s.httpServer.WriteTimeout = write
+		s.httpServer.IdleTimeout = idle
+	}
+}
+
+// WithMetrics makes the Server record its metrics in m, e.g. to share them with other components.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithClock makes the Server and its new devices take the time of signatures from clock.
+func WithClock(clock domain.Clock) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
 }
 
 // NewServer is a factory to instantiate a new Server.
-func NewServer(listenAddress string, db *persistence.InMemoryDB) *Server {
-	return &Server{
+func NewServer(listenAddress string, db *persistence.InMemoryDB, options ...Option) *Server {
+	server := &Server{
 		listenAddress: listenAddress,
 		db:            db,
+		keyPolicy:     crypto.DefaultKeyPolicy(),
+		metrics:       metrics.New(),
+		auditLog:      audit.NewLog(),
+		logger:        logging.Discard(),
+		clock:         domain.SystemClock{},
+		httpServer: &http.Server{
+			Addr:              listenAddress,
+			ErrorLog:          slog.NewLogLogger(logging.Discard().Handler(), slog.LevelWarn),
+			ReadTimeout:       10 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+		},
 	}
+	for _, option := range options {
+		option(server)
+	}
+	// Devices restored by the database on startup count like newly created ones.
+	for _, organization := range db.GetOrganizations() {
+		for _, device := range db.GetAll(organization.Id) {
+			server.metrics.DeviceAdded(device.Algorithm())
+		}
+	}
+	server.httpServer.Handler = server.Handler()
+	return server
 }
 
-// Run registers all HandlerFuncs for the existing HTTP routes and starts the Server.
-func (s *Server) Run() error {
+// Handler registers all HandlerFuncs for the existing HTTP routes.
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
+	handle := func(route string, handler http.Handler) {
+		mux.Handle(route, s.metrics.InstrumentHandler(route, handler))
+	}
 
-	mux.Handle("/api/v0/health", http.HandlerFunc(s.Health))
-	mux.Handle("/api/v0/new", http.HandlerFunc(s.CreateSignatureDevice))
-	mux.Handle("/api/v0/sign", http.HandlerFunc(s.SignData))
-	mux.Handle("/api/v0/devices", http.HandlerFunc(s.GetDevices))
-	mux.Handle("/api/v0/devices/", http.HandlerFunc(s.GetDevice))
+	handle("/api/v0/health", http.HandlerFunc(s.Health))
+	handle("/api/v0/health/live", http.HandlerFunc(s.Liveness))
+	handle("/api/v0/health/ready", http.HandlerFunc(s.Readiness))
+	handle("/api/v0/new", s.authenticate(auth.PermissionCreate, s.CreateSignatureDevice))
+	handle("/api/v0/sign", s.authenticate(auth.PermissionSign, s.SignData))
+	handle("/api/v0/devices", s.authenticate(auth.PermissionRead, s.GetDevices))
+	handle("/api/v0/jwks", s.authenticate(auth.PermissionRead, s.JWKS))
+	handle("/api/v0/devices/", s.deviceRoutes())
+	handle("/api/v0/audit", s.authenticate(auth.PermissionAdmin, s.AuditLog))
+
+	if s.keys != nil {
+		handle("/api/v0/admin/keys", s.authenticate(auth.PermissionAdmin, s.APIKeys))
+		handle("/api/v0/admin/keys/", s.authenticate(auth.PermissionAdmin, s.APIKey))
+		handle("/api/v0/admin/organizations", s.authenticate(auth.PermissionAdmin, s.Organizations))
+	}
+	if s.anchors != nil {
+		handle("/api/v0/anchors", s.authenticate(auth.PermissionRead, s.Anchors))
+		handle("/api/v0/anchors/consistency", s.authenticate(auth.PermissionRead, s.ConsistencyProof))
+	}
+	if s.backupKey != nil {
+		handle("/api/v0/admin/devices/", s.authenticate(auth.PermissionAdmin, s.BackupDevice))
+		handle("/api/v0/admin/devices/restore", s.authenticate(auth.PermissionAdmin, s.RestoreDevice))
+	}
+
+	mux.Handle("/metrics", s.metrics.Handler())
+
+	return s.withRequestLogging(withClientSubject(mux))
+}
+
+// Run starts the Server on its listen address.
+// It blocks until the Server fails or is stopped by Shutdown, in which case nil is returned.
+func (s *Server) Run() error {
+	listener, err := net.Listen("tcp", s.listenAddress)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
 
-	return http.ListenAndServe(s.listenAddress, mux)
+// Serve accepts connections on the given listener. It behaves like Run otherwise.
+// If TLS is configured, the connections are wrapped in TLS.
+func (s *Server) Serve(listener net.Listener) error {
+	if s.httpServer.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.httpServer.TLSConfig)
+	}
+	err := s.httpServer.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// RegisterOnShutdown adds a hook that runs during Shutdown once all in-flight requests are finished.
+// Hooks run in registration order, e.g. to flush and close storage.
+func (s *Server) RegisterOnShutdown(hook func(ctx context.Context) error) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.shutdownHooks = append(s.shutdownHooks, hook)
+}
+
+// Shutdown stops accepting new connections, waits for in-flight requests to finish and runs the shutdown hooks.
+// If the context expires before all requests are drained, the hooks still run and the context error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	errs := []error{s.httpServer.Shutdown(ctx)}
+
+	s.hooksMu.Lock()
+	hooks := append([]func(context.Context) error(nil), s.shutdownHooks...)
+	s.hooksMu.Unlock()
+
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
+			s.logger.Error("Shutdown hook failed", "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // WriteInternalError writes a default internal error message as an HTTP response.
@@ -1,13 +1,20 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
 )
 
@@ -43,10 +50,7 @@ func TestServer_CreateDevice(t *testing.T) {
 	ts := initServer(db)
 	defer ts.Close()
 
-	requestData := SignatureDeviceRequest{
-		"RSA",
-		"Device1",
-	}
+	requestData := SignatureDeviceRequest{Algorithm: "RSA", Label: "Device1"}
 	body, _ := json.Marshal(requestData)
 	res, err := sendPostRequest(ts.URL+"/api/v0/new", body)
 	if err != nil {
@@ -75,15 +79,9 @@ func TestServer_CreateAndGetDevices(t *testing.T) {
 	ts := initServer(db)
 	defer ts.Close()
 
-	requestData1 := SignatureDeviceRequest{
-		"RSA",
-		"Device1",
-	}
+	requestData1 := SignatureDeviceRequest{Algorithm: "RSA", Label: "Device1"}
 
-	requestData2 := SignatureDeviceRequest{
-		"ECC",
-		"Device2",
-	}
+	requestData2 := SignatureDeviceRequest{Algorithm: "ECC", Label: "Device2"}
 	body, _ := json.Marshal(requestData1)
 	res, err := sendPostRequest(ts.URL+"/api/v0/new", body)
 	if err != nil {
@@ -115,15 +113,9 @@ func TestServer_CreateAndGetSpecificDevice(t *testing.T) {
 	ts := initServer(db)
 	defer ts.Close()
 
-	requestData1 := SignatureDeviceRequest{
-		"RSA",
-		"Device1",
-	}
+	requestData1 := SignatureDeviceRequest{Algorithm: "RSA", Label: "Device1"}
 
-	requestData2 := SignatureDeviceRequest{
-		"ECC",
-		"Device2",
-	}
+	requestData2 := SignatureDeviceRequest{Algorithm: "ECC", Label: "Device2"}
 	body, _ := json.Marshal(requestData1)
 	res, err := sendPostRequest(ts.URL+"/api/v0/new", body)
 	if err != nil {
@@ -160,15 +152,9 @@ func TestServer_CreateDevicesAndSignatures(t *testing.T) {
 	ts := initServer(db)
 	defer ts.Close()
 
-	requestData1 := SignatureDeviceRequest{
-		"RSA",
-		"Device1",
-	}
+	requestData1 := SignatureDeviceRequest{Algorithm: "RSA", Label: "Device1"}
 
-	requestData2 := SignatureDeviceRequest{
-		"ECC",
-		"Device2",
-	}
+	requestData2 := SignatureDeviceRequest{Algorithm: "ECC", Label: "Device2"}
 	body, _ := json.Marshal(requestData1)
 	res, err := sendPostRequest(ts.URL+"/api/v0/new", body)
 	if err != nil {
@@ -193,10 +179,7 @@ func TestServer_CreateDevicesAndSignatures(t *testing.T) {
 	_ = json.Unmarshal(dataBytes, &deviceResponse)
 	idDevice2 := deviceResponse.Id
 
-	requestSignData1 := SignDataRequest{
-		idDevice1,
-		"Hello World to Device1",
-	}
+	requestSignData1 := SignDataRequest{Id: idDevice1, Data: "Hello World to Device1"}
 	body, _ = json.Marshal(requestSignData1)
 	res, err = sendPostRequest(ts.URL+"/api/v0/sign", body)
 	if err != nil {
@@ -208,7 +191,7 @@ func TestServer_CreateDevicesAndSignatures(t *testing.T) {
 	dataBytes, _ = json.Marshal(response.Data)
 	_ = json.Unmarshal(dataBytes, &signDataResponse)
 
-	device1, _ := db.Get(idDevice1)
+	device1, _ := db.Get(domain.DefaultOrganizationId, idDevice1)
 	if !device1.Signer.VerifySignature([]byte(signDataResponse.SignedData), signDataResponse.Signature) {
 		t.Error("Failed to verify signature from device1.")
 	}
@@ -221,10 +204,7 @@ func TestServer_CreateDevicesAndSignatures(t *testing.T) {
 		t.Error("Device1 state not updated properly.")
 	}
 
-	requestSignData1 = SignDataRequest{
-		idDevice1,
-		"Hello World to Device1 again!",
-	}
+	requestSignData1 = SignDataRequest{Id: idDevice1, Data: "Hello World to Device1 again!"}
 	body, _ = json.Marshal(requestSignData1)
 	res, err = sendPostRequest(ts.URL+"/api/v0/sign", body)
 	if err != nil {
@@ -247,10 +227,7 @@ func TestServer_CreateDevicesAndSignatures(t *testing.T) {
 		t.Error("Device1 state not updated properly.")
 	}
 
-	requestSignData2 := SignDataRequest{
-		idDevice2,
-		"Hello World to Device2",
-	}
+	requestSignData2 := SignDataRequest{Id: idDevice2, Data: "Hello World to Device2"}
 	body, _ = json.Marshal(requestSignData2)
 	res, err = sendPostRequest(ts.URL+"/api/v0/sign", body)
 	if err != nil {
@@ -261,7 +238,7 @@ func TestServer_CreateDevicesAndSignatures(t *testing.T) {
 	dataBytes, _ = json.Marshal(response.Data)
 	_ = json.Unmarshal(dataBytes, &signDataResponse)
 
-	device2, _ := db.Get(idDevice2)
+	device2, _ := db.Get(domain.DefaultOrganizationId, idDevice2)
 	if !device2.Signer.VerifySignature([]byte(signDataResponse.SignedData), signDataResponse.Signature) {
 		t.Error("Failed to verify signature from device2.")
 	}
@@ -274,10 +251,7 @@ func TestServer_CreateDevicesAndSignatures(t *testing.T) {
 		t.Error("Device2 state not updated properly.")
 	}
 
-	requestSignData2 = SignDataRequest{
-		idDevice2,
-		"Hello World to Device2 again!",
-	}
+	requestSignData2 = SignDataRequest{Id: idDevice2, Data: "Hello World to Device2 again!"}
 	body, _ = json.Marshal(requestSignData2)
 	res, err = sendPostRequest(ts.URL+"/api/v0/sign", body)
 	if err != nil {
@@ -314,3 +288,135 @@ func TestServer_CreateDevicesAndSignatures(t *testing.T) {
 	}
 
 }
+
+func TestServer_GracefulShutdown(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	server := NewServer("127.0.0.1:0", db)
+	hookCalled := make(chan struct{})
+	server.RegisterOnShutdown(func(ctx context.Context) error {
+		close(hookCalled)
+		return nil
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- server.Serve(listener)
+	}()
+
+	// Start a request whose body is still outstanding, so the handler is in flight during shutdown.
+	body, _ := json.Marshal(SignatureDeviceRequest{Algorithm: "ECC", Label: "Device1"})
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "POST /api/v0/new HTTP/1.1\r\nHost: test\r\nContent-Length: %d\r\n\r\n", len(body))
+	time.Sleep(50 * time.Millisecond)
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(context.Background())
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-shutdownErr:
+		t.Fatal("Shutdown must wait for in-flight requests.")
+	case <-hookCalled:
+		t.Fatal("Shutdown hooks must run after in-flight requests are finished.")
+	default:
+	}
+
+	conn.Write(body)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Error("In-flight request must be completed.")
+	}
+
+	if err := <-shutdownErr; err != nil {
+		t.Error(err)
+	}
+	if err := <-runErr; err != nil {
+		t.Error("Serve must return nil after shutdown, got:", err)
+	}
+	select {
+	case <-hookCalled:
+	default:
+		t.Error("Shutdown hook not called.")
+	}
+	if len(db.GetAll(domain.DefaultOrganizationId)) != 1 {
+		t.Error("In-flight request must be processed.")
+	}
+}
+
+func TestServer_SignFailsWithoutPersistence(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	ts := initServer(db)
+	defer ts.Close()
+
+	body, _ := json.Marshal(SignatureDeviceRequest{Algorithm: "ECC", Label: "Device1"})
+	res, err := sendPostRequest(ts.URL+"/api/v0/new", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response Response
+	_ = json.NewDecoder(res.Body).Decode(&response)
+	var deviceResponse SignatureDeviceResponse
+	dataBytes, _ := json.Marshal(response.Data)
+	_ = json.Unmarshal(dataBytes, &deviceResponse)
+
+	body, _ = json.Marshal(SignDataRequest{Id: deviceResponse.Id, Data: "data"})
+	sendPostRequest(ts.URL+"/api/v0/sign", body)
+	device, _ := db.Get(domain.DefaultOrganizationId, deviceResponse.Id)
+	lastSig := device.LastSig
+
+	db.Close()
+	res, err = sendPostRequest(ts.URL+"/api/v0/sign", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Error("Signing must fail if the transaction cannot be stored, got", res.StatusCode)
+	}
+	if device.SignatureCounter != 1 || !bytes.Equal(device.LastSig, lastSig) {
+		t.Error("A signature that was not stored must not advance the device.")
+	}
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestServer_SignedTimestamp(t *testing.T) {
+	now := time.Date(2026, 10, 19, 8, 30, 15, 250e6, time.UTC)
+	ts := httptest.NewServer(NewServer(":8080", persistence.GetInMemoryDB(), WithClock(fixedClock(now))).Handler())
+	defer ts.Close()
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC"}, &device)
+	var signed SignDataResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "receipt"}, &signed)
+
+	if !strings.HasPrefix(signed.SignedData, "v3:0:2026-10-19T08:30:15.250Z:7:receipt:") {
+		t.Error("Secured data must contain the signing time, got", signed.SignedData)
+	}
+	if signed.Timestamp == nil || !signed.Timestamp.Equal(now) || !signed.StartedAt.Equal(now) || !signed.FinishedAt.Equal(now) {
+		t.Error("Response must contain the signed time and processing times, got", signed.Timestamp, signed.StartedAt, signed.FinishedAt)
+	}
+
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC", DataFormat: "v1"}, &device)
+	var untimed SignDataResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: device.Id, Data: "receipt"}, &untimed)
+	if untimed.SignedData == "" || untimed.Timestamp != nil {
+		t.Error("Data formats without time must not report a signed time.")
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+)
+
+// SuspendDevice handles a request to suspend a device at /api/v0/devices/{id}/suspend, e.g. while it is suspected
+// to be compromised. A suspended device keeps its state but signs nothing and cannot be backed up until it is
+// resumed; its key can still be rotated.
+func (s *Server) SuspendDevice(response http.ResponseWriter, request *http.Request) {
+	s.setSuspended(response, request, "/suspend", true, ActionDeviceSuspend)
+}
+
+// ResumeDevice handles a request to resume a suspended device at /api/v0/devices/{id}/resume.
+func (s *Server) ResumeDevice(response http.ResponseWriter, request *http.Request) {
+	s.setSuspended(response, request, "/resume", false, ActionDeviceResume)
+}
+
+// setSuspended suspends or resumes the device of a request to /api/v0/devices/{id}/<suffix> and audits it as action.
+func (s *Server) setSuspended(response http.ResponseWriter, request *http.Request, suffix string, suspended bool, action string) {
+	if request.Method != http.MethodPost {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+	device, ok := s.pathDevice(response, request, suffix)
+	if !ok {
+		return
+	}
+
+	if err := s.db.SuspendDevice(device.Id, suspended); err != nil {
+		s.requestLogger(request).Error("Suspending device failed", "device", device, "suspended", suspended, "error", err)
+		s.audit(request, device.OrganizationId, action, deviceTarget(device.Id), audit.OutcomeFailure)
+		WriteInternalError(response)
+		return
+	}
+	s.requestLogger(request).Info("Device suspension changed", "device", device, "suspended", suspended)
+	s.audit(request, device.OrganizationId, action, deviceTarget(device.Id), audit.OutcomeSuccess)
+	WriteAPIResponse(response, http.StatusOK, s.signatureDeviceResponse(device))
+}
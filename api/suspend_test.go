@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+)
+
+func TestSuspend_SuspendedDeviceDoesNotSign(t *testing.T) {
+	ts := initAuthenticatedServer(t)
+	adminKey := createOrganizationKey(t, ts.URL, "Tenant A", "admin")
+	var tenantKey APIKeyResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/admin/keys", adminKey.Secret, APIKeyRequest{
+		Permissions: []string{"create", "sign", "read"},
+		AllDevices:  true,
+	}, &tenantKey)
+
+	var device SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", tenantKey.Secret, SignatureDeviceRequest{Algorithm: "ECC"}, &device)
+	deviceURL := ts.URL + "/api/v0/devices/" + device.Id.String()
+	if code := sendAuthenticatedRequest(t, http.MethodPost, deviceURL+"/suspend", tenantKey.Secret, nil, nil); code != http.StatusForbidden {
+		t.Error("Suspending must require the admin permission, got", code)
+	}
+
+	var suspended SignatureDeviceResponse
+	if code := sendAuthenticatedRequest(t, http.MethodPost, deviceURL+"/suspend", adminKey.Secret, nil, &suspended); code != http.StatusOK || !suspended.Suspended {
+		t.Fatal("Expected the device to be suspended, got", code, suspended.Suspended)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", tenantKey.Secret, SignDataRequest{Id: device.Id, Data: "data"}, nil); code != http.StatusConflict {
+		t.Error("Suspended device must not sign, got", code)
+	}
+
+	var resumed SignatureDeviceResponse
+	if code := sendAuthenticatedRequest(t, http.MethodPost, deviceURL+"/resume", adminKey.Secret, nil, &resumed); code != http.StatusOK || resumed.Suspended {
+		t.Fatal("Expected the device to be resumed, got", code, resumed.Suspended)
+	}
+	var signed SignDataResponse
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", tenantKey.Secret, SignDataRequest{Id: device.Id, Data: "data"}, &signed); code != http.StatusOK {
+		t.Error("Resumed device must sign, got", code)
+	}
+
+	var entries []audit.Entry
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/audit", adminKey.Secret, nil, &entries)
+	actions := map[string]string{}
+	for _, entry := range entries {
+		actions[entry.Action] = entry.Outcome
+	}
+	if actions[ActionDeviceSuspend] != audit.OutcomeSuccess || actions[ActionDeviceResume] != audit.OutcomeSuccess {
+		t.Error("Suspension and resumption must be audited, got", actions)
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func createOrganizationKey(t *testing.T, url string, name string, permissions ...string) APIKeyResponse {
+	var organization OrganizationResponse
+	if code := sendAuthenticatedRequest(t, http.MethodPost, url+"/api/v0/admin/organizations", adminSecret, OrganizationRequest{name}, &organization); code != http.StatusOK {
+		t.Fatal("Operator must be able to create organizations, got", code)
+	}
+
+	var key APIKeyResponse
+	code := sendAuthenticatedRequest(t, http.MethodPost, url+"/api/v0/admin/keys", adminSecret, APIKeyRequest{
+		OrganizationId: &organization.Id,
+		Permissions:    permissions,
+		AllDevices:     true,
+	}, &key)
+	if code != http.StatusOK || key.OrganizationId != organization.Id {
+		t.Fatal("Operator must be able to create keys for organizations, got", code)
+	}
+	return key
+}
+
+func TestTenant_CrossTenantAccess(t *testing.T) {
+	ts := initAuthenticatedServer(t)
+	keyA := createOrganizationKey(t, ts.URL, "Tenant A", "create", "sign", "read")
+	keyB := createOrganizationKey(t, ts.URL, "Tenant B", "create", "sign", "read")
+
+	var deviceA SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", keyA.Secret, SignatureDeviceRequest{Algorithm: "ECC", Label: "A"}, &deviceA)
+
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices/"+deviceA.Id.String(), keyB.Secret, nil, nil); code != http.StatusNotFound {
+		t.Error("Reading a device of another tenant must return 404, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", keyB.Secret, SignDataRequest{Id: deviceA.Id, Data: "data"}, nil); code != http.StatusNotFound {
+		t.Error("Signing with a device of another tenant must return 404, got", code)
+	}
+
+	var devicesB []SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices", keyB.Secret, nil, &devicesB)
+	if len(devicesB) != 0 {
+		t.Error("Device list must not contain devices of other tenants.")
+	}
+
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices/"+deviceA.Id.String(), keyA.Secret, nil, nil); code != http.StatusOK {
+		t.Error("Owner tenant must be able to read its device, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", keyA.Secret, SignDataRequest{Id: deviceA.Id, Data: "data"}, nil); code != http.StatusOK {
+		t.Error("Owner tenant must be able to sign with its device, got", code)
+	}
+
+	var operatorDevices []SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/devices", adminSecret, nil, &operatorDevices)
+	if len(operatorDevices) != 0 {
+		t.Error("Devices of tenants must not be listed in the default organization.")
+	}
+}
+
+func TestTenant_AdminIsolation(t *testing.T) {
+	ts := initAuthenticatedServer(t)
+	keyA := createOrganizationKey(t, ts.URL, "Tenant A", "create")
+	adminB := createOrganizationKey(t, ts.URL, "Tenant B", "admin")
+
+	var deviceA SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", keyA.Secret, SignatureDeviceRequest{Algorithm: "ECC", Label: "A"}, &deviceA)
+
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/admin/keys/"+keyA.Id.String(), adminB.Secret, nil, nil); code != http.StatusNotFound {
+		t.Error("Tenant admins must not see keys of other tenants, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodDelete, ts.URL+"/api/v0/admin/keys/"+keyA.Id.String(), adminB.Secret, nil, nil); code != http.StatusNotFound {
+		t.Error("Tenant admins must not revoke keys of other tenants, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/admin/keys", adminB.Secret, APIKeyRequest{
+		OrganizationId: &keyA.OrganizationId,
+		Permissions:    []string{"sign"},
+	}, nil); code != http.StatusForbidden {
+		t.Error("Tenant admins must not create keys for other tenants, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/admin/keys", adminB.Secret, APIKeyRequest{
+		Permissions: []string{"sign"},
+		Devices:     []uuid.UUID{deviceA.Id},
+	}, nil); code != http.StatusBadRequest {
+		t.Error("Keys must not be scoped to devices of other tenants, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/admin/organizations", adminB.Secret, nil, nil); code != http.StatusForbidden {
+		t.Error("Tenant admins must not manage organizations, got", code)
+	}
+
+	var keysB []APIKeyResponse
+	sendAuthenticatedRequest(t, http.MethodGet, ts.URL+"/api/v0/admin/keys", adminB.Secret, nil, &keysB)
+	if len(keysB) != 1 || keysB[0].Id != adminB.Id {
+		t.Error("Tenant admins must only list keys of their organization.")
+	}
+}
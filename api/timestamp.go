@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// TimestampAuthority issues RFC 3161 time-stamp tokens over the SHA-256 hash of a message, like tsa.Client.
+type TimestampAuthority interface {
+	Timestamp(ctx context.Context, message []byte) ([]byte, error)
+}
+
+// WithTimestampAuthority makes the Server obtain a time-stamp token from authority for every signature, or for
+// the Merkle root of the signatures of a batch, and store it with the transactions.
+func WithTimestampAuthority(authority TimestampAuthority) Option {
+	return func(s *Server) {
+		s.timestamps = authority
+	}
+}
+
+// timestamp obtains and stores a time-stamp token over committed transactions of a device. The transactions stay
+// valid without one, so failures are only logged and leave them without token.
+func (s *Server) timestamp(request *http.Request, transactions []domain.Transaction) *domain.Timestamp {
+	if s.timestamps == nil || len(transactions) == 0 {
+		return nil
+	}
+	deviceId := transactions[0].DeviceId
+	token, err := s.timestamps.Timestamp(request.Context(), domain.TimestampMessage(transactions))
+	if err != nil {
+		s.requestLogger(request).Warn("Obtaining time-stamp token failed", "device_id", deviceId, "error", err)
+		return nil
+	}
+	timestamp := domain.Timestamp{Token: token, FirstCounter: transactions[0].Counter, Count: len(transactions)}
+	if err := s.db.AttachTimestamp(deviceId, timestamp); err != nil {
+		s.requestLogger(request).Error("Storing time-stamp token failed", "device_id", deviceId, "error", err)
+		return nil
+	}
+	return &timestamp
+}
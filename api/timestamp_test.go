@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/merkle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/tsa"
+)
+
+func startTimestampAuthority(t *testing.T) *tsa.Client {
+	t.Helper()
+	stub, err := tsa.NewStub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return tsa.NewClient(server.URL, 5*time.Second)
+}
+
+func TestTimestamp_SignaturesAreTimestamped(t *testing.T) {
+	db := persistence.GetInMemoryDB()
+	ts := httptest.NewServer(NewServer(":8080", db, WithTimestampAuthority(startTimestampAuthority(t))).Handler())
+	defer ts.Close()
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC"}, &deviceResponse)
+	deviceURL := ts.URL + "/api/v0/devices/" + deviceResponse.Id.String()
+
+	var signed SignDataResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: deviceResponse.Id, Data: "single"}, &signed)
+	if _, err := tsa.Verify(signed.TimestampToken, signed.Signature); err != nil {
+		t.Error("Token must cover the signature:", err)
+	}
+
+	var batch []SignDataResponse
+	sendAuthenticatedRequest(t, http.MethodPost, deviceURL+"/sign/batch", "", SignBatchRequest{Data: []string{"a", "b", "c"}}, &batch)
+	signatures := make([][]byte, 0, len(batch))
+	for _, item := range batch {
+		signatures = append(signatures, item.Signature)
+		if !bytes.Equal(item.TimestampToken, batch[0].TimestampToken) {
+			t.Error("All items of a batch must share one token.")
+		}
+	}
+	if _, err := tsa.Verify(batch[0].TimestampToken, merkle.Root(signatures)); err != nil {
+		t.Error("Token must cover the Merkle root of the batch:", err)
+	}
+
+	for counter, token := range [][]byte{signed.TimestampToken, batch[0].TimestampToken, batch[0].TimestampToken, batch[0].TimestampToken} {
+		var transaction TransactionResponse
+		code := sendAuthenticatedRequest(t, http.MethodGet, deviceURL+"/transactions/"+strconv.Itoa(counter), "", nil, &transaction)
+		if code != http.StatusOK || transaction.Timestamp == nil || !bytes.Equal(transaction.Timestamp.Token, token) {
+			t.Fatal("Transaction", counter, "must be served with its token, got", code)
+		}
+		if transaction.Counter != uint64(counter) || transaction.Timestamp.Time == nil {
+			t.Error("Transaction", counter, "has unexpected counter or time:", transaction.Counter, transaction.Timestamp.Time)
+		}
+	}
+	var transaction TransactionResponse
+	sendAuthenticatedRequest(t, http.MethodGet, deviceURL+"/transactions/2", "", nil, &transaction)
+	if transaction.Timestamp.FirstCounter != 1 || transaction.Timestamp.Count != 3 {
+		t.Error("Token of a batch item must name the batch, got", transaction.Timestamp.FirstCounter, transaction.Timestamp.Count)
+	}
+
+	if code := sendAuthenticatedRequest(t, http.MethodGet, deviceURL+"/transactions/4", "", nil, nil); code != http.StatusNotFound {
+		t.Error("Unknown transaction must not be found, got", code)
+	}
+	if code := sendAuthenticatedRequest(t, http.MethodGet, deviceURL+"/transactions/first", "", nil, nil); code != http.StatusBadRequest {
+		t.Error("Invalid counter must be rejected, got", code)
+	}
+}
+
+func TestTimestamp_SigningSucceedsWithoutAuthority(t *testing.T) {
+	unavailable := httptest.NewServer(http.NotFoundHandler())
+	defer unavailable.Close()
+	db := persistence.GetInMemoryDB()
+	ts := httptest.NewServer(NewServer(":8080", db, WithTimestampAuthority(tsa.NewClient(unavailable.URL, time.Second))).Handler())
+	defer ts.Close()
+
+	var deviceResponse SignatureDeviceResponse
+	sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/new", "", SignatureDeviceRequest{Algorithm: "ECC"}, &deviceResponse)
+	var signed SignDataResponse
+	code := sendAuthenticatedRequest(t, http.MethodPost, ts.URL+"/api/v0/sign", "", SignDataRequest{Id: deviceResponse.Id, Data: "single"}, &signed)
+	if code != http.StatusOK || signed.TimestampToken != nil {
+		t.Error("Signature must succeed without token, got", code)
+	}
+	transactions := db.GetTransactions(domain.DefaultOrganizationId, deviceResponse.Id)
+	if len(transactions) != 1 || transactions[0].Timestamp != nil {
+		t.Error("Transaction must be stored without token.")
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+)
+
+// TLSReloader serves the certificate, private key and client CA from PEM files
+// and reloads them whenever one of the files is modified.
+type TLSReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	// checkInterval limits how often the files are checked for modifications.
+	checkInterval time.Duration
+
+	logger *slog.Logger
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	modTimes    [3]time.Time
+	lastCheck   time.Time
+}
+
+// NewTLSReloader loads the given files. When clientCAFile is not empty,
+// clients must present a certificate issued by that CA (mutual TLS).
+// Failed reloads are reported to logger; a nil logger discards them.
+func NewTLSReloader(certFile, keyFile, clientCAFile string, logger *slog.Logger) (*TLSReloader, error) {
+	if logger == nil {
+		logger = logging.Discard()
+	}
+	reloader := &TLSReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		clientCAFile:  clientCAFile,
+		checkInterval: time.Second,
+		logger:        logger,
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Config returns a tls.Config that always uses the most recently loaded files.
+func (r *TLSReloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			if time.Since(r.lastCheck) >= r.checkInterval {
+				r.lastCheck = time.Now()
+				if err := r.reloadIfModified(); err != nil {
+					// Keep serving the previous certificate until the files are consistent again.
+					r.logger.Warn("Reloading TLS files failed", "error", err)
+				}
+			}
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.certificate},
+			}
+			if r.clientCAs != nil {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = r.clientCAs
+			}
+			return config, nil
+		},
+	}
+}
+
+func (r *TLSReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadIfModified()
+}
+
+// reloadIfModified reads all files again if any modification time differs from the last load.
+// The caller must hold r.mu.
+func (r *TLSReloader) reloadIfModified() error {
+	var modTimes [3]time.Time
+	for i, file := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if r.certificate != nil && modTimes == r.modTimes {
+		return nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading key pair: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		caPEM, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return errors.New("no certificates found in client CA file " + r.clientCAFile)
+		}
+	}
+
+	r.certificate = &certificate
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
+
+// WithTLS makes the Server serve HTTPS with the files managed by reloader.
+func WithTLS(reloader *TLSReloader) Option {
+	return func(s *Server) {
+		s.httpServer.TLSConfig = reloader.Config()
+	}
+}
+
+type clientSubjectKey struct{}
+
+// ClientSubject returns the subject of the verified client certificate of a mutual TLS request.
+func ClientSubject(ctx context.Context) (pkix.Name, bool) {
+	subject, ok := ctx.Value(clientSubjectKey{}).(pkix.Name)
+	return subject, ok
+}
+
+// withClientSubject makes the subject of a verified client certificate available through ClientSubject.
+func withClientSubject(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 {
+			subject := request.TLS.VerifiedChains[0][0].Subject
+			request = request.WithContext(context.WithValue(request.Context(), clientSubjectKey{}, subject))
+		}
+		next.ServeHTTP(response, request)
+	})
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issueCertificate creates a certificate for commonName signed by issuer, or a self-signed CA if issuer is nil.
+func issueCertificate(t *testing.T, commonName string, issuer *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Test"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signerKey := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signerKey = issuer.cert, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCertificate{cert, key}
+}
+
+func (c *testCertificate) writeFiles(t *testing.T, dir string, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	keyDER, _ := x509.MarshalECPrivateKey(c.key)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func startTLSServer(t *testing.T, reloader *TLSReloader) string {
+	server := NewServer("127.0.0.1:0", persistence.GetInMemoryDB(), WithTLS(reloader))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	return "https://" + listener.Addr().String()
+}
+
+func tlsClient(roots *x509.CertPool, certificates ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates},
+	}}
+}
+
+func TestTLS_ServeAndReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCertificate(t, "Test CA", nil)
+	certFile, keyFile := issueCertificate(t, "server-1", ca).writeFiles(t, dir, "server")
+
+	reloader, err := NewTLSReloader(certFile, keyFile, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.checkInterval = 0
+	url := startTLSServer(t, reloader)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	res, err := tlsClient(roots).Get(url + "/api/v0/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TLS.PeerCertificates[0].Subject.CommonName != "server-1" {
+		t.Error("Wrong server certificate served.")
+	}
+
+	issueCertificate(t, "server-2", ca).writeFiles(t, dir, "server")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+
+	res, err = tlsClient(roots).Get(url + "/api/v0/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TLS.PeerCertificates[0].Subject.CommonName != "server-2" {
+		t.Error("Modified certificate not reloaded.")
+	}
+}
+
+func TestTLS_MutualAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCertificate(t, "Test CA", nil)
+	certFile, keyFile := issueCertificate(t, "server", ca).writeFiles(t, dir, "server")
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+
+	reloader, err := NewTLSReloader(certFile, keyFile, caFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := startTLSServer(t, reloader)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	if _, err := tlsClient(roots).Get(url + "/api/v0/health"); err == nil {
+		t.Error("Clients without certificate must be rejected.")
+	}
+
+	foreignCA := issueCertificate(t, "Foreign CA", nil)
+	foreignClient := issueCertificate(t, "intruder", foreignCA)
+	if _, err := tlsClient(roots, foreignClient.tlsCertificate()).Get(url + "/api/v0/health"); err == nil {
+		t.Error("Clients with certificates from other CAs must be rejected.")
+	}
+
+	client := issueCertificate(t, "terminal-1", ca)
+	res, err := tlsClient(roots, client.tlsCertificate()).Get(url + "/api/v0/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Error("Clients with valid certificate must be accepted.")
+	}
+}
+
+func TestTLS_ClientSubject(t *testing.T) {
+	ca := issueCertificate(t, "Test CA", nil)
+	client := issueCertificate(t, "terminal-1", ca)
+
+	var subject pkix.Name
+	var found bool
+	handler := withClientSubject(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		subject, found = ClientSubject(request.Context())
+	}))
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if found {
+		t.Error("Plain requests must not have a client subject.")
+	}
+
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{client.cert, ca.cert}}}
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if !found || subject.CommonName != "terminal-1" {
+		t.Error("Client subject not available to handlers.")
+	}
+}
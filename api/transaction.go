@@ -0,0 +1,109 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/tsa"
+	"github.com/google/uuid"
+)
+
+// TransactionResponse is a transaction from the journal of a device.
+type TransactionResponse struct {
+	Counter    uint64             `json:"counter"`
+	SignedData []byte             `json:"signed_data"`
+	Signature  []byte             `json:"signature"`
+	Timestamp  *TimestampResponse `json:"timestamp,omitempty"`
+}
+
+// TimestampResponse is an RFC 3161 time-stamp token over transactions FirstCounter to FirstCounter+Count-1 of a
+// device, see domain.TimestampMessage. Time is the time attested by the token.
+type TimestampResponse struct {
+	Token        []byte     `json:"token"`
+	FirstCounter uint64     `json:"first_counter"`
+	Count        int        `json:"count"`
+	Time         *time.Time `json:"time,omitempty"`
+}
+
+func newTransactionResponse(transaction domain.Transaction) TransactionResponse {
+	transactionResponse := TransactionResponse{
+		Counter:    transaction.Counter,
+		SignedData: transaction.SignedData,
+		Signature:  transaction.Signature,
+	}
+	if timestamp := transaction.Timestamp; timestamp != nil {
+		transactionResponse.Timestamp = &TimestampResponse{
+			Token:        timestamp.Token,
+			FirstCounter: timestamp.FirstCounter,
+			Count:        timestamp.Count,
+		}
+		if attested, err := tsa.Time(timestamp.Token); err == nil {
+			transactionResponse.Timestamp.Time = &attested
+		}
+	}
+	return transactionResponse
+}
+
+var errInvalidTransactionPath = errors.New("invalid transaction path")
+
+// parseTransactionPath returns device id and counter of a path /api/v0/devices/{id}/transactions/{counter}
+// followed by suffix.
+func parseTransactionPath(path string, suffix string) (uuid.UUID, uint64, error) {
+	rest, found := strings.CutSuffix(strings.TrimPrefix(path, "/api/v0/devices/"), suffix)
+	if !found {
+		return uuid.Nil, 0, errInvalidTransactionPath
+	}
+	deviceIdStr, counterStr, found := strings.Cut(rest, "/transactions/")
+	if !found {
+		return uuid.Nil, 0, errInvalidTransactionPath
+	}
+	id, err := uuid.Parse(deviceIdStr)
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+	counter, err := strconv.ParseUint(counterStr, 10, 64)
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+	return id, counter, nil
+}
+
+// GetTransaction handles a request for one transaction of a device, including its time-stamp token.
+func (s *Server) GetTransaction(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
+			http.StatusText(http.StatusMethodNotAllowed),
+		})
+		return
+	}
+
+	id, counter, err := parseTransactionPath(request.URL.Path, "")
+	if err != nil {
+		http.Error(response, "Invalid device ID or counter", http.StatusBadRequest)
+		return
+	}
+	device, exists := s.db.Get(organizationId(request), id)
+	if !exists {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"No device found under provided id.",
+		})
+		return
+	}
+	if !canAccessDevice(request, device.Id) {
+		writeDeviceForbidden(response)
+		return
+	}
+	transaction, exists := s.db.GetTransaction(device.OrganizationId, device.Id, counter)
+	if !exists {
+		WriteErrorResponse(response, http.StatusNotFound, []string{
+			"No transaction found under provided counter.",
+		})
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, newTransactionResponse(transaction))
+}
@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outcomes of audited actions.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// Entry records who performed which action on which target, when, and with which outcome.
+// Entries of an organization form a hash chain: Hash covers all fields and the Hash of the previous entry.
+type Entry struct {
+	OrganizationId uuid.UUID `json:"organization_id"`
+	Sequence       uint64    `json:"sequence"`
+	Timestamp      time.Time `json:"timestamp"`
+	Actor          string    `json:"actor"`
+	Action         string    `json:"action"`
+	Target         string    `json:"target"`
+	Outcome        string    `json:"outcome"`
+	RequestId      string    `json:"request_id,omitempty"`
+	PreviousHash   []byte    `json:"previous_hash"`
+	Hash           []byte    `json:"hash"`
+}
+
+// computeHash hashes all fields except Hash itself in an unambiguous, length-prefixed encoding.
+func (e *Entry) computeHash() []byte {
+	var buffer bytes.Buffer
+	writeField := func(field []byte) {
+		binary.Write(&buffer, binary.BigEndian, uint32(len(field)))
+		buffer.Write(field)
+	}
+
+	writeField(e.OrganizationId[:])
+	binary.Write(&buffer, binary.BigEndian, e.Sequence)
+	writeField([]byte(e.Timestamp.UTC().Format(time.RFC3339Nano)))
+	writeField([]byte(e.Actor))
+	writeField([]byte(e.Action))
+	writeField([]byte(e.Target))
+	writeField([]byte(e.Outcome))
+	writeField([]byte(e.RequestId))
+	writeField(e.PreviousHash)
+
+	hash := sha256.Sum256(buffer.Bytes())
+	return hash[:]
+}
+
+// Filter selects entries of an organization. Zero values match all entries.
+type Filter struct {
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (f Filter) matches(entry *Entry) bool {
+	return (f.Action == "" || entry.Action == f.Action) &&
+		(f.Target == "" || entry.Target == f.Target) &&
+		(f.Since.IsZero() || !entry.Timestamp.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.Timestamp.Before(f.Until))
+}
+
+// Store persists the entries of a log, so that its chains survive restarts.
+type Store interface {
+	// AppendAuditEntry durably records an entry before it is added to its chain.
+	AppendAuditEntry(entry Entry) error
+	// AuditEntries returns all recorded entries in the order they were appended.
+	AuditEntries() []Entry
+}
+
+// Log is an append-only, hash-chained audit log with one chain per organization.
+type Log struct {
+	mu     sync.RWMutex
+	chains map[uuid.UUID][]Entry
+	store  Store
+}
+
+// NewLog creates an empty audit log that is only kept in memory.
+func NewLog() *Log {
+	return &Log{
+		chains: make(map[uuid.UUID][]Entry),
+	}
+}
+
+// NewStoredLog creates an audit log that records every entry in store and continues the chains recorded there.
+// It fails with ErrChainBroken if the recorded chains were tampered with.
+func NewStoredLog(store Store) (*Log, error) {
+	log := NewLog()
+	log.store = store
+	for _, entry := range store.AuditEntries() {
+		log.chains[entry.OrganizationId] = append(log.chains[entry.OrganizationId], entry)
+	}
+	for organizationId, chain := range log.chains {
+		if err := Verify(chain); err != nil {
+			return nil, fmt.Errorf("audit log of organization %s: %w", organizationId, err)
+		}
+	}
+	return log, nil
+}
+
+// Append adds an entry to the chain of its organization.
+// Sequence, timestamp and hashes are assigned by the log; the stored entry is returned. If the entry cannot be
+// recorded in the store of the log, it is not added.
+func (l *Log) Append(entry Entry) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	chain := l.chains[entry.OrganizationId]
+	entry.Sequence = uint64(len(chain))
+	entry.Timestamp = time.Now().UTC()
+	entry.PreviousHash = make([]byte, sha256.Size)
+	if len(chain) > 0 {
+		entry.PreviousHash = chain[len(chain)-1].Hash
+	}
+	entry.Hash = entry.computeHash()
+
+	if l.store != nil {
+		if err := l.store.AppendAuditEntry(entry); err != nil {
+			return Entry{}, err
+		}
+	}
+	l.chains[entry.OrganizationId] = append(chain, entry)
+	return entry, nil
+}
+
+// Query returns the entries of an organization matching the filter in chain order.
+func (l *Log) Query(organizationId uuid.UUID, filter Filter) []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var entries []Entry
+	for i := range l.chains[organizationId] {
+		entry := &l.chains[organizationId][i]
+		if !filter.matches(entry) {
+			continue
+		}
+		entries = append(entries, *entry)
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+	}
+	return entries
+}
+
+// ErrChainBroken is returned by Verify if entries were modified, removed, reordered or inserted.
+var ErrChainBroken = errors.New("audit chain broken")
+
+// Verify checks the complete chain of one organization, e.g. an unfiltered export of the query endpoint.
+// It needs no access to the service and can be run offline.
+func Verify(entries []Entry) error {
+	previousHash := make([]byte, sha256.Size)
+	for i, entry := range entries {
+		if entry.Sequence != uint64(i) {
+			return fmt.Errorf("%w: entry %d has sequence %d", ErrChainBroken, i, entry.Sequence)
+		}
+		if entry.OrganizationId != entries[0].OrganizationId {
+			return fmt.Errorf("%w: entry %d belongs to another organization", ErrChainBroken, i)
+		}
+		if !bytes.Equal(entry.PreviousHash, previousHash) {
+			return fmt.Errorf("%w: entry %d does not link to its predecessor", ErrChainBroken, i)
+		}
+		if !bytes.Equal(entry.Hash, entry.computeHash()) {
+			return fmt.Errorf("%w: entry %d was modified", ErrChainBroken, i)
+		}
+		previousHash = entry.Hash
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func appendEntries(log *Log, organizationId uuid.UUID) {
+	log.Append(Entry{OrganizationId: organizationId, Actor: "api_key:1", Action: "device.create", Target: "device:1", Outcome: OutcomeSuccess})
+	log.Append(Entry{OrganizationId: organizationId, Actor: "api_key:1", Action: "device.sign", Target: "device:1", Outcome: OutcomeSuccess})
+	log.Append(Entry{OrganizationId: organizationId, Actor: "api_key:2", Action: "device.sign", Target: "device:1", Outcome: OutcomeDenied})
+}
+
+func TestLog_AppendAndVerify(t *testing.T) {
+	log := NewLog()
+	organizationId := uuid.New()
+	appendEntries(log, organizationId)
+
+	entries := log.Query(organizationId, Filter{})
+	if len(entries) != 3 {
+		t.Fatal("Expected three entries, got", len(entries))
+	}
+	if err := Verify(entries); err != nil {
+		t.Error("Untampered chain must verify:", err)
+	}
+
+	// Verification must also work on an exported copy of the chain.
+	exported, _ := json.Marshal(entries)
+	var imported []Entry
+	_ = json.Unmarshal(exported, &imported)
+	if err := Verify(imported); err != nil {
+		t.Error("Exported chain must verify:", err)
+	}
+}
+
+func TestLog_VerifyDetectsTampering(t *testing.T) {
+	log := NewLog()
+	organizationId := uuid.New()
+	appendEntries(log, organizationId)
+
+	modified := log.Query(organizationId, Filter{})
+	modified[1].Outcome = OutcomeFailure
+	if err := Verify(modified); !errors.Is(err, ErrChainBroken) {
+		t.Error("Modified entries must be detected.")
+	}
+
+	entries := log.Query(organizationId, Filter{})
+	removed := append([]Entry{entries[0]}, entries[2])
+	if err := Verify(removed); !errors.Is(err, ErrChainBroken) {
+		t.Error("Removed entries must be detected.")
+	}
+
+	entries = log.Query(organizationId, Filter{})
+	entries[1].Sequence, entries[2].Sequence = entries[2].Sequence, entries[1].Sequence
+	entries[1], entries[2] = entries[2], entries[1]
+	if err := Verify(entries); !errors.Is(err, ErrChainBroken) {
+		t.Error("Reordered entries must be detected.")
+	}
+}
+
+func TestLog_QueryIsScopedAndFiltered(t *testing.T) {
+	log := NewLog()
+	organizationA, organizationB := uuid.New(), uuid.New()
+	appendEntries(log, organizationA)
+	appendEntries(log, organizationB)
+
+	entriesB := log.Query(organizationB, Filter{})
+	if len(entriesB) != 3 || entriesB[0].Sequence != 0 {
+		t.Error("Each organization must have its own chain.")
+	}
+
+	signs := log.Query(organizationA, Filter{Action: "device.sign"})
+	if len(signs) != 2 {
+		t.Error("Filter by action failed.")
+	}
+
+	limited := log.Query(organizationA, Filter{Limit: 1})
+	if len(limited) != 1 || limited[0].Action != "device.create" {
+		t.Error("Limit failed.")
+	}
+
+	limited[0].Actor = "someone else"
+	if log.Query(organizationA, Filter{})[0].Actor != "api_key:1" {
+		t.Error("Returned entries must not modify the log.")
+	}
+}
+
+// memoryStore records entries like a database would.
+type memoryStore struct {
+	entries []Entry
+	err     error
+}
+
+func (s *memoryStore) AppendAuditEntry(entry Entry) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *memoryStore) AuditEntries() []Entry {
+	return s.entries
+}
+
+func TestStoredLog_ContinuesRecordedChains(t *testing.T) {
+	store := &memoryStore{}
+	log, _ := NewStoredLog(store)
+	organizationA, organizationB := uuid.New(), uuid.New()
+	appendEntries(log, organizationA)
+	appendEntries(log, organizationB)
+
+	restored, err := NewStoredLog(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := restored.Append(Entry{OrganizationId: organizationA, Action: "device.export", Outcome: OutcomeSuccess})
+	if err != nil || next.Sequence != 3 {
+		t.Fatal("Restored chain must be continued, got sequence", next.Sequence, err)
+	}
+	if err := Verify(restored.Query(organizationA, Filter{})); err != nil {
+		t.Error("Continued chain must verify:", err)
+	}
+
+	store.entries[1].Outcome = OutcomeFailure
+	if _, err := NewStoredLog(store); !errors.Is(err, ErrChainBroken) {
+		t.Error("Tampered recorded chain must be detected, got", err)
+	}
+
+	store.err = errors.New("disk full")
+	if _, err := restored.Append(Entry{OrganizationId: organizationB}); err == nil || len(restored.Query(organizationB, Filter{})) != 3 {
+		t.Error("Entry that cannot be recorded must not be added")
+	}
+}
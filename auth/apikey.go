@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Permission is an operation an API key may perform.
+type Permission string
+
+const (
+	PermissionCreate Permission = "create"
+	PermissionSign   Permission = "sign"
+	PermissionRead   Permission = "read"
+	// PermissionAdmin grants all other permissions and access to the admin endpoints.
+	PermissionAdmin Permission = "admin"
+)
+
+// ParsePermission validates the name of a permission.
+func ParsePermission(name string) (Permission, error) {
+	switch permission := Permission(name); permission {
+	case PermissionCreate, PermissionSign, PermissionRead, PermissionAdmin:
+		return permission, nil
+	default:
+		return "", fmt.Errorf("unknown permission %q", name)
+	}
+}
+
+// APIKey is a credential of an organization, scoped to a set of its signature devices and permissions.
+// Only the SHA-256 hash of the secret is kept.
+type APIKey struct {
+	Id             uuid.UUID
+	OrganizationId uuid.UUID
+	Name           string
+	Permissions    []Permission
+	// AllDevices grants access to every device, otherwise only DeviceIds are accessible.
+	AllDevices bool
+	DeviceIds  []uuid.UUID
+	CreatedAt  time.Time
+	hash       [sha256.Size]byte
+	// stored tells whether the key is recorded in the Store of its KeyStore.
+	stored bool
+}
+
+// HasPermission reports whether the key grants the permission. Admin keys have all permissions.
+func (k *APIKey) HasPermission(permission Permission) bool {
+	for _, granted := range k.Permissions {
+		if granted == permission || granted == PermissionAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessDevice reports whether the key is scoped to the device.
+func (k *APIKey) CanAccessDevice(id uuid.UUID) bool {
+	if k.AllDevices || k.HasPermission(PermissionAdmin) {
+		return true
+	}
+	for _, deviceId := range k.DeviceIds {
+		if deviceId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrKeyNotFound is returned for operations on API keys that do not exist.
+var ErrKeyNotFound = errors.New("api key not found")
+
+// StoredKey is an API key as recorded in a Store, with the SHA-256 hash of its secret.
+type StoredKey struct {
+	APIKey
+	SecretHash [sha256.Size]byte
+}
+
+// Store durably records the API keys of a KeyStore, so that they survive restarts.
+type Store interface {
+	// PutAPIKey records a created or changed API key before the change takes effect.
+	PutAPIKey(key StoredKey) error
+	// DeleteAPIKey records the revocation of an API key before it takes effect.
+	DeleteAPIKey(id uuid.UUID) error
+	// APIKeys returns all recorded API keys that were not revoked.
+	APIKeys() []StoredKey
+}
+
+// KeyStore holds API keys and authenticates their secrets.
+type KeyStore struct {
+	mu     sync.RWMutex
+	keys   map[uuid.UUID]*APIKey
+	hashes map[[sha256.Size]byte]*APIKey
+	store  Store
+}
+
+// NewKeyStore creates an empty KeyStore whose keys only live in memory.
+func NewKeyStore() *KeyStore {
+	return &KeyStore{
+		keys:   make(map[uuid.UUID]*APIKey),
+		hashes: make(map[[sha256.Size]byte]*APIKey),
+	}
+}
+
+// NewStoredKeyStore creates a KeyStore that records every key created with Create, and every later change to it,
+// in store, and holds the keys recorded there.
+func NewStoredKeyStore(store Store) *KeyStore {
+	s := NewKeyStore()
+	s.store = store
+	for _, stored := range store.APIKeys() {
+		key := stored.APIKey.copy()
+		key.hash = stored.SecretHash
+		key.stored = true
+		s.keys[key.Id] = key
+		s.hashes[key.hash] = key
+	}
+	return s
+}
+
+// Create generates a new API key and returns it together with its secret.
+// The secret cannot be recovered later.
+func (s *KeyStore) Create(key APIKey) (*APIKey, string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(random)
+	created, err := s.add(key, secret, s.store != nil)
+	return created, secret, err
+}
+
+// Add holds an API key with a secret chosen by the caller, e.g. a bootstrap admin key from the configuration. The
+// key is not recorded in the Store: it is added again on every start, so it only lasts as long as its secret is
+// configured, and changes to it last until the next restart.
+func (s *KeyStore) Add(key APIKey, secret string) (*APIKey, error) {
+	return s.add(key, secret, false)
+}
+
+func (s *KeyStore) add(key APIKey, secret string, stored bool) (*APIKey, error) {
+	if secret == "" {
+		return nil, errors.New("secret must not be empty")
+	}
+	key.Id = uuid.New()
+	key.CreatedAt = time.Now().UTC()
+	key.Permissions = append([]Permission(nil), key.Permissions...)
+	key.DeviceIds = append([]uuid.UUID(nil), key.DeviceIds...)
+	key.hash = sha256.Sum256([]byte(secret))
+	key.stored = stored
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.hashes[key.hash]; exists {
+		return nil, errors.New("secret already in use")
+	}
+	if err := s.put(&key); err != nil {
+		return nil, err
+	}
+	s.keys[key.Id] = &key
+	s.hashes[key.hash] = &key
+	return key.copy(), nil
+}
+
+// put records a key in the Store, if it is stored there. The caller must hold the lock.
+func (s *KeyStore) put(key *APIKey) error {
+	if !key.stored {
+		return nil
+	}
+	return s.store.PutAPIKey(StoredKey{APIKey: *key.copy(), SecretHash: key.hash})
+}
+
+// Authenticate returns the API key belonging to the secret.
+func (s *KeyStore) Authenticate(secret string) (*APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.hashes[sha256.Sum256([]byte(secret))]
+	if !ok {
+		return nil, false
+	}
+	return key.copy(), true
+}
+
+// Get returns the API key with the given id.
+func (s *KeyStore) Get(id uuid.UUID) (*APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, false
+	}
+	return key.copy(), true
+}
+
+// GetAll returns all API keys of the organization.
+func (s *KeyStore) GetAll(organizationId uuid.UUID) []*APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []*APIKey
+	for _, key := range s.keys {
+		if key.OrganizationId == organizationId {
+			keys = append(keys, key.copy())
+		}
+	}
+	return keys
+}
+
+// Update replaces the permissions and device scope of an API key.
+func (s *KeyStore) Update(id uuid.UUID, permissions []Permission, allDevices bool, deviceIds []uuid.UUID) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	updated := key.copy()
+	updated.Permissions = append([]Permission(nil), permissions...)
+	updated.AllDevices = allDevices
+	updated.DeviceIds = append([]uuid.UUID(nil), deviceIds...)
+	if err := s.put(updated); err != nil {
+		return nil, err
+	}
+	*key = *updated
+	return key.copy(), nil
+}
+
+// GrantDevice adds a device to the scope of an API key.
+func (s *KeyStore) GrantDevice(id uuid.UUID, deviceId uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	updated := key.copy()
+	updated.DeviceIds = append(updated.DeviceIds, deviceId)
+	if err := s.put(updated); err != nil {
+		return err
+	}
+	*key = *updated
+	return nil
+}
+
+// Delete revokes an API key.
+func (s *KeyStore) Delete(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if key.stored {
+		if err := s.store.DeleteAPIKey(id); err != nil {
+			return err
+		}
+	}
+	delete(s.keys, id)
+	delete(s.hashes, key.hash)
+	return nil
+}
+
+// copy returns a copy that can be handed out without exposing the stored key to concurrent modification.
+func (k *APIKey) copy() *APIKey {
+	c := *k
+	c.Permissions = append([]Permission(nil), k.Permissions...)
+	c.DeviceIds = append([]uuid.UUID(nil), k.DeviceIds...)
+	return &c
+}
+
+type keyContextKey struct{}
+
+// NewContext returns a context carrying the authenticated API key.
+func NewContext(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// FromContext returns the authenticated API key of a request, if any.
+func FromContext(ctx context.Context) (*APIKey, bool) {
+	key, ok := ctx.Value(keyContextKey{}).(*APIKey)
+	return key, ok
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestKeyStore_CreateAndAuthenticate(t *testing.T) {
+	store := NewKeyStore()
+	key, secret, err := store.Create(APIKey{Name: "terminal", Permissions: []Permission{PermissionSign}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticated, ok := store.Authenticate(secret)
+	if !ok || authenticated.Id != key.Id {
+		t.Error("Authentication with valid secret failed.")
+	}
+
+	if _, ok := store.Authenticate(secret + "x"); ok {
+		t.Error("Authentication with invalid secret must fail.")
+	}
+
+	if err := store.Delete(key.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Authenticate(secret); ok {
+		t.Error("Revoked keys must not authenticate.")
+	}
+}
+
+func TestAPIKey_Permissions(t *testing.T) {
+	deviceId := uuid.New()
+	key := APIKey{Permissions: []Permission{PermissionSign}, DeviceIds: []uuid.UUID{deviceId}}
+
+	if !key.HasPermission(PermissionSign) || key.HasPermission(PermissionCreate) {
+		t.Error("Permissions not evaluated properly.")
+	}
+	if !key.CanAccessDevice(deviceId) || key.CanAccessDevice(uuid.New()) {
+		t.Error("Device scope not evaluated properly.")
+	}
+
+	admin := APIKey{Permissions: []Permission{PermissionAdmin}}
+	if !admin.HasPermission(PermissionRead) || !admin.CanAccessDevice(uuid.New()) {
+		t.Error("Admin keys must have all permissions.")
+	}
+}
+
+func TestKeyStore_GrantDevice(t *testing.T) {
+	store := NewKeyStore()
+	key, secret, _ := store.Create(APIKey{Permissions: []Permission{PermissionCreate}})
+	deviceId := uuid.New()
+
+	if err := store.GrantDevice(key.Id, deviceId); err != nil {
+		t.Fatal(err)
+	}
+
+	authenticated, _ := store.Authenticate(secret)
+	if !authenticated.CanAccessDevice(deviceId) {
+		t.Error("Granted device not accessible.")
+	}
+	if key.CanAccessDevice(deviceId) {
+		t.Error("Returned keys must not change with the store.")
+	}
+}
+
+// failingStore records nothing.
+type failingStore struct{}
+
+func (failingStore) PutAPIKey(key StoredKey) error   { return errors.New("disk full") }
+func (failingStore) DeleteAPIKey(id uuid.UUID) error { return errors.New("disk full") }
+func (failingStore) APIKeys() []StoredKey            { return nil }
+
+func TestKeyStore_ChangesRequireStore(t *testing.T) {
+	store := NewStoredKeyStore(failingStore{})
+	if _, _, err := store.Create(APIKey{Permissions: []Permission{PermissionRead}}); err == nil {
+		t.Error("Key that cannot be stored must not be created.")
+	}
+
+	// Keys added from the configuration are not stored and therefore not affected.
+	key, err := store.Add(APIKey{Permissions: []Permission{PermissionRead}}, "configured")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Update(key.Id, []Permission{PermissionAdmin}, true, nil); err != nil {
+		t.Error("Keys that are not stored must be changed in memory, got", err)
+	}
+}
@@ -0,0 +1,240 @@
+package backup
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	signingcrypto "github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// Version is the format version of bundles written by Seal. Version 3 bundles carry the journal of the device.
+// Bundles of versions 1 and 2 have no journal, so restoring them would leave a gap in the journal of the device;
+// Open rejects them.
+const Version = 3
+
+// journalVersion is the first version of bundles that carry the journal of the device.
+const journalVersion = 3
+
+// KeySize is the size of sealing keys in bytes. Bundles are encrypted with AES-256-GCM.
+const KeySize = 32
+
+// ErrInvalidBundle is returned by Open if a bundle is malformed, was modified or was sealed with another key.
+var ErrInvalidBundle = errors.New("invalid backup bundle")
+
+// Bundle is a sealed backup of a signature device. The header fields can be read without the
+// sealing key, e.g. to check for stale bundles, and are authenticated together with the ciphertext.
+type Bundle struct {
+	Version          int       `json:"version"`
+	DeviceId         uuid.UUID `json:"device_id"`
+	OrganizationId   uuid.UUID `json:"organization_id"`
+	SignatureCounter uint64    `json:"signature_counter"`
+	CreatedAt        time.Time `json:"created_at"`
+	Nonce            []byte    `json:"nonce"`
+	Ciphertext       []byte    `json:"ciphertext"`
+}
+
+// contents is the encrypted part of a bundle.
+type contents struct {
+	Label            string `json:"label"`
+	Algorithm        string `json:"algorithm"`
+	PrivateKey       []byte `json:"private_key"`
+	SignatureCounter uint64 `json:"signature_counter"`
+	LastSignature    []byte `json:"last_signature"`
+	DataFormat       string `json:"data_format,omitempty"`
+	// RetiredKeys holds the PKIX encoded public keys of the previous key versions of the device, oldest first.
+	RetiredKeys [][]byte `json:"retired_keys,omitempty"`
+	// Transactions is the journal of the device up to SignatureCounter, so that the chain continues without gaps
+	// on the instance the bundle is restored on.
+	Transactions []transaction `json:"transactions,omitempty"`
+}
+
+// transaction is a transaction of the journal in a bundle.
+type transaction struct {
+	Counter          uint64     `json:"counter"`
+	SignedData       []byte     `json:"signed_data"`
+	Signature        []byte     `json:"signature"`
+	SignatureVersion int        `json:"signature_version"`
+	KeyVersion       int        `json:"key_version,omitempty"`
+	Timestamp        *timestamp `json:"timestamp,omitempty"`
+}
+
+// timestamp is a time-stamp token over transactions FirstCounter to FirstCounter+Count-1.
+type timestamp struct {
+	Token        []byte `json:"token"`
+	FirstCounter uint64 `json:"first_counter"`
+	Count        int    `json:"count"`
+}
+
+func newTransactions(journal []domain.Transaction) []transaction {
+	transactions := make([]transaction, len(journal))
+	for i, t := range journal {
+		transactions[i] = transaction{
+			Counter:          t.Counter,
+			SignedData:       t.SignedData,
+			Signature:        t.Signature,
+			SignatureVersion: int(t.SignatureVersion),
+			KeyVersion:       t.KeyVersion,
+		}
+		if t.Timestamp != nil {
+			transactions[i].Timestamp = &timestamp{Token: t.Timestamp.Token, FirstCounter: t.Timestamp.FirstCounter, Count: t.Timestamp.Count}
+		}
+	}
+	return transactions
+}
+
+func (b *Bundle) journal(transactions []transaction) []domain.Transaction {
+	journal := make([]domain.Transaction, len(transactions))
+	for i, t := range transactions {
+		journal[i] = domain.Transaction{
+			OrganizationId:   b.OrganizationId,
+			DeviceId:         b.DeviceId,
+			Counter:          t.Counter,
+			SignedData:       t.SignedData,
+			Signature:        t.Signature,
+			SignatureVersion: domain.SignatureVersion(t.SignatureVersion),
+			KeyVersion:       t.KeyVersion,
+		}
+		if t.Timestamp != nil {
+			journal[i].Timestamp = &domain.Timestamp{Token: t.Timestamp.Token, FirstCounter: t.Timestamp.FirstCounter, Count: t.Timestamp.Count}
+		}
+	}
+	return journal
+}
+
+// additionalData encodes the header unambiguously, so that no header field can be changed without detection.
+func (b *Bundle) additionalData() []byte {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.BigEndian, uint32(b.Version))
+	buffer.Write(b.DeviceId[:])
+	buffer.Write(b.OrganizationId[:])
+	binary.Write(&buffer, binary.BigEndian, b.SignatureCounter)
+	createdAt := b.CreatedAt.UTC().Format(time.RFC3339Nano)
+	binary.Write(&buffer, binary.BigEndian, uint32(len(createdAt)))
+	buffer.WriteString(createdAt)
+	return buffer.Bytes()
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("sealing key must have %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal creates a bundle of the device at state with its journal, encrypted with key. The journal must hold the
+// transactions up to the state.
+func Seal(device *domain.SignatureDevice, state domain.DeviceState, journal []domain.Transaction, key []byte, createdAt time.Time) (*Bundle, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	signer, _ := device.Key()
+	privateKey, err := signingcrypto.MarshalPrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	retiredKeys, err := signingcrypto.MarshalPublicKeys(device.RetiredKeys())
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(contents{
+		Label:            device.Label,
+		Algorithm:        signer.GetAlgorithm(),
+		PrivateKey:       privateKey,
+		RetiredKeys:      retiredKeys,
+		SignatureCounter: state.Counter,
+		LastSignature:    state.LastSig,
+		DataFormat:       string(device.DataFormat),
+		Transactions:     newTransactions(journal),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{
+		Version:          Version,
+		DeviceId:         device.Id,
+		OrganizationId:   device.OrganizationId,
+		SignatureCounter: state.Counter,
+		CreatedAt:        createdAt.UTC(),
+		Nonce:            make([]byte, aead.NonceSize()),
+	}
+	if _, err := rand.Read(bundle.Nonce); err != nil {
+		return nil, err
+	}
+	bundle.Ciphertext = aead.Seal(nil, bundle.Nonce, plaintext, bundle.additionalData())
+	return bundle, nil
+}
+
+// Open decrypts a bundle with key and recreates the device with its identity, key pair and state, and returns it
+// with its journal. Bundles without journal are rejected with ErrInvalidBundle. The logger is passed to the
+// restored signer.
+func Open(bundle *Bundle, key []byte, logger *slog.Logger, options ...domain.DeviceOption) (*domain.SignatureDevice, []domain.Transaction, error) {
+	if bundle.Version >= 1 && bundle.Version < journalVersion {
+		return nil, nil, fmt.Errorf("%w: version %d bundles hold no journal and cannot be restored without a gap in it", ErrInvalidBundle, bundle.Version)
+	}
+	if bundle.Version < 1 || bundle.Version > Version {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidBundle, bundle.Version)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(bundle.Nonce) != aead.NonceSize() {
+		return nil, nil, fmt.Errorf("%w: nonce has %d bytes", ErrInvalidBundle, len(bundle.Nonce))
+	}
+	plaintext, err := aead.Open(nil, bundle.Nonce, bundle.Ciphertext, bundle.additionalData())
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+
+	var sealed contents
+	if err := json.Unmarshal(plaintext, &sealed); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if sealed.SignatureCounter != bundle.SignatureCounter {
+		return nil, nil, fmt.Errorf("%w: header and contents disagree on the counter", ErrInvalidBundle)
+	}
+	signer, err := signingcrypto.SignerFromPrivateKey(sealed.Algorithm, sealed.PrivateKey, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	retiredKeys, err := signingcrypto.ParsePublicKeys(sealed.RetiredKeys)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: retired keys: %v", ErrInvalidBundle, err)
+	}
+	state := domain.DeviceState{Counter: sealed.SignatureCounter, LastSig: sealed.LastSignature}
+	// Bundles without data format were sealed before devices could select one and sign in v1.
+	dataFormat := domain.DataFormat(sealed.DataFormat)
+	if dataFormat == "" {
+		dataFormat = domain.DataFormatV1
+	}
+	options = append([]domain.DeviceOption{domain.WithDataFormat(dataFormat), domain.WithRetiredKeys(retiredKeys)}, options...)
+	device := domain.RestoreSignatureDevice(bundle.DeviceId, bundle.OrganizationId, sealed.Label, signer, state, options...)
+	return device, bundle.journal(sealed.Transactions), nil
+}
+
+// SameKey reports whether restored holds the keys of device: the current key of device is the key of the same
+// version of restored, whose key may have been rotated since.
+func SameKey(device, restored *domain.SignatureDevice) bool {
+	version := device.KeyVersion()
+	current, _ := device.PublicKey(version)
+	restoredKey, found := restored.PublicKey(version)
+	publicKey, ok := current.(interface{ Equal(crypto.PublicKey) bool })
+	return found && ok && publicKey.Equal(restoredKey)
+}
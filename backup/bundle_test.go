@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+func TestBundle_SealAndOpen(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	signer, _ := crypto.SignerFactory("RSA")
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "backup", signer)
+	transaction, _ := device.SignDataFenced([]byte("data"), 0)
+	transaction.Timestamp = &domain.Timestamp{Token: []byte("token"), FirstCounter: 0, Count: 1}
+	rotated, _ := crypto.SignerFactory("RSA")
+	device.RotateKey(rotated)
+	second, _ := device.SignDataFenced([]byte("rotated"), 0)
+
+	bundle, err := Seal(device, device.State(), []domain.Transaction{transaction, second}, key, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, journal, err := Open(bundle, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Id != device.Id || restored.Label != device.Label || restored.SignatureCounter != 2 || restored.KeyVersion() != 2 ||
+		!bytes.Equal(restored.LastSig, device.LastSig) || !SameKey(restored, device) {
+		t.Error("Restored device differs from the original.")
+	}
+	if !reflect.DeepEqual(journal, []domain.Transaction{transaction, second}) {
+		t.Error("Journal of the bundle differs from the original, got", journal)
+	}
+	if err := domain.VerifyChain(device.Id, restored, journal); err != nil {
+		t.Error("Journal must verify with the restored keys:", err)
+	}
+}
+
+func TestBundle_RejectsTampering(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	signer, _ := crypto.SignerFactory("ECC")
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "backup", signer)
+
+	bundle, _ := Seal(device, device.State(), nil, key, time.Now())
+	bundle.SignatureCounter = 100
+	if _, _, err := Open(bundle, key, nil); !errors.Is(err, ErrInvalidBundle) {
+		t.Error("A modified header must be detected, got", err)
+	}
+
+	bundle, _ = Seal(device, device.State(), nil, key, time.Now())
+	if _, _, err := Open(bundle, bytes.Repeat([]byte{2}, KeySize), nil); !errors.Is(err, ErrInvalidBundle) {
+		t.Error("Opening with another key must fail, got", err)
+	}
+}
+
+func TestBundle_RejectsVersionsWithoutJournal(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	signer, _ := crypto.SignerFactory("ECC")
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "backup", signer)
+	device.SignData([]byte("first"))
+	device.SignData([]byte("second"))
+
+	// Version 2 bundles hold the state of the device, but not its journal.
+	aead, _ := newAEAD(key)
+	privateKey, _ := crypto.MarshalPrivateKey(signer)
+	plaintext, _ := json.Marshal(contents{
+		Label:            device.Label,
+		Algorithm:        "ECC",
+		PrivateKey:       privateKey,
+		SignatureCounter: 2,
+		LastSignature:    device.LastSig,
+	})
+	bundle := &Bundle{Version: 2, DeviceId: device.Id, SignatureCounter: 2, Nonce: make([]byte, aead.NonceSize())}
+	bundle.Ciphertext = aead.Seal(nil, bundle.Nonce, plaintext, bundle.additionalData())
+
+	_, _, err := Open(bundle, key, nil)
+	if !errors.Is(err, ErrInvalidBundle) || !strings.Contains(err.Error(), "no journal") {
+		t.Error("Bundle without journal must be rejected as such, got", err)
+	}
+}
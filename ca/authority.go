@@ -0,0 +1,184 @@
+// Package ca is the internal certificate authority of the signing service. It issues X.509 certificates for the
+// public keys of signature devices, so that verifiers can rely on certificate chains instead of bare keys.
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// DefaultValidity is the validity period of device certificates if none is configured.
+const DefaultValidity = 365 * 24 * time.Hour
+
+// rootValidity is the validity period of generated root certificates.
+const rootValidity = 10 * 365 * 24 * time.Hour
+
+// ErrInvalidIssuer is returned for a CA certificate that cannot issue certificates with the given key.
+var ErrInvalidIssuer = errors.New("invalid issuer certificate")
+
+// Config configures an Authority.
+type Config struct {
+	// Validity is the validity period of issued certificates. Zero uses DefaultValidity.
+	Validity time.Duration
+	// Clock is the time source of issued certificates. A nil clock uses the system clock.
+	Clock domain.Clock
+}
+
+// Authority issues device certificates with the key of its CA certificate.
+type Authority struct {
+	// chain holds the CA certificate first, followed by the certificates of its issuers, if any.
+	chain    []*x509.Certificate
+	key      crypto.Signer
+	validity time.Duration
+	clock    domain.Clock
+}
+
+// New creates an authority that issues certificates with key. chain starts with the CA certificate of key and may
+// continue with the certificates of its issuers up to a root.
+func New(chain []*x509.Certificate, key crypto.Signer, config Config) (*Authority, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%w: no certificate", ErrInvalidIssuer)
+	}
+	issuer := chain[0]
+	if !issuer.IsCA || (issuer.KeyUsage != 0 && issuer.KeyUsage&x509.KeyUsageCertSign == 0) {
+		return nil, fmt.Errorf("%w: %s is not a CA certificate", ErrInvalidIssuer, issuer.Subject)
+	}
+	if publicKey, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !publicKey.Equal(issuer.PublicKey) {
+		return nil, fmt.Errorf("%w: key does not match %s", ErrInvalidIssuer, issuer.Subject)
+	}
+	authority := &Authority{chain: chain, key: key, validity: config.Validity, clock: config.Clock}
+	if authority.validity <= 0 {
+		authority.validity = DefaultValidity
+	}
+	if authority.clock == nil {
+		authority.clock = domain.SystemClock{}
+	}
+	return authority, nil
+}
+
+// NewRoot generates a self-signed root certificate with a new ECDSA P-384 key.
+func NewRoot(commonName string, now time.Time) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now,
+		NotAfter:              now.Add(rootValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certificate, key, nil
+}
+
+// Chain returns the CA certificate followed by the certificates of its issuers.
+func (a *Authority) Chain() []*x509.Certificate {
+	return append([]*x509.Certificate(nil), a.chain...)
+}
+
+// Issue certifies the current public key of a device. The subject names the device by its label as common name
+// and by its id as serial number and urn:uuid URI; the certificate only allows digital signatures. It returns the
+// DER encoded certificate followed by the chain of the authority.
+func (a *Authority) Issue(device *domain.SignatureDevice) ([][]byte, error) {
+	signer, _ := device.Key()
+	publicKey := signer.GetPublicKey()
+	keyId, err := KeyIdentifier(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	issuer := a.chain[0]
+	now := a.clock.Now().UTC().Truncate(time.Second)
+	notAfter := now.Add(a.validity)
+	if notAfter.After(issuer.NotAfter) {
+		notAfter = issuer.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               Subject(device),
+		URIs:                  []*url.URL{deviceURI(device)},
+		NotBefore:             now,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          keyId,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, publicKey, a.key)
+	if err != nil {
+		return nil, fmt.Errorf("issuing certificate of device %s: %w", device.Id, err)
+	}
+	chain := [][]byte{der}
+	for _, certificate := range a.chain {
+		chain = append(chain, certificate.Raw)
+	}
+	return chain, nil
+}
+
+// Certifies reports whether certificate was issued by the authority for the current key of a device and has not
+// expired yet. Certificates of other authorities, e.g. of an ephemeral root of an earlier run, never certify.
+func (a *Authority) Certifies(certificate *x509.Certificate, device *domain.SignatureDevice) bool {
+	signer, _ := device.Key()
+	publicKey, ok := certificate.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(signer.GetPublicKey()) {
+		return false
+	}
+	if certificate.CheckSignatureFrom(a.chain[0]) != nil {
+		return false
+	}
+	return a.clock.Now().Before(certificate.NotAfter)
+}
+
+// KeyIdentifier derives the key identifier of a public key as in RFC 5280, section 4.2.1.2: the SHA-1 hash of the
+// bits of the subject public key.
+func KeyIdentifier(publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	var subjectPublicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &subjectPublicKeyInfo); err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum(subjectPublicKeyInfo.PublicKey.Bytes)
+	return hash[:], nil
+}
+
+// newSerialNumber returns a random positive 128 bit serial number.
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,112 @@
+package ca
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func newDevice(t *testing.T, algorithm string) *domain.SignatureDevice {
+	signer, err := crypto.SignerFactory(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return domain.NewSignatureDevice(domain.DefaultOrganizationId, "till 1", signer)
+}
+
+func TestAuthority_IssuesDeviceCertificates(t *testing.T) {
+	authority, err := Load("", "", Config{Validity: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.Chain()[0])
+
+	for _, algorithm := range []string{"RSA", "ECC"} {
+		device := newDevice(t, algorithm)
+		chain, err := authority.Issue(device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chain) != 2 {
+			t.Fatal("Expected the device certificate and the root, got", len(chain))
+		}
+		certificate, err := x509.ParseCertificate(chain[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := certificate.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+			t.Error(algorithm, "certificate does not verify:", err)
+		}
+		if certificate.Subject.SerialNumber != device.Id.String() || certificate.Subject.CommonName != "till 1" {
+			t.Error("Subject must name the device, got", certificate.Subject)
+		}
+		if certificate.KeyUsage != x509.KeyUsageDigitalSignature || certificate.IsCA {
+			t.Error("Certificate must only allow digital signatures, got", certificate.KeyUsage)
+		}
+		if validity := certificate.NotAfter.Sub(certificate.NotBefore); validity != time.Hour {
+			t.Error("Expected a validity of an hour, got", validity)
+		}
+		if !authority.Certifies(certificate, device) {
+			t.Error(algorithm, "certificate must certify the device")
+		}
+		if authority.Certifies(certificate, newDevice(t, algorithm)) {
+			t.Error(algorithm, "certificate must not certify another key")
+		}
+	}
+}
+
+func TestAuthority_CertificatesExpire(t *testing.T) {
+	now := time.Now()
+	authority, _ := Load("", "", Config{Validity: time.Hour, Clock: fixedClock(now)})
+	device := newDevice(t, "ECC")
+	chain, _ := authority.Issue(device)
+	certificate, _ := x509.ParseCertificate(chain[0])
+
+	later, _ := New(authority.Chain(), authority.key, Config{Clock: fixedClock(now.Add(2 * time.Hour))})
+	if later.Certifies(certificate, device) {
+		t.Error("Expired certificate must be issued again")
+	}
+}
+
+func TestAuthority_ForeignCertificatesDoNotCertify(t *testing.T) {
+	// An ephemeral root is generated anew on every start.
+	earlier, _ := Load("", "", Config{})
+	device := newDevice(t, "ECC")
+	chain, _ := earlier.Issue(device)
+	certificate, _ := x509.ParseCertificate(chain[0])
+
+	current, _ := Load("", "", Config{})
+	if current.Certifies(certificate, device) {
+		t.Error("Certificate of another root must be issued again")
+	}
+}
+
+func TestLoad_GeneratesAndReloadsRoot(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+	generated, err := Load(certFile, keyFile, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(certFile, keyFile, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Chain()[0].Equal(generated.Chain()[0]) {
+		t.Error("Reloaded CA differs from the generated one")
+	}
+	if _, err := Load(certFile, filepath.Join(dir, "missing.key"), Config{}); err == nil {
+		t.Error("CA certificate without key must be rejected")
+	}
+}
@@ -0,0 +1,129 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// RootCommonName is the common name of generated root certificates.
+const RootCommonName = "Signing Service Device CA"
+
+// Load creates an authority from the PEM encoded CA certificate chain in certFile and the private key in keyFile.
+// If neither file exists, a root certificate and key are generated and written there, the key readable only by
+// the owner. Without files, the generated root is lost on restart.
+func Load(certFile string, keyFile string, config Config) (*Authority, error) {
+	if certFile == "" && keyFile == "" {
+		return generate(config)
+	}
+	certPEM, certErr := os.ReadFile(certFile)
+	keyPEM, keyErr := os.ReadFile(keyFile)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		authority, err := generate(config)
+		if err != nil {
+			return nil, err
+		}
+		return authority, authority.write(certFile, keyFile)
+	}
+	if err := errors.Join(certErr, keyErr); err != nil {
+		return nil, err
+	}
+
+	chain, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("CA certificate %s: %w", certFile, err)
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("CA key %s: %w", keyFile, err)
+	}
+	return New(chain, key, config)
+}
+
+func generate(config Config) (*Authority, error) {
+	clock := config.Clock
+	if clock == nil {
+		clock = domain.SystemClock{}
+	}
+	root, key, err := NewRoot(RootCommonName, clock.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return New([]*x509.Certificate{root}, key, config)
+}
+
+// write stores the chain and key of a generated authority.
+func (a *Authority) write(certFile string, keyFile string) error {
+	key, err := x509.MarshalPKCS8PrivateKey(a.key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, EncodeChain(a.chain), 0o644)
+}
+
+// EncodeChain encodes certificates as consecutive PEM blocks.
+func EncodeChain(chain []*x509.Certificate) []byte {
+	var encoded []byte
+	for _, certificate := range chain {
+		encoded = append(encoded, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})...)
+	}
+	return encoded
+}
+
+// parseCertificates decodes all CERTIFICATE blocks of a PEM file in order.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, certificate)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no PEM encoded certificate")
+	}
+	return chain, nil
+}
+
+// parsePrivateKey decodes a PEM encoded PKCS #8, SEC 1 EC or PKCS #1 RSA private key.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key %T", key)
+	}
+	return signer, nil
+}
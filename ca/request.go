@@ -0,0 +1,75 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	signingcrypto "github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// ErrKeyMismatch is returned for a certificate that does not certify the key of the device.
+var ErrKeyMismatch = errors.New("certificate does not match the device key")
+
+// ErrInvalidChain is returned for a certificate chain that is expired or not issued in order.
+var ErrInvalidChain = errors.New("invalid certificate chain")
+
+// Subject returns the default subject of certificates of a device: its label as common name and its id as serial
+// number.
+func Subject(device *domain.SignatureDevice) pkix.Name {
+	return pkix.Name{CommonName: device.Label, SerialNumber: device.Id.String()}
+}
+
+// deviceURI names a device by its id in the subject alternative names.
+func deviceURI(device *domain.SignatureDevice) *url.URL {
+	return &url.URL{Scheme: "urn", Opaque: "uuid:" + device.Id.String()}
+}
+
+// NewRequest creates a PKCS #10 certificate signing request for the key of a device with subject, signed by the
+// private key of the device, so that an external authority can certify it.
+func NewRequest(device *domain.SignatureDevice, subject pkix.Name) ([]byte, error) {
+	signer, _ := device.Key()
+	key, err := signingcrypto.StandardSigner(signer)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.CertificateRequest{
+		Subject: subject,
+		URIs:    []*url.URL{deviceURI(device)},
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, fmt.Errorf("creating certificate request of device %s: %w", device.Id, err)
+	}
+	return der, nil
+}
+
+// CheckChain checks that chain certifies the key of a device at now: the first certificate must be for the
+// public key of the device, and every certificate must be valid and issued by the next one, if any.
+func CheckChain(chain []*x509.Certificate, device *domain.SignatureDevice, now time.Time) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("%w: no certificate", ErrInvalidChain)
+	}
+	signer, _ := device.Key()
+	publicKey, ok := chain[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(signer.GetPublicKey()) {
+		return ErrKeyMismatch
+	}
+	for i, certificate := range chain {
+		if now.Before(certificate.NotBefore) || now.After(certificate.NotAfter) {
+			return fmt.Errorf("%w: %s is not valid at %s", ErrInvalidChain, certificate.Subject, now.Format(time.RFC3339))
+		}
+		if i+1 < len(chain) {
+			if err := certificate.CheckSignatureFrom(chain[i+1]); err != nil {
+				return fmt.Errorf("%w: %s is not issued by %s: %v", ErrInvalidChain, certificate.Subject, chain[i+1].Subject, err)
+			}
+		}
+	}
+	return nil
+}
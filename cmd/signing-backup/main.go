@@ -0,0 +1,150 @@
+// Command signing-backup moves signature devices between instances of the signing service
+// through the backup and restore admin endpoints.
+//
+//	signing-backup backup -server https://a.example -device <id> -out device.bundle
+//	signing-backup restore -server https://b.example -in device.bundle
+//
+// The admin API key is read from -api-key or the SIGNING_API_KEY environment variable.
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `usage:
+  signing-backup backup -server URL -device ID [-organization ID] [-out FILE]
+  signing-backup restore -server URL [-in FILE]`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "signing-backup:", err)
+		os.Exit(1)
+	}
+}
+
+// client calls the admin endpoints of one signing service instance.
+type client struct {
+	server string
+	apiKey string
+	http   *http.Client
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	command := args[0]
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	server := flags.String("server", "http://localhost:8080", "base URL of the signing service")
+	apiKey := flags.String("api-key", os.Getenv("SIGNING_API_KEY"), "admin API key (default $SIGNING_API_KEY)")
+	caFile := flags.String("ca-file", "", "PEM file with the CA certificate of the server")
+	device := flags.String("device", "", "id of the device to back up")
+	organization := flags.String("organization", "", "organization of the device, for operators")
+	out := flags.String("out", "", "file to write the bundle to (default stdout)")
+	in := flags.String("in", "", "file to read the bundle from (default stdin)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	c := &client{server: strings.TrimSuffix(*server, "/"), apiKey: *apiKey, http: &http.Client{Timeout: 30 * time.Second}}
+	if *caFile != "" {
+		caPEM, err := os.ReadFile(*caFile)
+		if err != nil {
+			return err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			return errors.New("no certificates found in " + *caFile)
+		}
+		c.http.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}}
+	}
+
+	switch command {
+	case "backup":
+		if *device == "" {
+			return errors.New("-device is required")
+		}
+		path := "/api/v0/admin/devices/" + url.PathEscape(*device) + "/backup"
+		if *organization != "" {
+			path += "?organization_id=" + url.QueryEscape(*organization)
+		}
+		bundle, err := c.post(path, nil)
+		if err != nil {
+			return err
+		}
+		if *out == "" {
+			_, err = fmt.Fprintln(stdout, string(bundle))
+			return err
+		}
+		return os.WriteFile(*out, bundle, 0o600)
+	case "restore":
+		var bundle []byte
+		var err error
+		if *in == "" {
+			bundle, err = io.ReadAll(stdin)
+		} else {
+			bundle, err = os.ReadFile(*in)
+		}
+		if err != nil {
+			return err
+		}
+		restored, err := c.post("/api/v0/admin/devices/restore", bundle)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(stdout, string(restored))
+		return err
+	default:
+		return errors.New(usage)
+	}
+}
+
+// post sends body to the path and returns the data of the API response.
+func (c *client) post(path string, body []byte) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodPost, c.server+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(content)))
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(content, &envelope); err != nil {
+		return nil, err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, envelope.Data, "", "  "); err != nil {
+		return nil, err
+	}
+	return indented.Bytes(), nil
+}
@@ -0,0 +1,223 @@
+// Package cms creates detached CMS SignedData structures (RFC 5652) with the keys of signature devices, so that
+// document workflows can check device signatures with standard tools like openssl cms -verify.
+package cms
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+var (
+	oidData            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// contentInfo is the outer structure of CMS messages.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	// Content is the [0] EXPLICIT content.
+	Content asn1.RawValue
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+// encapsulatedContentInfo only names the type of the content, which is detached.
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+}
+
+type signerInfo struct {
+	Version int
+	// Sid is an IssuerAndSerialNumber or a [0] SubjectKeyIdentifier.
+	Sid                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// SignDetached creates SignedData over content without including it. The SHA-256 digest of content, its type and
+// signingTime are signed attributes, signed by signer. With a certificate chain, the certificate of the signer
+// comes first, the signer is identified by its issuer and serial number and the chain is included; without, the
+// signer is identified by the key identifier of its public key, see ca.KeyIdentifier.
+func SignDetached(content []byte, signer crypto.Signer, chain []*x509.Certificate, signingTime time.Time) ([]byte, error) {
+	signatureAlgorithm, err := signatureAlgorithm(signer)
+	if err != nil {
+		return nil, err
+	}
+	sid, err := signerIdentifier(signer, chain)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(content)
+	signedAttrs, err := marshalAttributes(
+		attributeValue{oidContentType, oidData},
+		attributeValue{oidSigningTime, signingTime.UTC()},
+		attributeValue{oidMessageDigest, digest[:]},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature covers the attributes encoded as SET OF, not with the implicit tag they are stored with.
+	signature, err := signer.Sign(append([]byte{0x31}, signedAttrs.FullBytes[1:]...))
+	if err != nil {
+		return nil, err
+	}
+	if signer.GetAlgorithm() == "ECC" {
+		if signature, err = ecdsaSignatureToASN1(signature); err != nil {
+			return nil, err
+		}
+	}
+
+	version := 1
+	if sid.Tag == 0 && sid.Class == asn1.ClassContextSpecific {
+		version = 3
+	}
+	digestAlgorithm := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	data := signedData{
+		Version:          version,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgorithm},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: oidData},
+		SignerInfos: []signerInfo{{
+			Version:            version,
+			Sid:                sid,
+			DigestAlgorithm:    digestAlgorithm,
+			SignedAttrs:        signedAttrs,
+			SignatureAlgorithm: signatureAlgorithm,
+			Signature:          signature,
+		}},
+	}
+	if len(chain) > 0 {
+		var certificates []byte
+		for _, certificate := range chain {
+			certificates = append(certificates, certificate.Raw...)
+		}
+		data.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificates}
+	}
+	encoded, err := asn1.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: asn1.RawValue{
+		Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: encoded,
+	}})
+}
+
+// CheckSigner returns an error if SignDetached cannot sign with signer.
+func CheckSigner(signer crypto.Signer) error {
+	_, err := signatureAlgorithm(signer)
+	return err
+}
+
+// signatureAlgorithm identifies the signature scheme of the device signers, which all hash with SHA-256.
+func signatureAlgorithm(signer crypto.Signer) (pkix.AlgorithmIdentifier, error) {
+	switch signer.GetAlgorithm() {
+	case "RSA":
+		return pkix.AlgorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue}, nil
+	case "ECC":
+		return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, nil
+	default:
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("unsupported algorithm %q", signer.GetAlgorithm())
+	}
+}
+
+// signerIdentifier identifies the signer by the first certificate of chain or, without chain, by its key.
+func signerIdentifier(signer crypto.Signer, chain []*x509.Certificate) (asn1.RawValue, error) {
+	if len(chain) == 0 {
+		keyId, err := ca.KeyIdentifier(signer.GetPublicKey())
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: keyId}, nil
+	}
+	encoded, err := asn1.Marshal(issuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: chain[0].RawIssuer},
+		SerialNumber: chain[0].SerialNumber,
+	})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	return asn1.RawValue{FullBytes: encoded}, nil
+}
+
+// attributeValue is an attribute with a single value to be encoded.
+type attributeValue struct {
+	attributeType asn1.ObjectIdentifier
+	value         interface{}
+}
+
+// marshalAttributes encodes attributes with a single value each as [0] IMPLICIT SET OF in DER order.
+func marshalAttributes(values ...attributeValue) (asn1.RawValue, error) {
+	encoded := make([][]byte, 0, len(values))
+	for _, value := range values {
+		inner, err := asn1.Marshal(value.value)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		attributeBytes, err := asn1.Marshal(attribute{
+			Type:   value.attributeType,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: inner},
+		})
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		encoded = append(encoded, attributeBytes)
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+	set := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(encoded, nil)}
+	full, err := asn1.Marshal(set)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	set.FullBytes = full
+	return set, nil
+}
+
+// ecdsaSignatureToASN1 converts an ECDSA signature of the device signers, r and s of equal size concatenated, to
+// the ASN.1 SEQUENCE of two INTEGERs used by CMS.
+func ecdsaSignatureToASN1(signature []byte) ([]byte, error) {
+	if len(signature) == 0 || len(signature)%2 != 0 {
+		return nil, errors.New("malformed ECDSA signature")
+	}
+	size := len(signature) / 2
+	return asn1.Marshal(struct{ R, S *big.Int }{
+		new(big.Int).SetBytes(signature[:size]),
+		new(big.Int).SetBytes(signature[size:]),
+	})
+}
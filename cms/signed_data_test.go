@@ -0,0 +1,135 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	signingcrypto "github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// parse decodes the SignedData of a CMS message and checks the signature of its only signer over the signed
+// attributes.
+func parse(t *testing.T, encoded []byte, signer signingcrypto.Signer) (signedData, []attribute) {
+	t.Helper()
+	var info contentInfo
+	if rest, err := asn1.Unmarshal(encoded, &info); err != nil || len(rest) > 0 || !info.ContentType.Equal(oidSignedData) {
+		t.Fatal("Expected a ContentInfo with SignedData, got", info.ContentType, err)
+	}
+	var data signedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.SignerInfos) != 1 {
+		t.Fatal("Expected one signer, got", len(data.SignerInfos))
+	}
+	signerInfo := data.SignerInfos[0]
+	if !signerInfo.DigestAlgorithm.Algorithm.Equal(oidSHA256) {
+		t.Error("Digest algorithm must be SHA-256, got", signerInfo.DigestAlgorithm.Algorithm)
+	}
+
+	signed := append([]byte{0x31}, signerInfo.SignedAttrs.FullBytes[1:]...)
+	digest := sha256.Sum256(signed)
+	switch publicKey := signer.GetPublicKey().(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signerInfo.Signature); err != nil {
+			t.Error("RSA signature must verify:", err)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(publicKey, digest[:], signerInfo.Signature) {
+			t.Error("ECDSA signature must verify")
+		}
+	}
+
+	var attributes []attribute
+	if _, err := asn1.UnmarshalWithParams(signed, &attributes, "set"); err != nil {
+		t.Fatal(err)
+	}
+	return data, attributes
+}
+
+// attributeBytes returns the value of the attribute of type oid.
+func attributeBytes(t *testing.T, attributes []attribute, oid asn1.ObjectIdentifier) []byte {
+	t.Helper()
+	for _, attribute := range attributes {
+		if attribute.Type.Equal(oid) {
+			return attribute.Values.Bytes
+		}
+	}
+	t.Fatal("Missing attribute", oid)
+	return nil
+}
+
+func TestSignDetached_IdentifiesSignerByKey(t *testing.T) {
+	signer := signingcrypto.NewECCSigner()
+	content := []byte("receipt")
+	signingTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	encoded, err := SignDetached(content, signer, nil, signingTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, attributes := parse(t, encoded, signer)
+
+	keyId, _ := ca.KeyIdentifier(signer.GetPublicKey())
+	sid := data.SignerInfos[0].Sid
+	if data.Version != 3 || sid.Class != asn1.ClassContextSpecific || !bytes.Equal(sid.Bytes, keyId) {
+		t.Error("Signer must be identified by its key identifier, got version", data.Version)
+	}
+	if len(data.Certificates.Bytes) > 0 {
+		t.Error("No certificates must be included without chain")
+	}
+
+	var digest []byte
+	asn1.Unmarshal(attributeBytes(t, attributes, oidMessageDigest), &digest)
+	if expected := sha256.Sum256(content); !bytes.Equal(digest, expected[:]) {
+		t.Error("Message digest must be the SHA-256 digest of the content")
+	}
+	var signedAt time.Time
+	asn1.Unmarshal(attributeBytes(t, attributes, oidSigningTime), &signedAt)
+	if !signedAt.Equal(signingTime) {
+		t.Error("Signing time must be signed, got", signedAt)
+	}
+}
+
+func TestSignDetached_IncludesCertificateChain(t *testing.T) {
+	authority, err := ca.Load("", "", ca.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "till", signingcrypto.NewRSASigner())
+	der, err := authority.Issue(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chain []*x509.Certificate
+	for _, certificate := range der {
+		parsed, _ := x509.ParseCertificate(certificate)
+		chain = append(chain, parsed)
+	}
+
+	encoded, err := SignDetached([]byte("receipt"), device.Signer, chain, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := parse(t, encoded, device.Signer)
+
+	var sid issuerAndSerialNumber
+	if _, err := asn1.Unmarshal(data.SignerInfos[0].Sid.FullBytes, &sid); err != nil || data.Version != 1 {
+		t.Fatal("Signer must be identified by issuer and serial number, got version", data.Version, err)
+	}
+	if !bytes.Equal(sid.Issuer.FullBytes, chain[0].RawIssuer) || sid.SerialNumber.Cmp(chain[0].SerialNumber) != 0 {
+		t.Error("Signer must be the certificate of the device")
+	}
+	if included := bytes.Join([][]byte{der[0], der[1]}, nil); !bytes.Equal(data.Certificates.Bytes, included) {
+		t.Error("Certificate chain must be included")
+	}
+}
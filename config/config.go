@@ -0,0 +1,361 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/backup"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds all configuration parameters of the signing service.
+type Config struct {
+	ListenAddress string          `json:"listen_address" yaml:"listen_address"`
+	Storage       StorageConfig   `json:"storage" yaml:"storage"`
+	KeyPolicy     KeyPolicyConfig `json:"key_policy" yaml:"key_policy"`
+	TLS           TLSConfig       `json:"tls" yaml:"tls"`
+	Auth          AuthConfig      `json:"auth" yaml:"auth"`
+	Backup        BackupConfig    `json:"backup" yaml:"backup"`
+	Timestamp     TimestampConfig `json:"timestamp_authority" yaml:"timestamp_authority"`
+	Anchoring     AnchoringConfig `json:"anchoring" yaml:"anchoring"`
+	CA            CAConfig        `json:"certificate_authority" yaml:"certificate_authority"`
+	Ownership     OwnershipConfig `json:"ownership" yaml:"ownership"`
+	Timeouts      TimeoutConfig   `json:"timeouts" yaml:"timeouts"`
+	Log           LogConfig       `json:"log" yaml:"log"`
+}
+
+// StorageConfig selects the storage backend for signature devices.
+// With DataDir, the memory backend writes every change to a write-ahead log in that directory
+// and compacts it with a snapshot every SnapshotInterval; zero only snapshots on shutdown.
+type StorageConfig struct {
+	Backend          string   `json:"backend" yaml:"backend"`
+	DataDir          string   `json:"data_dir" yaml:"data_dir"`
+	SnapshotInterval Duration `json:"snapshot_interval" yaml:"snapshot_interval"`
+}
+
+// KeyPolicyConfig restricts which algorithms may be used for new devices and how their keys are generated.
+type KeyPolicyConfig struct {
+	Algorithms []string `json:"algorithms" yaml:"algorithms"`
+	RSABits    int      `json:"rsa_bits" yaml:"rsa_bits"`
+	ECCCurve   string   `json:"ecc_curve" yaml:"ecc_curve"`
+}
+
+// TLSConfig holds the certificate files used to serve HTTPS.
+type TLSConfig struct {
+	Enabled      bool   `json:"enabled" yaml:"enabled"`
+	CertFile     string `json:"cert_file" yaml:"cert_file"`
+	KeyFile      string `json:"key_file" yaml:"key_file"`
+	ClientCAFile string `json:"client_ca_file" yaml:"client_ca_file"`
+}
+
+// AuthConfig controls API key authentication.
+// AdminKey is the secret of an initial admin key used to create further keys through the admin endpoints.
+type AuthConfig struct {
+	Enabled  bool   `json:"enabled" yaml:"enabled"`
+	AdminKey string `json:"admin_key" yaml:"admin_key"`
+}
+
+// BackupConfig enables device backup and restore.
+// Key is the base64 encoded 32 byte key that seals bundles; it must be the same on all instances devices move between.
+type BackupConfig struct {
+	Key string `json:"key" yaml:"key"`
+}
+
+// SealingKey decodes the backup key. It returns nil if backups are disabled.
+func (c BackupConfig) SealingKey() ([]byte, error) {
+	if c.Key == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("backup.key must be base64 encoded: %v", err)
+	}
+	if len(key) != backup.KeySize {
+		return nil, fmt.Errorf("backup.key must be %d bytes, got %d", backup.KeySize, len(key))
+	}
+	return key, nil
+}
+
+// TimestampConfig enables RFC 3161 time-stamp tokens of a third-party authority for all signatures.
+// URL is the HTTP endpoint of the authority; an empty URL disables timestamping.
+type TimestampConfig struct {
+	URL     string   `json:"url" yaml:"url"`
+	Timeout Duration `json:"timeout" yaml:"timeout"`
+}
+
+// AnchoringConfig enables anchoring all transactions in a Merkle tree every Interval; zero disables it.
+// Tree heads are signed by the service key of KeyAlgorithm in KeyFile, which is generated if it does not exist.
+// Without KeyFile, a new key is generated on every start.
+type AnchoringConfig struct {
+	Interval     Duration `json:"interval" yaml:"interval"`
+	KeyFile      string   `json:"key_file" yaml:"key_file"`
+	KeyAlgorithm string   `json:"key_algorithm" yaml:"key_algorithm"`
+}
+
+// CAConfig enables the internal certificate authority that certifies device keys for Validity.
+// The PEM encoded CA certificate chain and key are read from CertFile and KeyFile; if neither exists, a root
+// certificate and key are generated there. Without files, a new root is generated on every start.
+type CAConfig struct {
+	Enabled  bool     `json:"enabled" yaml:"enabled"`
+	CertFile string   `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string   `json:"key_file" yaml:"key_file"`
+	Validity Duration `json:"validity" yaml:"validity"`
+}
+
+// OwnershipConfig enables device leases for replicas sharing a repository. A replica identified by ReplicaId
+// only signs with devices it leases for LeaseTTL, and forwards requests for other devices to their owner, or
+// redirects clients there if Redirect is set. Address is the base URL other replicas reach this replica under.
+// Leases only coordinate replicas sharing a backend, so ownership cannot be enabled with the memory backend.
+type OwnershipConfig struct {
+	Enabled   bool     `json:"enabled" yaml:"enabled"`
+	ReplicaId string   `json:"replica_id" yaml:"replica_id"`
+	Address   string   `json:"address" yaml:"address"`
+	LeaseTTL  Duration `json:"lease_ttl" yaml:"lease_ttl"`
+	Redirect  bool     `json:"redirect" yaml:"redirect"`
+}
+
+// TimeoutConfig holds the HTTP server timeouts.
+type TimeoutConfig struct {
+	Read     Duration `json:"read" yaml:"read"`
+	Write    Duration `json:"write" yaml:"write"`
+	Idle     Duration `json:"idle" yaml:"idle"`
+	Shutdown Duration `json:"shutdown" yaml:"shutdown"`
+}
+
+// LogConfig holds the logging settings.
+type LogConfig struct {
+	Level  string `json:"level" yaml:"level"`
+	Format string `json:"format" yaml:"format"`
+}
+
+// Duration is a time.Duration that is written as a string like "5s" in configuration files.
+type Duration time.Duration
+
+// UnmarshalText parses a duration string.
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalText formats the duration as a string.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Default returns the configuration used when no other source overrides a value.
+func Default() Config {
+	return Config{
+		ListenAddress: ":8080",
+		Storage: StorageConfig{
+			Backend:          "memory",
+			SnapshotInterval: Duration(5 * time.Minute),
+		},
+		KeyPolicy: KeyPolicyConfig{
+			Algorithms: []string{"RSA", "ECC"},
+			RSABits:    2048,
+			ECCCurve:   "P384",
+		},
+		Timestamp: TimestampConfig{
+			Timeout: Duration(10 * time.Second),
+		},
+		Anchoring: AnchoringConfig{
+			KeyAlgorithm: "ECC",
+		},
+		CA: CAConfig{
+			Validity: Duration(365 * 24 * time.Hour),
+		},
+		Ownership: OwnershipConfig{
+			LeaseTTL: Duration(30 * time.Second),
+		},
+		Timeouts: TimeoutConfig{
+			Read:     Duration(10 * time.Second),
+			Write:    Duration(30 * time.Second),
+			Idle:     Duration(120 * time.Second),
+			Shutdown: Duration(30 * time.Second),
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// Validate checks the configuration for invalid or inconsistent values.
+// All problems are reported at once.
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.ListenAddress == "" {
+		errs = append(errs, errors.New("listen_address must not be empty"))
+	}
+
+	switch c.Storage.Backend {
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("storage.backend %q is not supported", c.Storage.Backend))
+	}
+
+	if len(c.KeyPolicy.Algorithms) == 0 {
+		errs = append(errs, errors.New("key_policy.algorithms must list at least one algorithm"))
+	}
+	for _, algorithm := range c.KeyPolicy.Algorithms {
+		switch strings.ToUpper(algorithm) {
+		case "RSA", "ECC":
+		default:
+			errs = append(errs, fmt.Errorf("key_policy.algorithms: unsupported algorithm %q", algorithm))
+		}
+	}
+	if c.KeyPolicy.RSABits < 1024 || c.KeyPolicy.RSABits > 8192 {
+		errs = append(errs, fmt.Errorf("key_policy.rsa_bits must be between 1024 and 8192, got %d", c.KeyPolicy.RSABits))
+	}
+	if _, err := crypto.CurveByName(c.KeyPolicy.ECCCurve); err != nil {
+		errs = append(errs, fmt.Errorf("key_policy.ecc_curve: %v (use P256, P384 or P521)", err))
+	}
+
+	if c.TLS.Enabled {
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			errs = append(errs, errors.New("tls.cert_file and tls.key_file are required when tls is enabled"))
+		}
+		for _, file := range []string{c.TLS.CertFile, c.TLS.KeyFile, c.TLS.ClientCAFile} {
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(file); err != nil {
+				errs = append(errs, fmt.Errorf("tls: %v", err))
+			}
+		}
+	} else if c.TLS.ClientCAFile != "" {
+		errs = append(errs, errors.New("tls.client_ca_file requires tls to be enabled"))
+	}
+
+	if c.Auth.Enabled && len(c.Auth.AdminKey) < 16 {
+		errs = append(errs, errors.New("auth.admin_key must have at least 16 characters when auth is enabled"))
+	}
+
+	if _, err := c.Backup.SealingKey(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Timestamp.URL != "" {
+		if parsed, err := url.Parse(c.Timestamp.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("timestamp_authority.url must be an http or https URL, got %q", c.Timestamp.URL))
+		}
+	}
+
+	if c.Anchoring.Interval < 0 {
+		errs = append(errs, errors.New("anchoring.interval must not be negative"))
+	}
+	switch strings.ToUpper(c.Anchoring.KeyAlgorithm) {
+	case "RSA", "ECC":
+	default:
+		errs = append(errs, fmt.Errorf("anchoring.key_algorithm: unsupported algorithm %q", c.Anchoring.KeyAlgorithm))
+	}
+
+	if (c.CA.CertFile == "") != (c.CA.KeyFile == "") {
+		errs = append(errs, errors.New("certificate_authority.cert_file and certificate_authority.key_file must be set together"))
+	}
+
+	if c.Ownership.Enabled {
+		if c.Storage.Backend == "memory" {
+			errs = append(errs, errors.New("ownership.enabled requires a storage backend shared between replicas, storage.backend memory is local to one process"))
+		}
+		if c.Ownership.ReplicaId == "" {
+			errs = append(errs, errors.New("ownership.replica_id is required when ownership is enabled"))
+		}
+		if parsed, err := url.Parse(c.Ownership.Address); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("ownership.address must be an http or https URL, got %q", c.Ownership.Address))
+		}
+	}
+
+	if c.Storage.SnapshotInterval < 0 {
+		errs = append(errs, errors.New("storage.snapshot_interval must not be negative"))
+	}
+
+	// Durations of disabled features are unused and not checked.
+	durations := []struct {
+		name  string
+		value Duration
+		used  bool
+	}{
+		{"timestamp_authority.timeout", c.Timestamp.Timeout, c.Timestamp.URL != ""},
+		{"certificate_authority.validity", c.CA.Validity, c.CA.Enabled},
+		{"ownership.lease_ttl", c.Ownership.LeaseTTL, c.Ownership.Enabled},
+		{"timeouts.read", c.Timeouts.Read, true},
+		{"timeouts.write", c.Timeouts.Write, true},
+		{"timeouts.idle", c.Timeouts.Idle, true},
+		{"timeouts.shutdown", c.Timeouts.Shutdown, true},
+	}
+	for _, duration := range durations {
+		if duration.used && duration.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", duration.name))
+		}
+	}
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level %q is not supported (use debug, info, warn or error)", c.Log.Level))
+	}
+	switch c.Log.Format {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log.format %q is not supported (use text or json)", c.Log.Format))
+	}
+
+	return errors.Join(errs...)
+}
+
+// SignerKeyPolicy converts the key policy settings into a crypto.KeyPolicy.
+func (c KeyPolicyConfig) SignerKeyPolicy() (crypto.KeyPolicy, error) {
+	curve, err := crypto.CurveByName(c.ECCCurve)
+	if err != nil {
+		return crypto.KeyPolicy{}, err
+	}
+	return crypto.KeyPolicy{
+		Algorithms: c.Algorithms,
+		RSABits:    c.RSABits,
+		ECCCurve:   curve,
+	}, nil
+}
+
+// loadFile decodes a YAML or JSON configuration file on top of the given configuration.
+// The format is chosen by the file extension; unknown keys are rejected.
+func loadFile(path string, cfg *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		if err = decoder.Decode(cfg); err == io.EOF {
+			err = nil
+		}
+	case ".json":
+		decoder := json.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(cfg)
+	default:
+		return fmt.Errorf("config file %s: unsupported extension (use .yaml, .yml or .json)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
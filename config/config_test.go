@@ -0,0 +1,149 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfig_Defaults(t *testing.T) {
+	cfg, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ListenAddress != ":8080" {
+		t.Error("Default listen address not applied.")
+	}
+	if cfg.Storage.Backend != "memory" {
+		t.Error("Default storage backend not applied.")
+	}
+}
+
+func TestConfig_YAMLFile(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+listen_address: ":9000"
+key_policy:
+  algorithms: [ECC]
+  rsa_bits: 4096
+  ecc_curve: P256
+timeouts:
+  read: 3s
+`)
+	cfg, err := Load([]string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ListenAddress != ":9000" {
+		t.Error("Listen address not read from file.")
+	}
+	if len(cfg.KeyPolicy.Algorithms) != 1 || cfg.KeyPolicy.Algorithms[0] != "ECC" {
+		t.Error("Algorithms not read from file.")
+	}
+	if time.Duration(cfg.Timeouts.Read) != 3*time.Second {
+		t.Error("Read timeout not read from file.")
+	}
+	if time.Duration(cfg.Timeouts.Write) != 30*time.Second {
+		t.Error("Defaults must be kept for values missing in file.")
+	}
+}
+
+func TestConfig_JSONFileFromEnv(t *testing.T) {
+	path := writeFile(t, "config.json", `{"listen_address": ":9001", "log": {"level": "debug"}}`)
+	cfg, err := Load(nil, env(map[string]string{"SIGNING_CONFIG": path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ListenAddress != ":9001" || cfg.Log.Level != "debug" {
+		t.Error("Config file from environment not loaded.")
+	}
+}
+
+func TestConfig_Precedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", "listen_address: \":9000\"\nrsa_bits_typo: 1\n")
+	_, err := Load([]string{"-config", path}, env(nil))
+	if err == nil {
+		t.Error("Unknown keys in config file must be rejected.")
+	}
+
+	path = writeFile(t, "config.yaml", "listen_address: \":9000\"\nlog:\n  level: warn\n  format: json\n")
+	cfg, err := Load(
+		[]string{"-config", path, "-listen-address", ":9002"},
+		env(map[string]string{"SIGNING_LISTEN_ADDRESS": ":9001", "SIGNING_LOG_LEVEL": "error"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ListenAddress != ":9002" {
+		t.Error("Flags must take precedence over environment and file.")
+	}
+	if cfg.Log.Level != "error" {
+		t.Error("Environment must take precedence over file.")
+	}
+	if cfg.Log.Format != "json" {
+		t.Error("File must take precedence over defaults.")
+	}
+}
+
+func TestConfig_Validation(t *testing.T) {
+	_, err := Load(
+		[]string{"-key-algorithms", "RSA,DSA", "-ecc-curve", "P192", "-rsa-bits", "256", "-read-timeout", "0s", "-backup-key", "c2hvcnQ=", "-tsa-url", "tsa.example.com", "-anchor-key-algorithm", "DSA2", "-ca-key-file", "ca.key", "-replica-address", "replica-1:8080"},
+		env(map[string]string{"SIGNING_TLS_ENABLED": "true", "SIGNING_AUTH_ENABLED": "true", "SIGNING_OWNERSHIP_ENABLED": "true"}),
+	)
+	if err == nil {
+		t.Fatal("Invalid configuration must be rejected.")
+	}
+
+	for _, expected := range []string{"DSA", "P192", "rsa_bits", "timeouts.read", "tls.cert_file", "auth.admin_key", "backup.key", "timestamp_authority.url", "anchoring.key_algorithm", "certificate_authority.cert_file", "ownership.enabled", "ownership.replica_id", "ownership.address"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Validation error must mention %s, got: %v", expected, err)
+		}
+	}
+}
+
+func TestConfig_ValidatesDurationsOfEnabledFeatures(t *testing.T) {
+	if _, err := Load([]string{"-snapshot-interval", "0s", "-lease-ttl", "0s", "-tsa-timeout", "0s", "-ca-validity", "0s"}, env(nil)); err != nil {
+		t.Fatal("Zero snapshot interval and durations of disabled features must be accepted, got:", err)
+	}
+
+	_, err := Load([]string{"-snapshot-interval", "-1s", "-tsa-url", "https://tsa.example.com", "-tsa-timeout", "0s", "-ca-validity", "0s"},
+		env(map[string]string{"SIGNING_CA_ENABLED": "true"}))
+	if err == nil {
+		t.Fatal("Invalid durations of enabled features must be rejected.")
+	}
+	for _, expected := range []string{"storage.snapshot_interval", "timestamp_authority.timeout", "certificate_authority.validity"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Validation error must mention %s, got: %v", expected, err)
+		}
+	}
+}
+
+func TestConfig_InvalidValues(t *testing.T) {
+	if _, err := Load([]string{"-rsa-bits", "many"}, env(nil)); err == nil {
+		t.Error("Non numeric key size must be rejected.")
+	}
+
+	if _, err := Load(nil, env(map[string]string{"SIGNING_WRITE_TIMEOUT": "soon"})); err == nil {
+		t.Error("Invalid duration must be rejected.")
+	}
+}
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is prepended to the names of all environment variables read by Load.
+const EnvPrefix = "SIGNING_"
+
+// binding connects a configuration parameter to its command line flag and environment variable.
+type binding struct {
+	flag  string
+	env   string
+	usage string
+	set   func(cfg *Config, value string) error
+}
+
+var bindings = []binding{
+	{"listen-address", "LISTEN_ADDRESS", "address the HTTP server listens on", func(c *Config, v string) error {
+		c.ListenAddress = v
+		return nil
+	}},
+	{"storage-backend", "STORAGE_BACKEND", "storage backend for signature devices (memory)", func(c *Config, v string) error {
+		c.Storage.Backend = v
+		return nil
+	}},
+	{"data-dir", "DATA_DIR", "directory of the write-ahead log; empty keeps all data in memory only", func(c *Config, v string) error {
+		c.Storage.DataDir = v
+		return nil
+	}},
+	{"snapshot-interval", "SNAPSHOT_INTERVAL", "period of snapshots that compact the write-ahead log; 0 disables them", func(c *Config, v string) error {
+		return c.Storage.SnapshotInterval.UnmarshalText([]byte(v))
+	}},
+	{"key-algorithms", "KEY_ALGORITHMS", "comma separated list of algorithms allowed for new devices", func(c *Config, v string) error {
+		c.KeyPolicy.Algorithms = splitList(v)
+		return nil
+	}},
+	{"rsa-bits", "RSA_BITS", "size of generated RSA keys in bits", func(c *Config, v string) error {
+		bits, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		c.KeyPolicy.RSABits = bits
+		return nil
+	}},
+	{"ecc-curve", "ECC_CURVE", "curve of generated ECC keys (P256, P384, P521)", func(c *Config, v string) error {
+		c.KeyPolicy.ECCCurve = v
+		return nil
+	}},
+	{"tls-enabled", "TLS_ENABLED", "serve HTTPS instead of HTTP", func(c *Config, v string) error {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.TLS.Enabled = enabled
+		return nil
+	}},
+	{"tls-cert-file", "TLS_CERT_FILE", "PEM encoded server certificate", func(c *Config, v string) error {
+		c.TLS.CertFile = v
+		return nil
+	}},
+	{"tls-key-file", "TLS_KEY_FILE", "PEM encoded server private key", func(c *Config, v string) error {
+		c.TLS.KeyFile = v
+		return nil
+	}},
+	{"tls-client-ca-file", "TLS_CLIENT_CA_FILE", "PEM encoded CA used to verify client certificates", func(c *Config, v string) error {
+		c.TLS.ClientCAFile = v
+		return nil
+	}},
+	{"auth-enabled", "AUTH_ENABLED", "require API keys for all requests except the health check", func(c *Config, v string) error {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.Auth.Enabled = enabled
+		return nil
+	}},
+	{"auth-admin-key", "AUTH_ADMIN_KEY", "secret of the initial admin API key", func(c *Config, v string) error {
+		c.Auth.AdminKey = v
+		return nil
+	}},
+	{"backup-key", "BACKUP_KEY", "base64 encoded 32 byte key sealing device backups; enables backup and restore", func(c *Config, v string) error {
+		c.Backup.Key = v
+		return nil
+	}},
+	{"tsa-url", "TSA_URL", "URL of an RFC 3161 time-stamp authority for all signatures; empty disables timestamping", func(c *Config, v string) error {
+		c.Timestamp.URL = v
+		return nil
+	}},
+	{"tsa-timeout", "TSA_TIMEOUT", "maximum duration of a time-stamp request", func(c *Config, v string) error {
+		return c.Timestamp.Timeout.UnmarshalText([]byte(v))
+	}},
+	{"anchor-interval", "ANCHOR_INTERVAL", "period of anchoring new transactions in a Merkle tree; 0 disables anchoring", func(c *Config, v string) error {
+		return c.Anchoring.Interval.UnmarshalText([]byte(v))
+	}},
+	{"anchor-key-file", "ANCHOR_KEY_FILE", "PEM file of the service key signing tree heads; generated if missing", func(c *Config, v string) error {
+		c.Anchoring.KeyFile = v
+		return nil
+	}},
+	{"anchor-key-algorithm", "ANCHOR_KEY_ALGORITHM", "algorithm of the service key (RSA, ECC)", func(c *Config, v string) error {
+		c.Anchoring.KeyAlgorithm = v
+		return nil
+	}},
+	{"ca-enabled", "CA_ENABLED", "issue certificates for the keys of devices with the internal certificate authority", func(c *Config, v string) error {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.CA.Enabled = enabled
+		return nil
+	}},
+	{"ca-cert-file", "CA_CERT_FILE", "PEM encoded CA certificate chain; generated with the key if both are missing", func(c *Config, v string) error {
+		c.CA.CertFile = v
+		return nil
+	}},
+	{"ca-key-file", "CA_KEY_FILE", "PEM encoded CA private key", func(c *Config, v string) error {
+		c.CA.KeyFile = v
+		return nil
+	}},
+	{"ca-validity", "CA_VALIDITY", "validity period of device certificates", func(c *Config, v string) error {
+		return c.CA.Validity.UnmarshalText([]byte(v))
+	}},
+	{"ownership-enabled", "OWNERSHIP_ENABLED", "lease devices to coordinate signing with other replicas", func(c *Config, v string) error {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.Ownership.Enabled = enabled
+		return nil
+	}},
+	{"replica-id", "REPLICA_ID", "identifier of this replica in device leases", func(c *Config, v string) error {
+		c.Ownership.ReplicaId = v
+		return nil
+	}},
+	{"replica-address", "REPLICA_ADDRESS", "base URL under which other replicas reach this replica", func(c *Config, v string) error {
+		c.Ownership.Address = v
+		return nil
+	}},
+	{"lease-ttl", "LEASE_TTL", "duration a device lease stays valid without being renewed", func(c *Config, v string) error {
+		return c.Ownership.LeaseTTL.UnmarshalText([]byte(v))
+	}},
+	{"ownership-redirect", "OWNERSHIP_REDIRECT", "redirect requests for devices of other replicas instead of forwarding them", func(c *Config, v string) error {
+		redirect, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.Ownership.Redirect = redirect
+		return nil
+	}},
+	{"read-timeout", "READ_TIMEOUT", "maximum duration for reading a request", func(c *Config, v string) error {
+		return c.Timeouts.Read.UnmarshalText([]byte(v))
+	}},
+	{"write-timeout", "WRITE_TIMEOUT", "maximum duration for writing a response", func(c *Config, v string) error {
+		return c.Timeouts.Write.UnmarshalText([]byte(v))
+	}},
+	{"idle-timeout", "IDLE_TIMEOUT", "maximum duration a keep-alive connection stays idle", func(c *Config, v string) error {
+		return c.Timeouts.Idle.UnmarshalText([]byte(v))
+	}},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "maximum duration to wait for in-flight requests on shutdown", func(c *Config, v string) error {
+		return c.Timeouts.Shutdown.UnmarshalText([]byte(v))
+	}},
+	{"log-level", "LOG_LEVEL", "log level (debug, info, warn, error)", func(c *Config, v string) error {
+		c.Log.Level = v
+		return nil
+	}},
+	{"log-format", "LOG_FORMAT", "log format (text, json)", func(c *Config, v string) error {
+		c.Log.Format = v
+		return nil
+	}},
+}
+
+// Load assembles the configuration from defaults, a configuration file, environment variables
+// and command line flags. Later sources take precedence over earlier ones.
+// The configuration file is given by the -config flag or the SIGNING_CONFIG environment variable.
+// The resulting configuration is validated before it is returned.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet("signing-service", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to a YAML or JSON configuration file")
+	flagValues := make(map[string]string)
+	for _, b := range bindings {
+		name := b.flag
+		flags.Func(name, b.usage, func(value string) error {
+			flagValues[name] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configFile == "" {
+		*configFile, _ = lookupEnv(EnvPrefix + "CONFIG")
+	}
+	if *configFile != "" {
+		if err := loadFile(*configFile, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	for _, b := range bindings {
+		value, ok := lookupEnv(EnvPrefix + b.env)
+		if !ok {
+			continue
+		}
+		if err := b.set(&cfg, value); err != nil {
+			return cfg, fmt.Errorf("environment variable %s%s: %v", EnvPrefix, b.env, err)
+		}
+	}
+
+	for _, b := range bindings {
+		value, ok := flagValues[b.flag]
+		if !ok {
+			continue
+		}
+		if err := b.set(&cfg, value); err != nil {
+			return cfg, fmt.Errorf("flag -%s: %v", b.flag, err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// splitList splits a comma separated list and drops empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
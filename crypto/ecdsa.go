@@ -4,6 +4,10 @@ import (
 	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"log/slog"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
 )
 
 // ECCKeyPair is a DTO that holds ECC private and public keys.
@@ -12,6 +16,11 @@ type ECCKeyPair struct {
 	Private *ecdsa.PrivateKey
 }
 
+// LogValue keeps the key material out of logs.
+func (keyPair ECCKeyPair) LogValue() slog.Value {
+	return slog.StringValue(logging.Redacted)
+}
+
 // ECCMarshaler can encode and decode an ECC key pair.
 type ECCMarshaler struct{}
 
@@ -28,7 +37,7 @@ func (m ECCMarshaler) Encode(keyPair ECCKeyPair) ([]byte, []byte, error) {
 		return nil, nil, err
 	}
 
-	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&keyPair.Public)
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(keyPair.Public)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -49,6 +58,9 @@ func (m ECCMarshaler) Encode(keyPair ECCKeyPair) ([]byte, []byte, error) {
 // Decode assembles an ECCKeyPair from an encoded private key.
 func (m ECCMarshaler) Decode(privateKeyBytes []byte) (*ECCKeyPair, error) {
 	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
 	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+)
+
+// MarshalPrivateKey encodes the key pair of a signer with the marshaler of its algorithm.
+func MarshalPrivateKey(signer Signer) ([]byte, error) {
+	switch signer := signer.(type) {
+	case *RSASigner:
+		marshaler := NewRSAMarshaler()
+		_, privateKey, err := marshaler.Marshal(*signer.keyPair)
+		return privateKey, err
+	case *ECCSigner:
+		_, privateKey, err := NewECCMarshaler().Encode(*signer.keyPair)
+		return privateKey, err
+	default:
+		return nil, fmt.Errorf("cannot marshal key of signer %T", signer)
+	}
+}
+
+// StandardSigner returns the private key of a signer as crypto.Signer of the standard library, e.g. to sign
+// certificates or certificate requests with the key of a device.
+func StandardSigner(signer Signer) (crypto.Signer, error) {
+	switch signer := signer.(type) {
+	case *RSASigner:
+		return signer.keyPair.Private, nil
+	case *ECCSigner:
+		return signer.keyPair.Private, nil
+	default:
+		return nil, fmt.Errorf("no private key of signer %T", signer)
+	}
+}
+
+// SignerFromPrivateKey restores a signer of the algorithm from a key encoded by MarshalPrivateKey.
+// A nil logger discards all log records.
+func SignerFromPrivateKey(algorithm string, privateKey []byte, logger *slog.Logger) (Signer, error) {
+	switch algorithm {
+	case "RSA":
+		marshaler := NewRSAMarshaler()
+		keyPair, err := marshaler.Unmarshal(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		return NewRSASignerFromKeyPair(keyPair, logger), nil
+	case "ECC":
+		keyPair, err := NewECCMarshaler().Decode(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		return NewECCSignerFromKeyPair(keyPair, logger), nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+}
+
+// MarshalPublicKeys encodes public keys as PKIX DER, e.g. the retired keys of a device.
+func MarshalPublicKeys(publicKeys []crypto.PublicKey) ([][]byte, error) {
+	var encoded [][]byte
+	for _, publicKey := range publicKeys {
+		der, err := x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, der)
+	}
+	return encoded, nil
+}
+
+// ParsePublicKeys decodes public keys encoded by MarshalPublicKeys.
+func ParsePublicKeys(encoded [][]byte) ([]crypto.PublicKey, error) {
+	var publicKeys []crypto.PublicKey
+	for _, der := range encoded {
+		publicKey, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, err
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return publicKeys, nil
+}
@@ -4,6 +4,10 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"log/slog"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
 )
 
 // RSAKeyPair is a DTO that holds RSA private and public keys.
@@ -12,6 +16,11 @@ type RSAKeyPair struct {
 	Private *rsa.PrivateKey
 }
 
+// LogValue keeps the key material out of logs.
+func (keyPair RSAKeyPair) LogValue() slog.Value {
+	return slog.StringValue(logging.Redacted)
+}
+
 // RSAMarshaler can encode and decode an RSA key pair.
 type RSAMarshaler struct{}
 
@@ -42,6 +51,9 @@ func (m *RSAMarshaler) Marshal(keyPair RSAKeyPair) ([]byte, []byte, error) {
 // Unmarshal takes an encoded RSA private key and transforms it into a rsa.PrivateKey.
 func (m *RSAMarshaler) Unmarshal(privateKeyBytes []byte) (*RSAKeyPair, error) {
 	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
 	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	if err != nil {
 		return nil, err
@@ -6,15 +6,18 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
-	"encoding/base64"
 	"fmt"
+	"log/slog"
 	"math/big"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
 )
 
 // Signer defines a contract for different types of signing implementations.
+// Signatures are raw bytes; encoding them, e.g. as base64, is left to the caller.
 type Signer interface {
 	Sign(dataToBeSigned []byte) ([]byte, error)
-	VerifySignature(data []byte, base64Signature []byte) bool
+	VerifySignature(data []byte, signature []byte) bool
 	GetPublicKey() crypto.PublicKey
 	GetAlgorithm() string
 }
@@ -22,43 +25,48 @@ type Signer interface {
 // RSASigner stores RSA Keys and handles data signing and verifying.
 type RSASigner struct {
 	keyPair *RSAKeyPair
+	logger  *slog.Logger
 }
 
 // ECCSigner stores ECDS Keys and handles data signing and verifying.
 type ECCSigner struct {
 	keyPair *ECCKeyPair
+	logger  *slog.Logger
 }
 
 // NewRSASigner is a factory to instantiate a new RSASigner.
 func NewRSASigner() *RSASigner {
 	keyGenerator := RSAGenerator{}
 	keyPair, _ := keyGenerator.Generate()
-	return &RSASigner{keyPair}
+	return &RSASigner{keyPair, logging.Discard()}
+}
+
+// NewRSASignerFromKeyPair instantiates a RSASigner for an existing key pair.
+// A nil logger discards all log records.
+func NewRSASignerFromKeyPair(keyPair *RSAKeyPair, logger *slog.Logger) *RSASigner {
+	if logger == nil {
+		logger = logging.Discard()
+	}
+	return &RSASigner{keyPair, logger}
 }
 
-// Sign of RSASigner signs the data with RSA algorithm.
+// Sign of RSASigner signs the SHA-256 hash of the data with RSASSA-PKCS1-v1_5.
 func (signer *RSASigner) Sign(dataToBeSigned []byte) ([]byte, error) {
 	hashed := sha256.Sum256(dataToBeSigned)
 	signature, err := rsa.SignPKCS1v15(rand.Reader, signer.keyPair.Private, crypto.SHA256, hashed[:])
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign data: %v", err)
 	}
-	base64Bytes := make([]byte, base64.StdEncoding.EncodedLen(len(signature)))
-	base64.StdEncoding.Encode(base64Bytes, signature)
-	return base64Bytes, nil
+	return signature, nil
 }
 
 // VerifySignature of RSASigner verifies the signature of given data with RSA algorithm.
-func (signer *RSASigner) VerifySignature(data []byte, base64Signature []byte) bool {
-	signatureBytes, err := base64.StdEncoding.DecodeString(string(base64Signature))
-	if err != nil {
-		fmt.Println("Error decoding signature:", err)
+func (signer *RSASigner) VerifySignature(data []byte, signature []byte) bool {
+	if err := verifyRSA(signer.keyPair.Public, data, signature); err != nil {
+		signer.logger.Debug("Signature verification failed", "algorithm", signer.GetAlgorithm(), "error", err)
 		return false
 	}
-
-	hashed := sha256.Sum256(data)
-	err = rsa.VerifyPKCS1v15(signer.keyPair.Public, crypto.SHA256, hashed[:], signatureBytes)
-	return err == nil
+	return true
 }
 
 // GetPublicKey of RSASigner returns RSA Public Key.
@@ -70,14 +78,29 @@ func (signer RSASigner) GetAlgorithm() string {
 	return "RSA"
 }
 
+// LogValue keeps the key material out of logs.
+func (signer *RSASigner) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("algorithm", signer.GetAlgorithm()))
+}
+
 // NewECCSigner is a factory to instantiate a new ECCSigner.
 func NewECCSigner() *ECCSigner {
 	keyGenerator := ECCGenerator{}
 	keyPair, _ := keyGenerator.Generate()
-	return &ECCSigner{keyPair}
+	return &ECCSigner{keyPair, logging.Discard()}
+}
+
+// NewECCSignerFromKeyPair instantiates an ECCSigner for an existing key pair.
+// A nil logger discards all log records.
+func NewECCSignerFromKeyPair(keyPair *ECCKeyPair, logger *slog.Logger) *ECCSigner {
+	if logger == nil {
+		logger = logging.Discard()
+	}
+	return &ECCSigner{keyPair, logger}
 }
 
-// Sign of ECCSigner signs the data with ECDS algorithm.
+// Sign of ECCSigner signs the SHA-256 hash of the data with ECDSA. The signature is r and s as big-endian
+// integers of the byte size of the curve order each, concatenated (IEEE P1363).
 func (signer *ECCSigner) Sign(dataToBeSigned []byte) ([]byte, error) {
 	hashed := sha256.Sum256(dataToBeSigned)
 	r, s, err := ecdsa.Sign(rand.Reader, signer.keyPair.Private, hashed[:])
@@ -85,31 +108,27 @@ func (signer *ECCSigner) Sign(dataToBeSigned []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to sign data: %v", err)
 	}
 
-	signature := append(r.Bytes(), s.Bytes()...)
-	base64Bytes := make([]byte, base64.StdEncoding.EncodedLen(len(signature)))
-	base64.StdEncoding.Encode(base64Bytes, signature)
-
-	return base64Bytes, nil
+	size := signer.scalarSize()
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signature, nil
 }
 
 // VerifySignature of ECCSigner verifies the signature of given data with ECDS algorithm.
-func (signer *ECCSigner) VerifySignature(data []byte, base64Signature []byte) bool {
-	decodedSignature := make([]byte, base64.StdEncoding.DecodedLen(len(base64Signature)))
-	n, err := base64.StdEncoding.Decode(decodedSignature, base64Signature)
-	if err != nil {
-		fmt.Println("Error decoding signature:", err)
+// Signatures of other sizes than those created by Sign are split in half, as signatures created before r and s
+// were padded to a fixed size.
+func (signer *ECCSigner) VerifySignature(data []byte, signature []byte) bool {
+	if !verifyECC(signer.keyPair.Public, data, signature) {
+		signer.logger.Debug("Signature verification failed", "algorithm", signer.GetAlgorithm())
 		return false
 	}
+	return true
+}
 
-	decodedSignature = decodedSignature[:n]
-	rBytes := decodedSignature[:len(decodedSignature)/2]
-	sBytes := decodedSignature[len(decodedSignature)/2:]
-	var r, s big.Int
-	r.SetBytes(rBytes)
-	s.SetBytes(sBytes)
-	hashed := sha256.Sum256(data)
-
-	return ecdsa.Verify(signer.keyPair.Public, hashed[:], &r, &s)
+// scalarSize is the byte size of r and s in signatures of the curve.
+func (signer *ECCSigner) scalarSize() int {
+	return (signer.keyPair.Public.Curve.Params().N.BitLen() + 7) / 8
 }
 
 // GetPublicKey of ECCSigner returns ECDS Public Key
@@ -120,3 +139,36 @@ func (signer *ECCSigner) GetPublicKey() crypto.PublicKey {
 func (signer ECCSigner) GetAlgorithm() string {
 	return "ECC"
 }
+
+// LogValue keeps the key material out of logs.
+func (signer *ECCSigner) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("algorithm", signer.GetAlgorithm()))
+}
+
+// VerifySignature verifies a signature created by the signer of publicKey, e.g. by a key that was rotated since
+// and is no longer available as signer.
+func VerifySignature(publicKey crypto.PublicKey, data []byte, signature []byte) bool {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		return verifyRSA(publicKey, data, signature) == nil
+	case *ecdsa.PublicKey:
+		return verifyECC(publicKey, data, signature)
+	default:
+		return false
+	}
+}
+
+// verifyRSA verifies an RSASSA-PKCS1-v1_5 signature over the SHA-256 hash of the data.
+func verifyRSA(publicKey *rsa.PublicKey, data []byte, signature []byte) error {
+	hashed := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signature)
+}
+
+// verifyECC verifies an ECDSA signature over the SHA-256 hash of the data, see ECCSigner.VerifySignature.
+func verifyECC(publicKey *ecdsa.PublicKey, data []byte, signature []byte) bool {
+	var r, s big.Int
+	r.SetBytes(signature[:len(signature)/2])
+	s.SetBytes(signature[len(signature)/2:])
+	hashed := sha256.Sum256(data)
+	return ecdsa.Verify(publicKey, hashed[:], &r, &s)
+}
@@ -1,7 +1,10 @@
 package crypto
 
 import (
+	"crypto/elliptic"
 	"errors"
+	"fmt"
+	"log/slog"
 	"strings"
 )
 
@@ -18,3 +21,71 @@ func SignerFactory(algorithm string) (Signer, error) {
 		return nil, errors.New("unsupported algorithm")
 	}
 }
+
+// KeyPolicy restricts the algorithms available for new signers and defines how their keys are generated.
+type KeyPolicy struct {
+	Algorithms []string
+	RSABits    int
+	ECCCurve   elliptic.Curve
+	// Logger is handed to the created signers. A nil logger discards all log records.
+	Logger *slog.Logger
+}
+
+// DefaultKeyPolicy allows all supported algorithms with the default key sizes.
+func DefaultKeyPolicy() KeyPolicy {
+	return KeyPolicy{
+		Algorithms: []string{"RSA", "ECC"},
+		RSABits:    DefaultRSABits,
+		ECCCurve:   elliptic.P384(),
+	}
+}
+
+// CurveByName returns the NIST curve with the given name (P256, P384 or P521).
+func CurveByName(name string) (elliptic.Curve, error) {
+	switch strings.ToUpper(name) {
+	case "P256", "P-256":
+		return elliptic.P256(), nil
+	case "P384", "P-384":
+		return elliptic.P384(), nil
+	case "P521", "P-521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", name)
+	}
+}
+
+// NewSigner generates a key pair for the given algorithm according to the policy.
+func (p KeyPolicy) NewSigner(algorithm string) (Signer, error) {
+	algorithm = strings.ToUpper(algorithm)
+	if !p.allows(algorithm) {
+		return nil, errors.New("unsupported algorithm")
+	}
+
+	switch algorithm {
+	case "RSA":
+		generator := RSAGenerator{Bits: p.RSABits}
+		keyPair, err := generator.Generate()
+		if err != nil {
+			return nil, err
+		}
+		return NewRSASignerFromKeyPair(keyPair, p.Logger), nil
+	case "ECC":
+		generator := ECCGenerator{Curve: p.ECCCurve}
+		keyPair, err := generator.Generate()
+		if err != nil {
+			return nil, err
+		}
+		return NewECCSignerFromKeyPair(keyPair, p.Logger), nil
+	default:
+		return nil, errors.New("unsupported algorithm")
+	}
+}
+
+func (p KeyPolicy) allows(algorithm string) bool {
+	for _, allowed := range p.Algorithms {
+		if strings.ToUpper(allowed) == algorithm {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,12 @@
 package crypto
 
 import (
+	"bytes"
+	"log/slog"
+	"strings"
 	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
 )
 
 func TestRSASigner_Sign(t *testing.T) {
@@ -76,3 +81,68 @@ func TestSignerFactory_XYZSigner(t *testing.T) {
 		t.Error("Signer Factory failed.")
 	}
 }
+
+func TestKeyPolicy_NewSigner(t *testing.T) {
+	policy := DefaultKeyPolicy()
+	policy.Algorithms = []string{"ECC"}
+
+	signer, err := policy.NewSigner("ecc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.GetAlgorithm() != "ECC" {
+		t.Error("Key policy created wrong signer.")
+	}
+
+	if _, err := policy.NewSigner("RSA"); err == nil {
+		t.Error("Key policy must reject algorithms that are not allowed.")
+	}
+}
+
+func TestKeyPair_LogValueRedacted(t *testing.T) {
+	generator := RSAGenerator{}
+	keyPair, _ := generator.Generate()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	logger.Info("key", "pair", *keyPair, "signer", NewRSASignerFromKeyPair(keyPair, nil))
+
+	if strings.Contains(logs.String(), keyPair.Private.D.String()) || !strings.Contains(logs.String(), logging.Redacted) {
+		t.Error("Key material must be redacted in logs.")
+	}
+}
+
+func TestMarshalPrivateKey_RoundTrip(t *testing.T) {
+	for _, algorithm := range []string{"RSA", "ECC"} {
+		signer, _ := SignerFactory(algorithm)
+		privateKey, err := MarshalPrivateKey(signer)
+		if err != nil {
+			t.Fatal(algorithm, err)
+		}
+		restored, err := SignerFromPrivateKey(algorithm, privateKey, nil)
+		if err != nil {
+			t.Fatal(algorithm, err)
+		}
+
+		data := []byte("Hello, World!")
+		signature, _ := restored.Sign(data)
+		if !signer.VerifySignature(data, signature) {
+			t.Error("Restored", algorithm, "signer must use the same key.")
+		}
+	}
+	if _, err := SignerFromPrivateKey("ECC", []byte("garbage"), nil); err == nil {
+		t.Error("Invalid key material must be rejected.")
+	}
+}
+
+func TestECCSigner_FixedSizeSignatures(t *testing.T) {
+	signer := NewECCSigner()
+	// r or s with leading zero bytes occur in about one of 128 signatures.
+	for i := 0; i < 500; i++ {
+		data := []byte{byte(i), byte(i >> 8)}
+		signature, _ := signer.Sign(data)
+		if len(signature) != 96 || !signer.VerifySignature(data, signature) {
+			t.Fatal("P-384 signatures must have 96 bytes and verify, got", len(signature))
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AnchorLeaf is the leaf of a transaction in the anchoring tree: the 16 bytes of the device id, the counter as
+// 8 byte big-endian integer and the raw signature. The signature binds the secured data, so the leaf commits to
+// the complete transaction.
+func AnchorLeaf(transaction Transaction) []byte {
+	leaf := make([]byte, 0, len(transaction.DeviceId)+8+len(transaction.Signature))
+	leaf = append(leaf, transaction.DeviceId[:]...)
+	leaf = binary.BigEndian.AppendUint64(leaf, transaction.Counter)
+	return append(leaf, transaction.Signature...)
+}
+
+// AnchorRef identifies an anchored transaction.
+type AnchorRef struct {
+	DeviceId uuid.UUID
+	Counter  uint64
+}
+
+// TreeHead is the root of the anchoring tree over the first Size anchored transactions, signed by the service key.
+type TreeHead struct {
+	Size      uint64
+	RootHash  []byte
+	Timestamp time.Time
+	Signature []byte
+}
+
+// SignedData returns the input of the signature of the tree head:
+// tree-head:v1:<size>:<timestamp>:<base64 root hash>, with the timestamp in the TimestampLayout.
+func (head TreeHead) SignedData() []byte {
+	return []byte("tree-head:v1:" + strconv.FormatUint(head.Size, 10) + ":" + head.Timestamp.UTC().Format(TimestampLayout) +
+		":" + base64.StdEncoding.EncodeToString(head.RootHash))
+}
@@ -0,0 +1,10 @@
+package domain
+
+// CertificateChain is the certificate chain of a device, its own DER encoded certificate first, followed by the
+// certificates of its issuers.
+type CertificateChain struct {
+	Chain [][]byte
+	// Attached tells that the chain was issued elsewhere and attached, rather than issued by the certificate
+	// authority of the service.
+	Attached bool
+}
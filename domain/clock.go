@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// Clock tells the time signatures are created at.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock of the operating system.
+type SystemClock struct{}
+
+// Now returns the current time.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock makes the device take the time of its signatures from clock instead of the SystemClock.
+func WithClock(clock Clock) DeviceOption {
+	return func(device *SignatureDevice) {
+		device.clock = clock
+	}
+}
@@ -1,51 +1,267 @@
 package domain
 
 import (
+	gocrypto "crypto"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
 	"github.com/google/uuid"
 )
 
 // SignatureDevice is struct holding all signature device data.
 type SignatureDevice struct {
 	Id               uuid.UUID
+	OrganizationId   uuid.UUID
 	Label            string
 	SignatureCounter uint64
 	Signer           crypto.Signer
 	LastSig          []byte
-	sigMutex         sync.RWMutex
+	// DataFormat is the format of the secured data the device signs.
+	DataFormat DataFormat
+	// Version is the version of the committed state the counter and last signature were read from.
+	Version uint64
+	// retiredKeys holds the public keys of the previous key versions, version v at index v-1, see RotateKey.
+	retiredKeys []gocrypto.PublicKey
+	sigMutex    sync.RWMutex
+	observer    Observer
+	journal     Journal
+	clock       Clock
+	logger      *slog.Logger
 }
 
-// NewSignatureDevice is factory that initializes signature device.
-func NewSignatureDevice(label string, signer crypto.Signer) *SignatureDevice {
+// Observer receives measurements of signature device operations, e.g. to export them as metrics.
+type Observer interface {
+	ObserveLockWait(algorithm string, wait time.Duration)
+}
+
+// DeviceOption configures the dependencies of a signature device.
+type DeviceOption func(*SignatureDevice)
+
+// WithObserver reports measurements of the device to the observer.
+func WithObserver(observer Observer) DeviceOption {
+	return func(device *SignatureDevice) {
+		device.observer = observer
+	}
+}
+
+// WithLogger makes the device log its operations. Data to be signed is never logged.
+func WithLogger(logger *slog.Logger) DeviceOption {
+	return func(device *SignatureDevice) {
+		device.logger = logger
+	}
+}
+
+// WithJournal records every transaction of the device in journal.
+// A transaction that cannot be recorded fails the signing operation.
+// The journal is the authority on the device state: signing retries with the committed state
+// if another instance of the device advanced it in the meantime.
+func WithJournal(journal Journal) DeviceOption {
+	return func(device *SignatureDevice) {
+		device.journal = journal
+	}
+}
+
+// NewSignatureDevice is factory that initializes signature device owned by the given organization.
+func NewSignatureDevice(organizationId uuid.UUID, label string, signer crypto.Signer, options ...DeviceOption) *SignatureDevice {
 	id := uuid.New()
-	base64EncodedId := make([]byte, base64.StdEncoding.EncodedLen(len(id[:])))
-	base64.StdEncoding.Encode(base64EncodedId, id[:])
 	if label == "" {
 		label = id.String()
 	}
-	signatureDevice := SignatureDevice{id, label, 0, signer, base64EncodedId, sync.RWMutex{}}
-	return &signatureDevice
+	// The first signature is chained to the id of the device.
+	return RestoreSignatureDevice(id, organizationId, label, signer, DeviceState{LastSig: id[:]}, options...)
 }
 
+// RestoreSignatureDevice recreates a signature device with a known identity and state, e.g. from a backup.
+func RestoreSignatureDevice(id uuid.UUID, organizationId uuid.UUID, label string, signer crypto.Signer, state DeviceState, options ...DeviceOption) *SignatureDevice {
+	signatureDevice := &SignatureDevice{
+		Id:               id,
+		OrganizationId:   organizationId,
+		Label:            label,
+		SignatureCounter: state.Counter,
+		Signer:           signer,
+		LastSig:          state.LastSig,
+		DataFormat:       DefaultDataFormat,
+		Version:          state.Version,
+		clock:            SystemClock{},
+		logger:           logging.Discard(),
+	}
+	for _, option := range options {
+		option(signatureDevice)
+	}
+	return signatureDevice
+}
+
+// ErrStaleState is returned if a restored state would not advance the signature counter.
+var ErrStaleState = errors.New("state does not advance the signature counter")
+
+// Restore replaces counter and last signature with a later state of the same device.
+// Restoring an older or the current state would reuse counters and is rejected with ErrStaleState.
+func (device *SignatureDevice) Restore(counter uint64, lastSig []byte) error {
+	device.sigMutex.Lock()
+	defer device.sigMutex.Unlock()
+	if counter <= device.SignatureCounter {
+		return fmt.Errorf("%w: restoring counter %d, device is at %d", ErrStaleState, counter, device.SignatureCounter)
+	}
+	device.SignatureCounter = counter
+	device.LastSig = lastSig
+	return nil
+}
+
+// maxCommitAttempts limits how often a signature is retried after a version conflict.
+const maxCommitAttempts = 5
+
+// SignData signs the data chained to the previous signature of the device. A counter is reserved for the
+// signature and only committed once the data was signed and recorded in the journal; on any failure the
+// counter and last signature stay unchanged, so the chain has no gaps. If the journal reports that the
+// device state was changed elsewhere, the signature is discarded and created again on the committed state.
 func (device *SignatureDevice) SignData(rawData []byte) ([]byte, []byte, error) {
+	transaction, err := device.SignDataFenced(rawData, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return transaction.SignedData, transaction.Signature, nil
+}
+
+// SignDataFenced works like SignData for a replica that owns the device through a lease and returns the
+// committed transaction. The journal rejects the transaction with ErrFenced if the lease with fencingToken
+// was taken over.
+func (device *SignatureDevice) SignDataFenced(rawData []byte, fencingToken uint64) (Transaction, error) {
+	transactions, err := device.SignBatch([][]byte{rawData}, fencingToken)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return transactions[0], nil
+}
+
+// ErrEmptyBatch is returned by SignBatch if there is nothing to sign.
+var ErrEmptyBatch = errors.New("batch contains no data")
+
+// SignBatch signs every item of rawData in order under a single acquisition of the device lock. The items get
+// consecutive counters and each signature is chained to the one before. The transactions are committed to the
+// journal at once, so either all of them advance the device or, on any failure, none.
+func (device *SignatureDevice) SignBatch(rawData [][]byte, fencingToken uint64) ([]Transaction, error) {
+	if len(rawData) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	waitStart := time.Now()
 	device.sigMutex.Lock()
 	defer device.sigMutex.Unlock()
-	data := prepareData(device.SignatureCounter, rawData, device.LastSig)
-	signature, err := device.Signer.Sign(data)
-	device.setLastSignature(signature)
+	if device.observer != nil {
+		device.observer.ObserveLockWait(device.Signer.GetAlgorithm(), time.Since(waitStart))
+	}
 
-	return data, signature, err
+	var transactions []Transaction
+	var version uint64
+	var err error
+	for attempt := 1; ; attempt++ {
+		transactions, version, err = device.sign(device.reserve(), rawData, fencingToken)
+		if !errors.Is(err, ErrVersionConflict) || attempt == maxCommitAttempts {
+			break
+		}
+		device.logger.Debug("Device state changed concurrently, retrying", "device", device, "attempt", attempt)
+		if err = device.refresh(); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		device.logger.Error("Signing failed", "device", device, "error", err)
+		return nil, err
+	}
+	device.commit(transactions[len(transactions)-1], version)
+	device.logger.Debug("Data signed", "device", device, "signatures", len(transactions))
+
+	return transactions, nil
 }
 
-// SetLastSignature updates last signature and increments signature counter
-func (device *SignatureDevice) setLastSignature(lastSig []byte) {
-	device.SignatureCounter += 1
-	device.LastSig = lastSig
+// reservation is the counter and last signature the next signature of a device is chained to,
+// and the version of the state they belong to.
+type reservation struct {
+	counter uint64
+	lastSig []byte
+	version uint64
+}
+
+// reserve returns the reservation for the next signature. The caller must hold the device lock.
+func (device *SignatureDevice) reserve() reservation {
+	return reservation{device.SignatureCounter, device.LastSig, device.Version}
+}
+
+// sign creates and journals the chained transactions starting at a reservation without changing the state of
+// the device. It returns the version of the device state after the transactions.
+func (device *SignatureDevice) sign(r reservation, rawData [][]byte, fencingToken uint64) ([]Transaction, uint64, error) {
+	transactions := make([]Transaction, 0, len(rawData))
+	counter, lastSig := r.counter, r.lastSig
+	for _, item := range rawData {
+		securedData := SecuredData{Format: device.DataFormat, Counter: counter, Time: device.clock.Now(), Data: item, LastSig: lastSig}
+		data := securedData.Bytes()
+		signature, err := device.Signer.Sign(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		transactions = append(transactions, Transaction{
+			OrganizationId:   device.OrganizationId,
+			DeviceId:         device.Id,
+			Counter:          counter,
+			SignedData:       data,
+			Signature:        signature,
+			FencingToken:     fencingToken,
+			SignatureVersion: CurrentSignatureVersion,
+			KeyVersion:       len(device.retiredKeys) + 1,
+		})
+		counter, lastSig = counter+1, signature
+	}
+	if device.journal == nil {
+		return transactions, r.version + uint64(len(transactions)), nil
+	}
+	version, err := device.journal.CommitTransactions(transactions, r.version)
+	if err != nil {
+		return nil, 0, fmt.Errorf("recording transactions: %w", err)
+	}
+	return transactions, version, nil
+}
+
+// commit makes the last signed transaction the state of the device. The caller must hold the device lock.
+func (device *SignatureDevice) commit(transaction Transaction, version uint64) {
+	device.SignatureCounter = transaction.Counter + 1
+	device.LastSig = transaction.Signature
+	device.Version = version
+}
+
+// refresh loads the committed state from the journal. The caller must hold the device lock.
+func (device *SignatureDevice) refresh() error {
+	state, err := device.journal.DeviceState(device.Id)
+	if err != nil {
+		return err
+	}
+	device.SignatureCounter = state.Counter
+	device.LastSig = state.LastSig
+	device.Version = state.Version
+	return nil
+}
+
+// State returns the signature counter, the last signature and their version, consistent with each other.
+func (device *SignatureDevice) State() DeviceState {
+	device.sigMutex.RLock()
+	defer device.sigMutex.RUnlock()
+	return DeviceState{device.SignatureCounter, device.LastSig, device.Version}
+}
+
+// LogValue describes the device in logs without its key material or signatures.
+func (device *SignatureDevice) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", device.Id.String()),
+		slog.String("organization_id", device.OrganizationId.String()),
+		slog.String("algorithm", device.Signer.GetAlgorithm()),
+		slog.Uint64("counter", device.SignatureCounter),
+	)
 }
 
 // PrepareData appends and prepends id and last signature to the data from sign request.
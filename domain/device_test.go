@@ -3,26 +3,26 @@ package domain
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	crypto2 "github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/google/uuid"
 )
 
 func TestDevice_CreateDevice(t *testing.T) {
 	algorithm := "RSA"
 	signer, _ := crypto2.SignerFactory(algorithm)
-	signatureDevice := NewSignatureDevice("", signer)
+	signatureDevice := NewSignatureDevice(DefaultOrganizationId, "", signer)
 
 	if signatureDevice.SignatureCounter != 0 {
 		t.Error("Device not properly initialized")
 	}
 
-	base64EncodedId := make([]byte, base64.StdEncoding.EncodedLen(len(signatureDevice.Id[:])))
-	base64.StdEncoding.Encode(base64EncodedId, signatureDevice.Id[:])
-
-	if !bytes.Equal(signatureDevice.LastSig, base64EncodedId) {
+	if !bytes.Equal(signatureDevice.LastSig, signatureDevice.Id[:]) {
 		t.Error("Device not properly initialized")
 	}
 }
@@ -30,7 +30,7 @@ func TestDevice_CreateDevice(t *testing.T) {
 func TestDevice_CreateAndSign(t *testing.T) {
 	algorithm := "RSA"
 	signer, _ := crypto2.SignerFactory(algorithm)
-	signatureDevice := NewSignatureDevice("", signer)
+	signatureDevice := NewSignatureDevice(DefaultOrganizationId, "", signer)
 
 	data, signature, _ := signatureDevice.SignData([]byte("Hello World!"))
 
@@ -51,7 +51,7 @@ func TestDevice_CreateAndSign(t *testing.T) {
 func TestDevice_ConcurrentSignatures(t *testing.T) {
 	algorithm := "RSA"
 	signer, _ := crypto2.SignerFactory(algorithm)
-	signatureDevice := NewSignatureDevice("", signer)
+	signatureDevice := NewSignatureDevice(DefaultOrganizationId, "", signer)
 
 	var wg sync.WaitGroup
 	goroutinesNum := 10
@@ -72,3 +72,249 @@ func TestDevice_PrepareData(t *testing.T) {
 		t.Error("Data preparation failed")
 	}
 }
+
+type lockWaitRecorder struct {
+	mu    sync.Mutex
+	waits []time.Duration
+}
+
+func (r *lockWaitRecorder) ObserveLockWait(algorithm string, wait time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waits = append(r.waits, wait)
+}
+
+func TestDevice_ObserveLockWait(t *testing.T) {
+	signer, _ := crypto2.SignerFactory("ECC")
+	observer := &lockWaitRecorder{}
+	signatureDevice := NewSignatureDevice(DefaultOrganizationId, "", signer, WithObserver(observer))
+
+	signatureDevice.SignData([]byte("Hello World!"))
+	signatureDevice.SignData([]byte("Hello World!"))
+
+	if len(observer.waits) != 2 {
+		t.Error("Lock wait not observed for every signature.")
+	}
+}
+
+func TestDevice_RestoreRejectsStaleState(t *testing.T) {
+	signer, _ := crypto2.SignerFactory("ECC")
+	signatureDevice := NewSignatureDevice(DefaultOrganizationId, "", signer)
+	signatureDevice.SignData([]byte("first"))
+	signatureDevice.SignData([]byte("second"))
+
+	if err := signatureDevice.Restore(1, []byte("older")); !errors.Is(err, ErrStaleState) {
+		t.Error("Restoring an older counter must fail, got", err)
+	}
+	if err := signatureDevice.Restore(2, []byte("same")); !errors.Is(err, ErrStaleState) {
+		t.Error("Restoring the current counter must fail, got", err)
+	}
+	if err := signatureDevice.Restore(5, []byte("later")); err != nil || signatureDevice.SignatureCounter != 5 {
+		t.Error("Restoring a later state must advance the device, got", err)
+	}
+}
+
+// failingSigner fails every signature while failing is set.
+type failingSigner struct {
+	crypto2.Signer
+	failing bool
+}
+
+func (s *failingSigner) Sign(dataToBeSigned []byte) ([]byte, error) {
+	if s.failing {
+		return nil, errors.New("signer unavailable")
+	}
+	return s.Signer.Sign(dataToBeSigned)
+}
+
+type failingJournal struct {
+	failing      bool
+	transactions []Transaction
+}
+
+func (j *failingJournal) CommitTransactions(transactions []Transaction, version uint64) (uint64, error) {
+	if j.failing {
+		return 0, errors.New("storage unavailable")
+	}
+	j.transactions = append(j.transactions, transactions...)
+	return version + uint64(len(transactions)), nil
+}
+
+func (j *failingJournal) DeviceState(deviceId uuid.UUID) (DeviceState, error) {
+	return DeviceState{}, errors.New("not supported")
+}
+
+func TestDevice_FailedSignatureKeepsState(t *testing.T) {
+	rsaSigner, _ := crypto2.SignerFactory("RSA")
+	signer := &failingSigner{Signer: rsaSigner}
+	signatureDevice := NewSignatureDevice(DefaultOrganizationId, "", signer)
+	_, firstSignature, _ := signatureDevice.SignData([]byte("first"))
+
+	signer.failing = true
+	if _, _, err := signatureDevice.SignData([]byte("lost")); err == nil {
+		t.Fatal("Signing must fail with a failing signer.")
+	}
+	if signatureDevice.SignatureCounter != 1 || !bytes.Equal(signatureDevice.LastSig, firstSignature) {
+		t.Error("A failed signature must not advance the device, counter is", signatureDevice.SignatureCounter)
+	}
+
+	signer.failing = false
+	data, _, err := signatureDevice.SignData([]byte("second"))
+	securedData, _ := ParseSecuredData(data)
+	if err != nil || securedData.Counter != 1 || !bytes.Equal(securedData.LastSig, firstSignature) {
+		t.Error("The next signature must reuse the reserved counter and chain to the last successful signature.")
+	}
+}
+
+func TestDevice_FailedPersistenceKeepsState(t *testing.T) {
+	signer, _ := crypto2.SignerFactory("ECC")
+	journal := &failingJournal{}
+	signatureDevice := NewSignatureDevice(DefaultOrganizationId, "", signer, WithJournal(journal))
+	lastSig := signatureDevice.LastSig
+
+	journal.failing = true
+	if _, _, err := signatureDevice.SignData([]byte("lost")); err == nil {
+		t.Fatal("Signing must fail if the transaction cannot be recorded.")
+	}
+	if signatureDevice.SignatureCounter != 0 || !bytes.Equal(signatureDevice.LastSig, lastSig) {
+		t.Error("An unrecorded signature must not advance the device.")
+	}
+
+	journal.failing = false
+	signatureDevice.SignData([]byte("first"))
+	signatureDevice.SignData([]byte("second"))
+	for i, transaction := range journal.transactions {
+		if transaction.Counter != uint64(i) {
+			t.Error("Journal must not contain gaps, got counter", transaction.Counter, "at", i)
+		}
+	}
+	if !bytes.Equal(signatureDevice.LastSig, journal.transactions[1].Signature) {
+		t.Error("Last signature must be the last recorded signature.")
+	}
+}
+
+// sharedJournal is the committed state of a device shared by several instances of it, like a repository
+// shared by service replicas.
+type sharedJournal struct {
+	mu           sync.Mutex
+	state        DeviceState
+	transactions []Transaction
+}
+
+func (j *sharedJournal) CommitTransactions(transactions []Transaction, version uint64) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state.Version != version {
+		return 0, ErrVersionConflict
+	}
+	j.transactions = append(j.transactions, transactions...)
+	last := transactions[len(transactions)-1]
+	j.state = DeviceState{last.Counter + 1, last.Signature, version + uint64(len(transactions))}
+	return j.state.Version, nil
+}
+
+func (j *sharedJournal) DeviceState(deviceId uuid.UUID) (DeviceState, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state, nil
+}
+
+func TestDevice_RetriesOnVersionConflict(t *testing.T) {
+	signer, _ := crypto2.SignerFactory("ECC")
+	original := NewSignatureDevice(DefaultOrganizationId, "", signer)
+	journal := &sharedJournal{state: original.State()}
+	replicaA := RestoreSignatureDevice(original.Id, DefaultOrganizationId, "", signer, journal.state, WithJournal(journal))
+	replicaB := RestoreSignatureDevice(original.Id, DefaultOrganizationId, "", signer, journal.state, WithJournal(journal))
+
+	var wg sync.WaitGroup
+	for _, replica := range []*SignatureDevice{replicaA, replicaB} {
+		wg.Add(1)
+		go func(replica *SignatureDevice) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if _, _, err := replica.SignData([]byte("data")); err != nil && !errors.Is(err, ErrVersionConflict) {
+					t.Error(err)
+				}
+			}
+		}(replica)
+	}
+	wg.Wait()
+
+	lastSig := original.LastSig
+	for i, transaction := range journal.transactions {
+		if transaction.Counter != uint64(i) {
+			t.Fatal("Counter issued twice or skipped at", i, "got", transaction.Counter)
+		}
+		if chained, _ := transaction.ChainedSignature(); !bytes.Equal(chained, lastSig) {
+			t.Fatal("Transaction", i, "is not chained to its predecessor.")
+		}
+		lastSig = transaction.Signature
+	}
+	if len(journal.transactions) < 10 {
+		t.Error("Conflicts must be resolved by retrying, only", len(journal.transactions), "signatures committed.")
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	signer, _ := crypto2.SignerFactory("ECC")
+	journal := &failingJournal{}
+	signatureDevice := NewSignatureDevice(DefaultOrganizationId, "", signer, WithJournal(journal))
+	signatureDevice.SignBatch([][]byte{[]byte("a"), []byte("b"), []byte("c")}, 0)
+
+	if err := VerifyChain(signatureDevice.Id, signatureDevice, journal.transactions); err != nil {
+		t.Fatal("Chain must verify:", err)
+	}
+	if err := VerifyChain(signatureDevice.Id, signatureDevice, append(journal.transactions[:1:1], journal.transactions[2])); !errors.Is(err, ErrBrokenChain) {
+		t.Error("Missing transaction must break the chain, got", err)
+	}
+	altered := append([]Transaction(nil), journal.transactions...)
+	altered[1].Signature = altered[2].Signature
+	if err := VerifyChain(signatureDevice.Id, signatureDevice, altered); !errors.Is(err, ErrBrokenChain) {
+		t.Error("Altered signature must break the chain, got", err)
+	}
+}
+
+func TestDevice_RotateKey(t *testing.T) {
+	signer, _ := crypto2.SignerFactory("ECC")
+	journal := &failingJournal{}
+	signatureDevice := NewSignatureDevice(DefaultOrganizationId, "", signer, WithJournal(journal))
+	signatureDevice.SignData([]byte("before rotation"))
+
+	rotated, _ := crypto2.SignerFactory("ECC")
+	if version, err := signatureDevice.RotateKey(rotated); err != nil || version != 2 || signatureDevice.KeyVersion() != 2 {
+		t.Fatal("Rotation must advance the key version, got", version, err)
+	}
+	signatureDevice.SignData([]byte("after rotation"))
+
+	if journal.transactions[0].KeyVersion != 1 || journal.transactions[1].KeyVersion != 2 {
+		t.Error("Transactions must record the key version they were signed with, got",
+			journal.transactions[0].KeyVersion, journal.transactions[1].KeyVersion)
+	}
+	if !rotated.VerifySignature(journal.transactions[1].SignedData, journal.transactions[1].Signature) {
+		t.Error("Device must sign with the new key after rotation.")
+	}
+	if err := VerifyChain(signatureDevice.Id, signatureDevice, journal.transactions); err != nil {
+		t.Error("Chain across a rotation must verify with the retired key:", err)
+	}
+	if publicKey, found := signatureDevice.PublicKey(1); !found || publicKey != signer.GetPublicKey() {
+		t.Error("Retired key must stay available.")
+	}
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestDevice_SignsClockTime(t *testing.T) {
+	signer, _ := crypto2.SignerFactory("ECC")
+	now := time.Date(2026, 10, 19, 10, 30, 0, 0, time.FixedZone("CEST", 7200))
+	signatureDevice := NewSignatureDevice(DefaultOrganizationId, "", signer, WithClock(fixedClock(now)))
+
+	data, _, _ := signatureDevice.SignData([]byte("receipt"))
+	securedData, err := ParseSecuredData(data)
+	if err != nil || securedData.Format != DataFormatV3 || !securedData.Time.Equal(now) || securedData.Time.Location() != time.UTC {
+		t.Error("Secured data must contain the time of the device clock in UTC, got", securedData.Time, err)
+	}
+}
@@ -0,0 +1,89 @@
+package domain
+
+import (
+	gocrypto "crypto"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/google/uuid"
+)
+
+// PublicKeys looks up the public key of a key version of a device.
+type PublicKeys interface {
+	// PublicKey returns the public key of version, or false if the device never had the version. Version 0 is
+	// version 1, the key of transactions recorded before keys could be rotated.
+	PublicKey(version int) (gocrypto.PublicKey, bool)
+}
+
+// KeyRecorder is implemented by journals that store the keys of devices. RotateKey records a new key before the
+// device signs with it, so that no transaction is signed by a key that could be lost.
+type KeyRecorder interface {
+	RecordKey(deviceId uuid.UUID, signer crypto.Signer, retiredKeys []gocrypto.PublicKey) error
+}
+
+// WithRetiredKeys restores the public keys of the previous key versions of a device, oldest first.
+func WithRetiredKeys(retiredKeys []gocrypto.PublicKey) DeviceOption {
+	return func(device *SignatureDevice) {
+		device.retiredKeys = retiredKeys
+	}
+}
+
+// Key returns the current signer of the device and its key version, consistent with each other.
+func (device *SignatureDevice) Key() (crypto.Signer, int) {
+	device.sigMutex.RLock()
+	defer device.sigMutex.RUnlock()
+	return device.Signer, len(device.retiredKeys) + 1
+}
+
+// KeyVersion returns the version of the current key of the device. It starts at 1 and increases with every rotation.
+func (device *SignatureDevice) KeyVersion() int {
+	_, version := device.Key()
+	return version
+}
+
+// Algorithm returns the algorithm of the current key of the device.
+func (device *SignatureDevice) Algorithm() string {
+	signer, _ := device.Key()
+	return signer.GetAlgorithm()
+}
+
+// RetiredKeys returns the public keys of the previous key versions of the device, oldest first.
+func (device *SignatureDevice) RetiredKeys() []gocrypto.PublicKey {
+	device.sigMutex.RLock()
+	defer device.sigMutex.RUnlock()
+	return device.retiredKeys[:len(device.retiredKeys):len(device.retiredKeys)]
+}
+
+// PublicKey returns the public key of a key version of the device, current or retired. It implements PublicKeys.
+func (device *SignatureDevice) PublicKey(version int) (gocrypto.PublicKey, bool) {
+	device.sigMutex.RLock()
+	defer device.sigMutex.RUnlock()
+	if version == 0 {
+		version = 1
+	}
+	switch {
+	case version < 1 || version > len(device.retiredKeys)+1:
+		return nil, false
+	case version == len(device.retiredKeys)+1:
+		return device.Signer.GetPublicKey(), true
+	default:
+		return device.retiredKeys[version-1], true
+	}
+}
+
+// RotateKey replaces the key of the device by signer and returns the new key version. The previous key is retired:
+// it signs no more transactions but remains available to verify those it signed. Signatures in progress complete
+// with the previous key first. A journal that is a KeyRecorder records the new key before it is used.
+func (device *SignatureDevice) RotateKey(signer crypto.Signer) (int, error) {
+	device.sigMutex.Lock()
+	defer device.sigMutex.Unlock()
+	retiredKeys := append(device.retiredKeys[:len(device.retiredKeys):len(device.retiredKeys)], device.Signer.GetPublicKey())
+	if recorder, ok := device.journal.(KeyRecorder); ok {
+		if err := recorder.RecordKey(device.Id, signer, retiredKeys); err != nil {
+			return 0, err
+		}
+	}
+	device.Signer = signer
+	device.retiredKeys = retiredKeys
+	device.logger.Info("Device key rotated", "device", device, "key_version", len(retiredKeys)+1)
+	return len(retiredKeys) + 1, nil
+}
@@ -0,0 +1,34 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Lease makes one service replica the owner of a device until ExpiresAt. Token is a fencing token:
+// it increases whenever the lease passes to a new owner, and commits must carry the token of the current
+// lease, so that a replica that lost its lease (e.g. while paused) cannot sign with the device anymore.
+type Lease struct {
+	DeviceId  uuid.UUID
+	Owner     string
+	Address   string
+	Token     uint64
+	ExpiresAt time.Time
+}
+
+// ErrLeaseHeld is returned by LeaseStore.AcquireLease if another replica holds an unexpired lease.
+var ErrLeaseHeld = errors.New("device is leased by another replica")
+
+// ErrFenced is returned by Journal.CommitTransactions if the transaction carries the token of a lease that was taken over.
+var ErrFenced = errors.New("lease of the device was taken over")
+
+// LeaseStore grants leases on devices to the replicas sharing a repository.
+type LeaseStore interface {
+	// AcquireLease renews the lease of owner or takes over an expired lease. If another replica holds
+	// the lease, its lease is returned together with ErrLeaseHeld. Address is where owner serves requests.
+	AcquireLease(deviceId uuid.UUID, owner string, address string, ttl time.Duration) (Lease, error)
+	// ReleaseLeases gives up all leases of owner, so that other replicas can take them over immediately.
+	ReleaseLeases(owner string) error
+}
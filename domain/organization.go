@@ -0,0 +1,21 @@
+package domain
+
+import "github.com/google/uuid"
+
+// DefaultOrganizationId identifies the organization used when requests are not authenticated.
+// API keys of this organization with admin permission may manage all other organizations.
+var DefaultOrganizationId = uuid.Nil
+
+// Organization is a tenant owning an isolated set of signature devices.
+type Organization struct {
+	Id   uuid.UUID
+	Name string
+}
+
+// NewOrganization is factory that initializes an organization.
+func NewOrganization(name string) *Organization {
+	return &Organization{
+		Id:   uuid.New(),
+		Name: name,
+	}
+}
@@ -0,0 +1,150 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DataFormat is the version of the secured data format a device signs, i.e. how counter, data and
+// last signature are combined into the input of a signature.
+type DataFormat string
+
+const (
+	// DataFormatV1 joins counter, data and base64 encoded last signature with underscores:
+	// <counter>_<data>_<last signature>.
+	DataFormatV1 DataFormat = "v1"
+	// DataFormatV2 prefixes the data with its length in bytes, so that it may contain any bytes:
+	// v2:<counter>:<length of data>:<data>:<last signature>.
+	DataFormatV2 DataFormat = "v2"
+	// DataFormatV3 extends v2 with the time of the signature in the TimestampLayout:
+	// v3:<counter>:<timestamp>:<length of data>:<data>:<last signature>.
+	DataFormatV3 DataFormat = "v3"
+)
+
+// DefaultDataFormat is the format of devices that did not select one.
+const DefaultDataFormat = DataFormatV3
+
+// TimestampLayout is the RFC 3339 layout of timestamps in secured data. Timestamps are in UTC with milliseconds,
+// so that they always have the same length.
+const TimestampLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// timestampLength is the length of timestamps in the TimestampLayout.
+const timestampLength = len("2006-01-02T15:04:05.000Z")
+
+// ErrUnknownDataFormat is returned for data formats other than the defined versions.
+var ErrUnknownDataFormat = errors.New("unknown data format")
+
+// ErrMalformedSecuredData is returned by ParseSecuredData if the data was not created by any data format.
+var ErrMalformedSecuredData = errors.New("malformed secured data")
+
+// ParseDataFormat returns the data format with the given name. An empty name selects the DefaultDataFormat.
+func ParseDataFormat(name string) (DataFormat, error) {
+	switch format := DataFormat(name); format {
+	case "":
+		return DefaultDataFormat, nil
+	case DataFormatV1, DataFormatV2, DataFormatV3:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%w: %q, expected v1, v2 or v3", ErrUnknownDataFormat, name)
+	}
+}
+
+// WithDataFormat makes the device sign data in the given format instead of the DefaultDataFormat.
+func WithDataFormat(format DataFormat) DeviceOption {
+	return func(device *SignatureDevice) {
+		if format != "" {
+			device.DataFormat = format
+		}
+	}
+}
+
+// SecuredData is the input of a signature split into its parts.
+type SecuredData struct {
+	Format  DataFormat
+	Counter uint64
+	// Time is when the data was signed. Only DataFormatV3 contains it; it is zero for other formats.
+	Time    time.Time
+	Data    []byte
+	LastSig []byte
+}
+
+// Bytes formats the secured data again, as it was signed.
+func (s SecuredData) Bytes() []byte {
+	switch s.Format {
+	case DataFormatV2, DataFormatV3:
+		buffer := bytes.NewBufferString(string(s.Format) + ":" + strconv.FormatUint(s.Counter, 10) + ":")
+		if s.Format == DataFormatV3 {
+			buffer.WriteString(s.Time.UTC().Format(TimestampLayout) + ":")
+		}
+		buffer.WriteString(strconv.Itoa(len(s.Data)) + ":")
+		buffer.Write(s.Data)
+		buffer.WriteString(":" + base64.StdEncoding.EncodeToString(s.LastSig))
+		return buffer.Bytes()
+	default:
+		return prepareData(s.Counter, s.Data, s.LastSig)
+	}
+}
+
+// ParseSecuredData splits signed data into counter, data and last signature. The format is recognized from the data.
+func ParseSecuredData(securedData []byte) (SecuredData, error) {
+	if rest, ok := bytes.CutPrefix(securedData, []byte(DataFormatV2+":")); ok {
+		return parseV2(DataFormatV2, rest)
+	}
+	if rest, ok := bytes.CutPrefix(securedData, []byte(DataFormatV3+":")); ok {
+		return parseV2(DataFormatV3, rest)
+	}
+	return parseV1(securedData)
+}
+
+// parseV1 relies on neither the decimal counter nor the base64 last signature containing underscores,
+// so that the data is everything between the first and the last one.
+func parseV1(securedData []byte) (SecuredData, error) {
+	first := bytes.IndexByte(securedData, '_')
+	last := bytes.LastIndexByte(securedData, '_')
+	if first < 0 || first == last {
+		return SecuredData{}, fmt.Errorf("%w: expected <counter>_<data>_<last signature>", ErrMalformedSecuredData)
+	}
+	counter, err := strconv.ParseUint(string(securedData[:first]), 10, 64)
+	if err != nil {
+		return SecuredData{}, fmt.Errorf("%w: invalid counter", ErrMalformedSecuredData)
+	}
+	lastSig, err := base64.StdEncoding.DecodeString(string(securedData[last+1:]))
+	if err != nil {
+		return SecuredData{}, fmt.Errorf("%w: invalid last signature", ErrMalformedSecuredData)
+	}
+	return SecuredData{Format: DataFormatV1, Counter: counter, Data: securedData[first+1 : last], LastSig: lastSig}, nil
+}
+
+// parseV2 parses the secured data of format v2 or v3 after the version prefix.
+func parseV2(format DataFormat, rest []byte) (SecuredData, error) {
+	counterField, rest, _ := bytes.Cut(rest, []byte(":"))
+	counter, err := strconv.ParseUint(string(counterField), 10, 64)
+	if err != nil {
+		return SecuredData{}, fmt.Errorf("%w: invalid counter", ErrMalformedSecuredData)
+	}
+	var timestamp time.Time
+	if format == DataFormatV3 {
+		// The timestamp contains colons itself, but has a fixed length.
+		if len(rest) <= timestampLength || rest[timestampLength] != ':' {
+			return SecuredData{}, fmt.Errorf("%w: invalid timestamp", ErrMalformedSecuredData)
+		}
+		if timestamp, err = time.Parse(TimestampLayout, string(rest[:timestampLength])); err != nil {
+			return SecuredData{}, fmt.Errorf("%w: invalid timestamp", ErrMalformedSecuredData)
+		}
+		rest = rest[timestampLength+1:]
+	}
+	lengthField, rest, _ := bytes.Cut(rest, []byte(":"))
+	length, err := strconv.ParseUint(string(lengthField), 10, 31)
+	if err != nil || uint64(len(rest)) < length+1 || rest[length] != ':' {
+		return SecuredData{}, fmt.Errorf("%w: invalid data length", ErrMalformedSecuredData)
+	}
+	lastSig, err := base64.StdEncoding.DecodeString(string(rest[length+1:]))
+	if err != nil {
+		return SecuredData{}, fmt.Errorf("%w: invalid last signature", ErrMalformedSecuredData)
+	}
+	return SecuredData{Format: format, Counter: counter, Time: timestamp, Data: rest[:length], LastSig: lastSig}, nil
+}
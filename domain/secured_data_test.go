@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	crypto2 "github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+func TestSecuredData_RoundTrip(t *testing.T) {
+	lastSig := []byte("previous signature")
+	signedAt := time.Date(2026, 10, 19, 8, 30, 0, 123e6, time.UTC)
+	for _, format := range []DataFormat{DataFormatV1, DataFormatV2, DataFormatV3} {
+		for _, data := range [][]byte{[]byte("Hello World!"), []byte("with_underscores_and:colons"), {0x00, '_', 0xff, ':'}, {}} {
+			original := SecuredData{Format: format, Counter: 42, Data: data, LastSig: lastSig}
+			if format == DataFormatV3 {
+				original.Time = signedAt
+			}
+			securedData := original.Bytes()
+			parsed, err := ParseSecuredData(securedData)
+			if err != nil {
+				t.Fatal(format, err)
+			}
+			if parsed.Format != format || parsed.Counter != 42 || !parsed.Time.Equal(original.Time) ||
+				!bytes.Equal(parsed.Data, data) || !bytes.Equal(parsed.LastSig, lastSig) {
+				t.Errorf("%s: parsed %+v from %q", format, parsed, securedData)
+			}
+		}
+	}
+}
+
+func TestSecuredData_V1MatchesPrepareData(t *testing.T) {
+	v1 := SecuredData{Format: DataFormatV1, Counter: 123, Data: []byte("Hello World!"), LastSig: []byte("42")}
+	if !bytes.Equal(v1.Bytes(), prepareData(123, []byte("Hello World!"), []byte("42"))) {
+		t.Error("Format v1 must stay compatible with existing signatures.")
+	}
+	v2 := SecuredData{Format: DataFormatV2, Counter: 7, Data: []byte("a_b"), LastSig: []byte("42")}
+	if got := v2.Bytes(); string(got) != "v2:7:3:a_b:NDI=" {
+		t.Error("Unexpected v2 format:", string(got))
+	}
+	v3 := SecuredData{Format: DataFormatV3, Counter: 7, Time: time.Date(2026, 10, 19, 10, 30, 0, 0, time.FixedZone("CEST", 7200)), Data: []byte("a_b"), LastSig: []byte("42")}
+	if got := v3.Bytes(); string(got) != "v3:7:2026-10-19T08:30:00.000Z:3:a_b:NDI=" {
+		t.Error("Unexpected v3 format:", string(got))
+	}
+}
+
+func TestParseSecuredData_RejectsMalformedData(t *testing.T) {
+	for _, securedData := range []string{"", "no separators", "x_data_NDI=", "1_data_not base64", "v2:1:10:short:NDI=", "v2:1:3:abcNDI=", "v2:x:1:a:NDI=", "v3:1:2026-10-19T08:30:00Z:1:a:NDI=", "v3:1:1:a:NDI="} {
+		if _, err := ParseSecuredData([]byte(securedData)); !errors.Is(err, ErrMalformedSecuredData) {
+			t.Errorf("%q must be rejected, got %v", securedData, err)
+		}
+	}
+}
+
+func TestDevice_SignsSelectedDataFormat(t *testing.T) {
+	signer, _ := crypto2.SignerFactory("ECC")
+	signatureDevice := NewSignatureDevice(DefaultOrganizationId, "", signer, WithDataFormat(DataFormatV2))
+	lastSig := signatureDevice.LastSig
+	data, _, _ := signatureDevice.SignData([]byte("1_2_3"))
+
+	parsed, err := ParseSecuredData(data)
+	if err != nil || parsed.Format != DataFormatV2 || parsed.Counter != 0 || string(parsed.Data) != "1_2_3" || !bytes.Equal(parsed.LastSig, lastSig) {
+		t.Error("Device must sign in its data format, got", parsed, err)
+	}
+}
@@ -0,0 +1,140 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/merkle"
+
+	"github.com/google/uuid"
+)
+
+// Transaction is one signature created by a signature device, as recorded in its journal.
+type Transaction struct {
+	OrganizationId uuid.UUID
+	DeviceId       uuid.UUID
+	Counter        uint64
+	SignedData     []byte
+	Signature      []byte
+	// FencingToken is the token of the lease the transaction was created under, or 0 without leases.
+	FencingToken uint64
+	// SignatureVersion is the signature encoding the transaction was created with.
+	SignatureVersion SignatureVersion
+	// KeyVersion is the version of the device key that signed the transaction, see SignatureDevice.RotateKey.
+	// Transactions recorded before keys could be rotated have 0 and were signed by version 1.
+	KeyVersion int
+	// Timestamp is the time-stamp token of a third-party authority covering the transaction, if there is one.
+	Timestamp *Timestamp
+}
+
+// Timestamp is an RFC 3161 time-stamp token over consecutive transactions of a device. A single transaction is
+// timestamped by its signature, a batch by the Merkle root of its signatures, see TimestampMessage.
+type Timestamp struct {
+	// Token is the DER encoded time-stamp token.
+	Token []byte
+	// FirstCounter and Count are the transactions covered by the token.
+	FirstCounter uint64
+	Count        int
+}
+
+// TimestampMessage returns the message timestamped for consecutive transactions: the signature of a single
+// transaction, or the Merkle root over the signatures of several, in counter order.
+func TimestampMessage(transactions []Transaction) []byte {
+	if len(transactions) == 1 {
+		return transactions[0].Signature
+	}
+	signatures := make([][]byte, len(transactions))
+	for i, transaction := range transactions {
+		signatures[i] = transaction.Signature
+	}
+	return merkle.Root(signatures)
+}
+
+// SignatureVersion is the version of the signature encoding of a transaction.
+type SignatureVersion int
+
+const (
+	// SignatureVersion1 transactions were created while signers returned base64 text instead of raw signatures.
+	// Their signature is stored decoded like all others, but their secured data embeds the base64 text of the
+	// previous signature, which is then base64 encoded again by the data format.
+	SignatureVersion1 SignatureVersion = 1
+	// SignatureVersion2 transactions embed the raw previous signature, base64 encoded once by the data format.
+	SignatureVersion2 SignatureVersion = 2
+	// CurrentSignatureVersion is the version of newly signed transactions.
+	CurrentSignatureVersion = SignatureVersion2
+)
+
+// ErrBrokenChain is returned by VerifyChain if transactions are missing, altered or not chained.
+var ErrBrokenChain = errors.New("transaction chain broken")
+
+// ChainedSignature returns the raw signature the transaction is chained to, taken from its secured data.
+func (transaction Transaction) ChainedSignature() ([]byte, error) {
+	securedData, err := ParseSecuredData(transaction.SignedData)
+	if err != nil {
+		return nil, err
+	}
+	if transaction.SignatureVersion == SignatureVersion1 {
+		return base64.StdEncoding.DecodeString(string(securedData.LastSig))
+	}
+	return securedData.LastSig, nil
+}
+
+// VerifyChain checks that the transactions of a device start at counter 0, are signed by the key of their key
+// version and are each chained to the signature before, the first one to the id of the device. Transactions of all
+// signature versions can be verified, including chains that changed the version or were signed by rotated keys.
+func VerifyChain(deviceId uuid.UUID, keys PublicKeys, transactions []Transaction) error {
+	return VerifyContinuation(DeviceState{LastSig: deviceId[:]}, keys, transactions)
+}
+
+// VerifyContinuation checks that the transactions continue a device at state like VerifyChain: they start at the
+// counter of the state, the first one is chained to its last signature, and each later one to the signature before.
+func VerifyContinuation(state DeviceState, keys PublicKeys, transactions []Transaction) error {
+	lastSig := state.LastSig
+	for i, transaction := range transactions {
+		counter := state.Counter + uint64(i)
+		if transaction.Counter != counter {
+			return fmt.Errorf("%w: expected counter %d, found %d", ErrBrokenChain, counter, transaction.Counter)
+		}
+		chained, err := transaction.ChainedSignature()
+		if err != nil || !bytes.Equal(chained, lastSig) {
+			return fmt.Errorf("%w: transaction %d is not chained to its predecessor", ErrBrokenChain, counter)
+		}
+		publicKey, found := keys.PublicKey(transaction.KeyVersion)
+		if !found || !crypto.VerifySignature(publicKey, transaction.SignedData, transaction.Signature) {
+			return fmt.Errorf("%w: signature of transaction %d does not verify", ErrBrokenChain, counter)
+		}
+		lastSig = transaction.Signature
+	}
+	return nil
+}
+
+// DeviceState is the committed state of a device in the repository. Version increases with every change,
+// so that concurrent changes, e.g. by service replicas sharing the repository, can be detected.
+type DeviceState struct {
+	Counter uint64
+	LastSig []byte
+	Version uint64
+}
+
+// ErrVersionConflict is returned by Journal.CommitTransactions if the device state was changed concurrently.
+var ErrVersionConflict = errors.New("device state changed concurrently")
+
+// ErrMigrated is returned by Journal.CommitTransactions for devices that were migrated to another instance with a
+// backup, so that counters exported in the bundle are not signed twice.
+var ErrMigrated = errors.New("device migrated to another instance")
+
+// ErrSuspended is returned by Journal.CommitTransactions for devices an admin suspended, until they are resumed.
+var ErrSuspended = errors.New("device suspended")
+
+// Journal records the transactions of signature devices and holds their committed state.
+type Journal interface {
+	// CommitTransactions appends consecutive transactions of one device to the journal and advances its state
+	// past the last of them, provided the state still has the given version (compare-and-swap). Either all
+	// transactions are recorded or none. It returns the new version, which increased by one per transaction.
+	CommitTransactions(transactions []Transaction, version uint64) (uint64, error)
+	// DeviceState returns the committed state of a device.
+	DeviceState(deviceId uuid.UUID) (DeviceState, error)
+}
@@ -0,0 +1,253 @@
+package export
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// Format is the container format of an export archive.
+type Format string
+
+// Supported archive formats.
+const (
+	FormatTar Format = "tar"
+	FormatZip Format = "zip"
+)
+
+// Names of the files in an export archive.
+const (
+	PublicKeyFile         = "public_key.pem"
+	RetiredKeysFile       = "retired_keys.pem" // only if the device key was rotated
+	DeviceFile            = "device.json"
+	TransactionsCSVFile   = "transactions.csv"
+	TransactionsJSONLFile = "transactions.jsonl"
+	ManifestFile          = "manifest.json"
+	ManifestSignatureFile = "manifest.sig"
+)
+
+// ParseFormat parses the name of an archive format. An empty name selects tar.
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "", FormatTar:
+		return FormatTar, nil
+	case FormatZip:
+		return FormatZip, nil
+	default:
+		return "", fmt.Errorf("unsupported export format %q", name)
+	}
+}
+
+// ContentType returns the media type of archives in the format.
+func (f Format) ContentType() string {
+	if f == FormatZip {
+		return "application/zip"
+	}
+	return "application/x-tar"
+}
+
+// Device is the metadata of the exported signature device.
+type Device struct {
+	Id               uuid.UUID `json:"id"`
+	OrganizationId   uuid.UUID `json:"organization_id"`
+	Label            string    `json:"label"`
+	Algorithm        string    `json:"algorithm"`
+	DataFormat       string    `json:"data_format"`
+	KeyVersion       int       `json:"key_version"`
+	SignatureCounter uint64    `json:"signature_counter"`
+	LastSignature    string    `json:"last_signature"`
+	ExportedAt       time.Time `json:"exported_at"`
+}
+
+// Transaction is one journal entry as written to transactions.jsonl.
+// SignedData is the exact input of the signature, which may be binary; it is base64 encoded like Signature.
+// SignatureVersion tells how the previous signature is embedded in SignedData, see domain.SignatureVersion.
+// KeyVersion is the version of the device key that made Signature: the key in public_key.pem if it is the key
+// version of the device, otherwise the retired key at that position in retired_keys.pem.
+// Timestamp is only written to transactions.jsonl.
+type Transaction struct {
+	Counter          uint64     `json:"counter"`
+	SignedData       []byte     `json:"signed_data"`
+	Signature        string     `json:"signature"`
+	SignatureVersion int        `json:"signature_version"`
+	KeyVersion       int        `json:"key_version"`
+	Timestamp        *Timestamp `json:"timestamp,omitempty"`
+}
+
+// Timestamp is the base64 encoded RFC 3161 token of a transaction. It covers transactions FirstCounter to
+// FirstCounter+Count-1, see domain.TimestampMessage.
+type Timestamp struct {
+	Token        string `json:"token"`
+	FirstCounter uint64 `json:"first_counter"`
+	Count        int    `json:"count"`
+}
+
+// File describes one file of the archive in the manifest.
+type File struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest lists all other files of the archive with their hashes.
+// It is signed by the exported device, so the archive can be verified with the public key it contains.
+type Manifest struct {
+	DeviceId         uuid.UUID `json:"device_id"`
+	Algorithm        string    `json:"algorithm"`
+	SignatureCounter uint64    `json:"signature_counter"`
+	ExportedAt       time.Time `json:"exported_at"`
+	Files            []File    `json:"files"`
+}
+
+type archiveFile struct {
+	name    string
+	content []byte
+}
+
+// Write writes an archive of the device in state and its transactions to w.
+// Only transactions below the signature counter of state are included. The state must be read before the
+// transactions, so that they include every transaction it describes even if the device signs concurrently.
+// Signing the manifest does not count as a transaction of the device.
+func Write(w io.Writer, format Format, device *domain.SignatureDevice, state domain.DeviceState, transactions []domain.Transaction, exportedAt time.Time) error {
+	counter := state.Counter
+	exportedAt = exportedAt.UTC()
+
+	signer, keyVersion := device.Key()
+	publicKey, err := x509.MarshalPKIXPublicKey(signer.GetPublicKey())
+	if err != nil {
+		return fmt.Errorf("encoding public key: %w", err)
+	}
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})
+	var retiredKeysPEM []byte
+	for _, retiredKey := range device.RetiredKeys()[:keyVersion-1] {
+		der, err := x509.MarshalPKIXPublicKey(retiredKey)
+		if err != nil {
+			return fmt.Errorf("encoding retired key: %w", err)
+		}
+		retiredKeysPEM = append(retiredKeysPEM, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+
+	deviceJSON, err := json.MarshalIndent(Device{
+		Id:               device.Id,
+		OrganizationId:   device.OrganizationId,
+		Label:            device.Label,
+		Algorithm:        signer.GetAlgorithm(),
+		DataFormat:       string(device.DataFormat),
+		KeyVersion:       keyVersion,
+		SignatureCounter: counter,
+		LastSignature:    base64.StdEncoding.EncodeToString(state.LastSig),
+		ExportedAt:       exportedAt,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var transactionsCSV, transactionsJSONL bytes.Buffer
+	csvWriter := csv.NewWriter(&transactionsCSV)
+	csvWriter.Write([]string{"counter", "signed_data", "signature", "signature_version", "key_version"})
+	jsonEncoder := json.NewEncoder(&transactionsJSONL)
+	for _, transaction := range transactions {
+		if transaction.Counter >= counter {
+			continue
+		}
+		entry := Transaction{
+			Counter:          transaction.Counter,
+			SignedData:       transaction.SignedData,
+			Signature:        base64.StdEncoding.EncodeToString(transaction.Signature),
+			SignatureVersion: int(transaction.SignatureVersion),
+			KeyVersion:       transaction.KeyVersion,
+		}
+		if entry.KeyVersion == 0 {
+			entry.KeyVersion = 1
+		}
+		if timestamp := transaction.Timestamp; timestamp != nil {
+			entry.Timestamp = &Timestamp{base64.StdEncoding.EncodeToString(timestamp.Token), timestamp.FirstCounter, timestamp.Count}
+		}
+		csvWriter.Write([]string{
+			strconv.FormatUint(entry.Counter, 10),
+			base64.StdEncoding.EncodeToString(entry.SignedData),
+			entry.Signature,
+			strconv.Itoa(entry.SignatureVersion),
+			strconv.Itoa(entry.KeyVersion),
+		})
+		if err := jsonEncoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return err
+	}
+
+	files := []archiveFile{
+		{PublicKeyFile, publicKeyPEM},
+		{DeviceFile, deviceJSON},
+		{TransactionsCSVFile, transactionsCSV.Bytes()},
+		{TransactionsJSONLFile, transactionsJSONL.Bytes()},
+	}
+	if len(retiredKeysPEM) > 0 {
+		files = append(files, archiveFile{RetiredKeysFile, retiredKeysPEM})
+	}
+
+	manifest := Manifest{
+		DeviceId:         device.Id,
+		Algorithm:        signer.GetAlgorithm(),
+		SignatureCounter: counter,
+		ExportedAt:       exportedAt,
+	}
+	for _, file := range files {
+		hash := sha256.Sum256(file.content)
+		manifest.Files = append(manifest.Files, File{file.name, len(file.content), hex.EncodeToString(hash[:])})
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifestSignature, err := signer.Sign(manifestJSON)
+	if err != nil {
+		return fmt.Errorf("signing manifest: %w", err)
+	}
+	encodedSignature := []byte(base64.StdEncoding.EncodeToString(manifestSignature))
+	files = append(files, archiveFile{ManifestFile, manifestJSON}, archiveFile{ManifestSignatureFile, encodedSignature})
+
+	switch format {
+	case FormatZip:
+		archive := zip.NewWriter(w)
+		for _, file := range files {
+			fileWriter, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: exportedAt})
+			if err != nil {
+				return err
+			}
+			if _, err := fileWriter.Write(file.content); err != nil {
+				return err
+			}
+		}
+		return archive.Close()
+	default:
+		archive := tar.NewWriter(w)
+		for _, file := range files {
+			header := &tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.content)), ModTime: exportedAt}
+			if err := archive.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := archive.Write(file.content); err != nil {
+				return err
+			}
+		}
+		return archive.Close()
+	}
+}
@@ -0,0 +1,224 @@
+package export
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+type journal []domain.Transaction
+
+func (j *journal) CommitTransactions(transactions []domain.Transaction, version uint64) (uint64, error) {
+	*j = append(*j, transactions...)
+	return version + uint64(len(transactions)), nil
+}
+
+func (j *journal) DeviceState(deviceId uuid.UUID) (domain.DeviceState, error) {
+	return domain.DeviceState{}, errors.New("not supported")
+}
+
+func readArchive(t *testing.T, format Format, archive []byte) map[string][]byte {
+	files := make(map[string][]byte)
+	if format == FormatZip {
+		reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range reader.File {
+			content, _ := file.Open()
+			files[file.Name], _ = io.ReadAll(content)
+		}
+		return files
+	}
+
+	reader := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name], _ = io.ReadAll(reader)
+	}
+}
+
+func TestWrite_SignedManifest(t *testing.T) {
+	for _, format := range []Format{FormatTar, FormatZip} {
+		signer, _ := crypto.SignerFactory("ECC")
+		var transactions journal
+		device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "export", signer, domain.WithJournal(&transactions))
+		device.SignData([]byte("first"))
+		device.SignData([]byte("second,\nwith separators"))
+		transactions[1].Timestamp = &domain.Timestamp{Token: []byte("token"), FirstCounter: 1, Count: 1}
+
+		var archive bytes.Buffer
+		if err := Write(&archive, format, device, device.State(), transactions, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		files := readArchive(t, format, archive.Bytes())
+
+		var manifest Manifest
+		if err := json.Unmarshal(files[ManifestFile], &manifest); err != nil {
+			t.Fatal(err)
+		}
+		manifestSignature, _ := base64.StdEncoding.DecodeString(string(files[ManifestSignatureFile]))
+		if !signer.VerifySignature(files[ManifestFile], manifestSignature) {
+			t.Error("Manifest signature must verify with the device key.")
+		}
+		if manifest.DeviceId != device.Id || manifest.SignatureCounter != 2 || len(manifest.Files) != 4 {
+			t.Error("Manifest incomplete:", manifest)
+		}
+		for _, file := range manifest.Files {
+			hash := sha256.Sum256(files[file.Name])
+			if hex.EncodeToString(hash[:]) != file.SHA256 || len(files[file.Name]) != file.Size {
+				t.Error("Manifest does not match file", file.Name)
+			}
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(files[TransactionsJSONLFile])), "\n")
+		if len(lines) != 2 {
+			t.Fatal("Expected 2 transactions, got", len(lines))
+		}
+		var second Transaction
+		json.Unmarshal([]byte(lines[1]), &second)
+		signature, _ := base64.StdEncoding.DecodeString(second.Signature)
+		if second.Counter != 1 || second.SignatureVersion != 2 || !signer.VerifySignature(second.SignedData, signature) {
+			t.Error("Exported transaction must verify:", second)
+		}
+		if second.Timestamp == nil || second.Timestamp.Token != base64.StdEncoding.EncodeToString([]byte("token")) {
+			t.Error("Exported transaction must contain its time-stamp token:", second.Timestamp)
+		}
+		if !bytes.HasPrefix(files[PublicKeyFile], []byte("-----BEGIN PUBLIC KEY-----")) {
+			t.Error("Public key must be PEM encoded.")
+		}
+		if device.SignatureCounter != 2 {
+			t.Error("Signing the manifest must not count as a transaction.")
+		}
+	}
+}
+
+func TestWrite_ExcludesTransactionsAfterCounter(t *testing.T) {
+	signer, _ := crypto.SignerFactory("RSA")
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "export", signer)
+	transactions := []domain.Transaction{{DeviceId: device.Id, Counter: 0}}
+
+	var archive bytes.Buffer
+	if err := Write(&archive, FormatTar, device, device.State(), transactions, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	files := readArchive(t, FormatTar, archive.Bytes())
+	if len(files[TransactionsJSONLFile]) != 0 {
+		t.Error("Transactions the device has not counted yet must not be exported.")
+	}
+}
+
+func TestWrite_BinarySignedData(t *testing.T) {
+	signer, _ := crypto.SignerFactory("ECC")
+	var transactions journal
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "export", signer, domain.WithJournal(&transactions))
+	device.SignData([]byte{0xff, 0xfe, 0x00, 0x80})
+
+	var archive bytes.Buffer
+	if err := Write(&archive, FormatTar, device, device.State(), transactions, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	files := readArchive(t, FormatTar, archive.Bytes())
+
+	var exported Transaction
+	json.Unmarshal(files[TransactionsJSONLFile], &exported)
+	signature, _ := base64.StdEncoding.DecodeString(exported.Signature)
+	if !bytes.Equal(exported.SignedData, transactions[0].SignedData) || !signer.VerifySignature(exported.SignedData, signature) {
+		t.Error("Binary signed data must be exported unchanged, got", exported.SignedData)
+	}
+	records, err := csv.NewReader(bytes.NewReader(files[TransactionsCSVFile])).ReadAll()
+	if err != nil || len(records) != 2 {
+		t.Fatal("Expected a header and one transaction, got", records, err)
+	}
+	if signedData, _ := base64.StdEncoding.DecodeString(records[1][1]); !bytes.Equal(signedData, transactions[0].SignedData) {
+		t.Error("Binary signed data must be exported unchanged to CSV, got", records[1][1])
+	}
+}
+
+func TestWrite_DescribesGivenState(t *testing.T) {
+	signer, _ := crypto.SignerFactory("ECC")
+	var transactions journal
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "export", signer, domain.WithJournal(&transactions))
+	device.SignData([]byte("first"))
+	state := device.State()
+	// Signed after the state was read, like a signature committed during the export.
+	device.SignData([]byte("second"))
+
+	var archive bytes.Buffer
+	if err := Write(&archive, FormatTar, device, state, transactions, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	files := readArchive(t, FormatTar, archive.Bytes())
+	var exported Device
+	json.Unmarshal(files[DeviceFile], &exported)
+	if exported.SignatureCounter != 1 || exported.LastSignature != base64.StdEncoding.EncodeToString(transactions[0].Signature) {
+		t.Error("Device must be described in the given state, got", exported)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(files[TransactionsJSONLFile])), "\n"); len(lines) != 1 {
+		t.Error("Only transactions of the given state must be exported, got", len(lines))
+	}
+}
+
+func TestWrite_RotatedDevice(t *testing.T) {
+	first, _ := crypto.SignerFactory("ECC")
+	var transactions journal
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "export", first, domain.WithJournal(&transactions))
+	device.SignData([]byte("first"))
+	second, _ := crypto.SignerFactory("ECC")
+	device.RotateKey(second)
+	device.SignData([]byte("second"))
+
+	var archive bytes.Buffer
+	if err := Write(&archive, FormatTar, device, device.State(), transactions, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	files := readArchive(t, FormatTar, archive.Bytes())
+	var exported Device
+	json.Unmarshal(files[DeviceFile], &exported)
+	if exported.KeyVersion != 2 {
+		t.Error("Device must be exported with its key version, got", exported.KeyVersion)
+	}
+	if block, _ := pem.Decode(files[RetiredKeysFile]); block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatal("Retired key must be exported PEM encoded.")
+	}
+	lines := strings.Split(strings.TrimSpace(string(files[TransactionsJSONLFile])), "\n")
+	for i, line := range lines {
+		var entry Transaction
+		json.Unmarshal([]byte(line), &entry)
+		signature, _ := base64.StdEncoding.DecodeString(entry.Signature)
+		signer := []crypto.Signer{first, second}[i]
+		if entry.KeyVersion != i+1 || !signer.VerifySignature(entry.SignedData, signature) {
+			t.Error("Transaction must name the key version that signed it, got", entry.KeyVersion)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if format, err := ParseFormat(""); err != nil || format != FormatTar {
+		t.Error("Empty format must default to tar.")
+	}
+	if _, err := ParseFormat("rar"); err == nil {
+		t.Error("Unknown format must be rejected.")
+	}
+}
@@ -1,5 +1,24 @@
 module github.com/fiskaly/coding-challenges/signing-service-challenge
 
-go 1.20
+go 1.21
 
 require github.com/google/uuid v1.3.0
+
+require (
+	github.com/digitorus/timestamp v0.0.0-20250524132541-c45532741eea
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/digitorus/pkcs7 v0.0.0-20230713084857-e76b763bdc49 // indirect
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/prometheus/client_golang v1.19.1
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
@@ -0,0 +1,61 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public JSON Web Key for signature verification. N and E are set for RSA keys, Curve, X and Y for
+// ECDSA keys, all base64url encoded big-endian integers.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ContentType is the media type of JSON Web Key Sets.
+const ContentType = "application/jwk-set+json"
+
+// NewJWK describes publicKey as JWK named keyId, for the algorithm Sign uses with it.
+func NewJWK(publicKey crypto.PublicKey, keyId string) (JWK, error) {
+	algorithm, _, err := Algorithm(publicKey)
+	if err != nil {
+		return JWK{}, err
+	}
+	jwk := JWK{Use: "sig", Algorithm: algorithm, KeyId: keyId}
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		key, err := publicKey.ECDH()
+		if err != nil {
+			return JWK{}, err
+		}
+		// The uncompressed point is 0x04 followed by the coordinates X and Y of equal size.
+		point := key.Bytes()[1:]
+		jwk.KeyType = "EC"
+		jwk.Curve = publicKey.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(point[:len(point)/2])
+		jwk.Y = base64.RawURLEncoding.EncodeToString(point[len(point)/2:])
+	default:
+		return JWK{}, fmt.Errorf("%w: %T", ErrUnsupportedKey, publicKey)
+	}
+	return jwk, nil
+}
@@ -0,0 +1,170 @@
+// Package jose signs compact JSON Web Signatures (RFC 7515) with the keys of signature devices and publishes their
+// public keys as JSON Web Keys (RFC 7517), for web frontends that consume JOSE.
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	signingcrypto "github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+// ErrUnsupportedKey is returned for keys without a JWS algorithm.
+var ErrUnsupportedKey = errors.New("unsupported key")
+
+// JWS algorithms of the device keys.
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	ES384 = "ES384"
+	ES512 = "ES512"
+)
+
+// header is the protected header of a JWS.
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyId     string `json:"kid"`
+}
+
+// Algorithm returns the JWS algorithm for a public key and the hash it signs: RS256 for RSA keys, and ES256, ES384
+// or ES512 for ECDSA keys on P-256, P-384 or P-521. The algorithm follows from the key, so keys on the same curve
+// always use the same hash, unlike the device signers, which sign SHA-256 with all keys. PS256 and EdDSA are not
+// supported, other keys return ErrUnsupportedKey.
+func Algorithm(publicKey crypto.PublicKey) (string, crypto.Hash, error) {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		return RS256, crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		switch publicKey.Curve {
+		case elliptic.P256():
+			return ES256, crypto.SHA256, nil
+		case elliptic.P384():
+			return ES384, crypto.SHA384, nil
+		case elliptic.P521():
+			return ES512, crypto.SHA512, nil
+		}
+		return "", 0, fmt.Errorf("%w: curve %s", ErrUnsupportedKey, publicKey.Curve.Params().Name)
+	default:
+		return "", 0, fmt.Errorf("%w: %T", ErrUnsupportedKey, publicKey)
+	}
+}
+
+// CheckSigner returns an error if Sign cannot sign with signer.
+func CheckSigner(signer signingcrypto.Signer) error {
+	if _, err := signingcrypto.StandardSigner(signer); err != nil {
+		return err
+	}
+	_, _, err := Algorithm(signer.GetPublicKey())
+	return err
+}
+
+// Sign creates a compact JWS of type JWT over the JSON encoding of payload, signed by signer and naming its key
+// by keyId.
+func Sign(signer signingcrypto.Signer, keyId string, payload interface{}) (string, error) {
+	key, err := signingcrypto.StandardSigner(signer)
+	if err != nil {
+		return "", err
+	}
+	algorithm, hash, err := Algorithm(key.Public())
+	if err != nil {
+		return "", err
+	}
+	encodedHeader, err := json.Marshal(header{Algorithm: algorithm, Type: "JWT", KeyId: keyId})
+	if err != nil {
+		return "", err
+	}
+	encodedPayload, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." +
+		base64.RawURLEncoding.EncodeToString(encodedPayload)
+
+	digest := hash.New()
+	digest.Write([]byte(signingInput))
+	signature, err := key.Sign(rand.Reader, digest.Sum(nil), hash)
+	if err != nil {
+		return "", err
+	}
+	if publicKey, ok := key.Public().(*ecdsa.PublicKey); ok {
+		if signature, err = ecdsaSignatureToJWS(publicKey, signature); err != nil {
+			return "", err
+		}
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks the signature of a compact JWS with publicKey and returns its decoded payload.
+func Verify(jws string, publicKey crypto.PublicKey) ([]byte, error) {
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed JWS: expected three parts")
+	}
+	encodedHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWS header: %w", err)
+	}
+	var protected header
+	if err := json.Unmarshal(encodedHeader, &protected); err != nil {
+		return nil, fmt.Errorf("malformed JWS header: %w", err)
+	}
+	algorithm, hash, err := Algorithm(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if protected.Algorithm != algorithm {
+		return nil, fmt.Errorf("JWS algorithm %s does not match the %s key", protected.Algorithm, algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWS signature: %w", err)
+	}
+
+	digest := hash.New()
+	digest.Write([]byte(parts[0] + "." + parts[1]))
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(publicKey, hash, digest.Sum(nil), signature)
+	case *ecdsa.PublicKey:
+		var r, s big.Int
+		r.SetBytes(signature[:len(signature)/2])
+		s.SetBytes(signature[len(signature)/2:])
+		if len(signature) != 2*scalarSize(publicKey) || !ecdsa.Verify(publicKey, digest.Sum(nil), &r, &s) {
+			err = errors.New("ecdsa: verification error")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWS signature: %w", err)
+	}
+	return base64.RawURLEncoding.DecodeString(parts[1])
+}
+
+// ecdsaSignatureToJWS converts an ASN.1 encoded ECDSA signature to r and s as big-endian integers of the byte
+// size of the curve order each, concatenated, as required by RFC 7518.
+func ecdsaSignatureToJWS(publicKey *ecdsa.PublicKey, signature []byte) ([]byte, error) {
+	var parsed struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(signature, &parsed); err != nil {
+		return nil, err
+	}
+	size := scalarSize(publicKey)
+	converted := make([]byte, 2*size)
+	parsed.R.FillBytes(converted[:size])
+	parsed.S.FillBytes(converted[size:])
+	return converted, nil
+}
+
+// scalarSize is the byte size of r and s in signatures of the curve of publicKey.
+func scalarSize(publicKey *ecdsa.PublicKey) int {
+	return (publicKey.Curve.Params().N.BitLen() + 7) / 8
+}
@@ -0,0 +1,86 @@
+package jose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	signingcrypto "github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+func newECCSigner(t *testing.T, curve elliptic.Curve) signingcrypto.Signer {
+	t.Helper()
+	keyPair, err := (&signingcrypto.ECCGenerator{Curve: curve}).Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingcrypto.NewECCSignerFromKeyPair(keyPair, nil)
+}
+
+func decodeInt(t *testing.T, encoded string) *big.Int {
+	t.Helper()
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return new(big.Int).SetBytes(decoded)
+}
+
+func TestSign_AlgorithmFollowsKey(t *testing.T) {
+	signers := map[string]signingcrypto.Signer{
+		RS256: signingcrypto.NewRSASigner(),
+		ES256: newECCSigner(t, elliptic.P256()),
+		ES384: newECCSigner(t, elliptic.P384()),
+		ES512: newECCSigner(t, elliptic.P521()),
+	}
+	for algorithm, signer := range signers {
+		jws, err := Sign(signer, "device:1", map[string]int{"counter": 7})
+		if err != nil {
+			t.Fatal(algorithm, err)
+		}
+		var protected header
+		encodedHeader, _ := base64.RawURLEncoding.DecodeString(strings.Split(jws, ".")[0])
+		json.Unmarshal(encodedHeader, &protected)
+		if protected.Algorithm != algorithm || protected.KeyId != "device:1" {
+			t.Error("Expected", algorithm, "with key id, got", protected)
+		}
+
+		payload, err := Verify(jws, signer.GetPublicKey())
+		if err != nil || string(payload) != `{"counter":7}` {
+			t.Error(algorithm, "JWS must verify, got", string(payload), err)
+		}
+		if _, err := Verify(jws[:len(jws)-4]+"AAAA", signer.GetPublicKey()); err == nil {
+			t.Error(algorithm, "JWS with other signature must not verify")
+		}
+	}
+}
+
+func TestNewJWK_DescribesPublicKey(t *testing.T) {
+	rsaSigner := signingcrypto.NewRSASigner()
+	jwk, err := NewJWK(rsaSigner.GetPublicKey(), "rsa:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey := rsaSigner.GetPublicKey().(*rsa.PublicKey)
+	if jwk.KeyType != "RSA" || jwk.Algorithm != RS256 || decodeInt(t, jwk.N).Cmp(rsaKey.N) != 0 || decodeInt(t, jwk.E).Int64() != int64(rsaKey.E) {
+		t.Error("JWK must hold the RSA public key, got", jwk)
+	}
+
+	eccSigner := newECCSigner(t, elliptic.P521())
+	jwk, err = NewJWK(eccSigner.GetPublicKey(), "ecc:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &ecdsa.PublicKey{Curve: elliptic.P521(), X: decodeInt(t, jwk.X), Y: decodeInt(t, jwk.Y)}
+	if jwk.KeyType != "EC" || jwk.Curve != "P-521" || jwk.Algorithm != ES512 || !restored.Equal(eccSigner.GetPublicKey()) {
+		t.Error("JWK must hold the ECDSA public key, got", jwk)
+	}
+	if x, _ := base64.RawURLEncoding.DecodeString(jwk.X); len(x) != 66 {
+		t.Error("Coordinates must have the size of the curve, got", len(x), "bytes")
+	}
+}
@@ -1,22 +1,170 @@
 package main
 
 import (
-	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
-	"log"
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/anchor"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
-)
-
-const (
-	ListenAddress = ":8080"
-	// TODO: add further configuration parameters here ...
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/auth"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/config"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/metrics"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/tsa"
 )
 
 func main() {
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		slog.Error("Could not load configuration", "error", err)
+		os.Exit(1)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		slog.Error("Could not create logger", "error", err)
+		os.Exit(1)
+	}
+
+	if err := run(cfg, logger); err != nil {
+		logger.Error("Signing service failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg config.Config, logger *slog.Logger) error {
+	keyPolicy, err := cfg.KeyPolicy.SignerKeyPolicy()
+	if err != nil {
+		return err
+	}
+	keyPolicy.Logger = logger
+
+	options := []api.Option{
+		api.WithLogger(logger),
+		api.WithKeyPolicy(keyPolicy),
+		api.WithTimeouts(
+			time.Duration(cfg.Timeouts.Read),
+			time.Duration(cfg.Timeouts.Write),
+			time.Duration(cfg.Timeouts.Idle),
+		),
+	}
+	if cfg.TLS.Enabled {
+		reloader, err := api.NewTLSReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile, logger)
+		if err != nil {
+			return err
+		}
+		options = append(options, api.WithTLS(reloader))
+	}
+	backupKey, err := cfg.Backup.SealingKey()
+	if err != nil {
+		return err
+	}
+	if backupKey != nil {
+		options = append(options, api.WithBackupKey(backupKey))
+	}
+
+	if cfg.Timestamp.URL != "" {
+		options = append(options, api.WithTimestampAuthority(tsa.NewClient(cfg.Timestamp.URL, time.Duration(cfg.Timestamp.Timeout))))
+	}
+
+	m := metrics.New()
+	options = append(options, api.WithMetrics(m))
+
 	db := persistence.GetInMemoryDB()
-	server := api.NewServer(ListenAddress, db)
+	if cfg.Storage.DataDir != "" {
+		db, err = persistence.OpenInMemoryDB(persistence.WALConfig{
+			Dir:              cfg.Storage.DataDir,
+			SnapshotInterval: time.Duration(cfg.Storage.SnapshotInterval),
+			Logger:           logger,
+			DeviceOptions:    []domain.DeviceOption{domain.WithObserver(m), domain.WithLogger(logger)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.Auth.Enabled {
+		// API keys are recorded in the database like organizations. The bootstrap admin key is not: it is added
+		// from the configuration on every start.
+		keys := auth.NewStoredKeyStore(db)
+		_, err := keys.Add(auth.APIKey{
+			Name:        "bootstrap admin",
+			Permissions: []auth.Permission{auth.PermissionAdmin},
+		}, cfg.Auth.AdminKey)
+		if err != nil {
+			return err
+		}
+		options = append(options, api.WithAuthentication(keys))
+	}
+	// The audit log is recorded in the database, so that its chains survive restarts with a data directory.
+	auditLog, err := audit.NewStoredLog(db)
+	if err != nil {
+		return err
+	}
+	options = append(options, api.WithAuditLog(auditLog))
+	if cfg.Anchoring.Interval > 0 {
+		if cfg.Anchoring.KeyFile == "" {
+			logger.Warn("No anchoring key file configured, tree heads are signed with a key that is lost on restart")
+		}
+		signer, err := anchor.LoadKey(cfg.Anchoring.KeyFile, cfg.Anchoring.KeyAlgorithm, logger)
+		if err != nil {
+			return err
+		}
+		anchors, err := anchor.New(db, anchor.Config{Signer: signer, Logger: logger})
+		if err != nil {
+			return err
+		}
+		anchors.Run(time.Duration(cfg.Anchoring.Interval))
+		options = append(options, api.WithAnchoring(anchors))
+	}
+	if cfg.CA.Enabled {
+		if cfg.CA.CertFile == "" {
+			logger.Warn("No CA files configured, device certificates are issued by a root that is lost on restart")
+		}
+		authority, err := ca.Load(cfg.CA.CertFile, cfg.CA.KeyFile, ca.Config{Validity: time.Duration(cfg.CA.Validity)})
+		if err != nil {
+			return err
+		}
+		options = append(options, api.WithCertificateAuthority(authority))
+	}
+	if cfg.Ownership.Enabled {
+		options = append(options, api.WithOwnership(api.Ownership{
+			ReplicaId: cfg.Ownership.ReplicaId,
+			Address:   cfg.Ownership.Address,
+			LeaseTTL:  time.Duration(cfg.Ownership.LeaseTTL),
+			Redirect:  cfg.Ownership.Redirect,
+		}))
+	}
+	server := api.NewServer(cfg.ListenAddress, db, options...)
+	server.RegisterOnShutdown(func(ctx context.Context) error {
+		return db.Close()
+	})
 
-	if err := server.Run(); err != nil {
-		log.Fatal("Could not start server on ", ListenAddress)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Run()
+	}()
+	logger.Info("Signing service started", "address", cfg.ListenAddress, "tls", cfg.TLS.Enabled, "auth", cfg.Auth.Enabled)
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
 	}
+	stop()
+
+	logger.Info("Shutting down, waiting for in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeouts.Shutdown))
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
 }
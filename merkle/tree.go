@@ -0,0 +1,267 @@
+// Package merkle computes Merkle tree hashes, inclusion proofs and consistency proofs over ordered lists of leaves,
+// as specified for Certificate Transparency in RFC 6962, section 2.1. Leaves and inner nodes are hashed with
+// different prefixes, so that an inner node can never be passed off as a leaf.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// HashSize is the size of all hashes of a tree.
+const HashSize = sha256.Size
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// LeafHash is the hash of a leaf with the given data.
+func LeafHash(data []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{leafPrefix})
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+// NodeHash is the hash of an inner node with the given children.
+func NodeHash(left []byte, right []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{nodePrefix})
+	hash.Write(left)
+	hash.Write(right)
+	return hash.Sum(nil)
+}
+
+// Root returns the root hash of the tree with the given leaves. The root of an empty tree is the hash
+// of no data.
+func Root(leaves [][]byte) []byte {
+	hashes := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = LeafHash(leaf)
+	}
+	return rootOf(hashes)
+}
+
+// rootOf returns the root over leaf hashes. The left subtree always holds the largest power of two
+// smaller than the number of leaves.
+func rootOf(hashes [][]byte) []byte {
+	switch len(hashes) {
+	case 0:
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	case 1:
+		return hashes[0]
+	}
+	split := largestPowerOfTwoBelow(len(hashes))
+	return NodeHash(rootOf(hashes[:split]), rootOf(hashes[split:]))
+}
+
+// largestPowerOfTwoBelow returns the largest power of two smaller than n, for n > 1.
+func largestPowerOfTwoBelow(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// ErrInvalidRange is returned for proofs of leaves or tree sizes that the tree does not have.
+var ErrInvalidRange = errors.New("invalid leaf index or tree size")
+
+// ErrInvalidProof is returned if a proof does not lead to the expected root hashes.
+var ErrInvalidProof = errors.New("invalid proof")
+
+// Tree is an append-only Merkle tree. It keeps the hashes of all complete subtrees, so that the roots and proofs of
+// the tree at any earlier size take O(log² n) hashes.
+type Tree struct {
+	// levels[k][i] is the hash of the complete subtree over the leaves i*2^k to (i+1)*2^k-1.
+	levels [][][]byte
+}
+
+// Append adds a leaf with the given data.
+func (t *Tree) Append(data []byte) {
+	t.AppendHash(LeafHash(data))
+}
+
+// AppendHash adds a leaf by its LeafHash.
+func (t *Tree) AppendHash(hash []byte) {
+	for level := 0; ; level++ {
+		if level == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		t.levels[level] = append(t.levels[level], hash)
+		n := len(t.levels[level])
+		if n%2 == 1 {
+			return
+		}
+		hash = NodeHash(t.levels[level][n-2], hash)
+	}
+}
+
+// Size returns the number of leaves.
+func (t *Tree) Size() int {
+	if len(t.levels) == 0 {
+		return 0
+	}
+	return len(t.levels[0])
+}
+
+// LeafHashAt returns the hash of the leaf at index.
+func (t *Tree) LeafHashAt(index int) ([]byte, error) {
+	if index < 0 || index >= t.Size() {
+		return nil, ErrInvalidRange
+	}
+	return t.levels[0][index], nil
+}
+
+// RootAt returns the root hash of the tree when it had the given number of leaves.
+func (t *Tree) RootAt(size int) ([]byte, error) {
+	if size < 0 || size > t.Size() {
+		return nil, ErrInvalidRange
+	}
+	if size == 0 {
+		return rootOf(nil), nil
+	}
+	return t.subtreeHash(0, size), nil
+}
+
+// subtreeHash returns the root hash over the leaves start to end-1.
+func (t *Tree) subtreeHash(start int, end int) []byte {
+	size := end - start
+	if size&(size-1) == 0 && start%size == 0 {
+		level := bits.TrailingZeros(uint(size))
+		return t.levels[level][start>>level]
+	}
+	split := largestPowerOfTwoBelow(size)
+	return NodeHash(t.subtreeHash(start, start+split), t.subtreeHash(start+split, end))
+}
+
+// InclusionProof returns the audit path of the leaf at index in the tree with the given size (RFC 6962, 2.1.1).
+func (t *Tree) InclusionProof(index int, size int) ([][]byte, error) {
+	if size > t.Size() || index < 0 || index >= size {
+		return nil, ErrInvalidRange
+	}
+	return t.path(index, 0, size), nil
+}
+
+func (t *Tree) path(index int, start int, end int) [][]byte {
+	if end-start == 1 {
+		return nil
+	}
+	split := largestPowerOfTwoBelow(end - start)
+	if index < split {
+		return append(t.path(index, start, start+split), t.subtreeHash(start+split, end))
+	}
+	return append(t.path(index-split, start+split, end), t.subtreeHash(start, start+split))
+}
+
+// ConsistencyProof returns the proof that the tree with size leaves extends the tree with oldSize leaves
+// (RFC 6962, 2.1.2).
+func (t *Tree) ConsistencyProof(oldSize int, size int) ([][]byte, error) {
+	if oldSize < 1 || oldSize > size || size > t.Size() {
+		return nil, ErrInvalidRange
+	}
+	return t.subproof(oldSize, 0, size, true), nil
+}
+
+func (t *Tree) subproof(oldSize int, start int, end int, complete bool) [][]byte {
+	if oldSize == end-start {
+		if complete {
+			return nil
+		}
+		return [][]byte{t.subtreeHash(start, end)}
+	}
+	split := largestPowerOfTwoBelow(end - start)
+	if oldSize <= split {
+		return append(t.subproof(oldSize, start, start+split, complete), t.subtreeHash(start+split, end))
+	}
+	return append(t.subproof(oldSize-split, start+split, end, false), t.subtreeHash(start, start+split))
+}
+
+// VerifyInclusion checks that proof is the audit path of a leaf with leafHash at index in the tree with the given
+// size and root (RFC 9162, 2.1.3.2).
+func VerifyInclusion(leafHash []byte, index uint64, size uint64, proof [][]byte, root []byte) error {
+	if index >= size {
+		return ErrInvalidRange
+	}
+	fn, sn := index, size-1
+	hash := leafHash
+	for _, sibling := range proof {
+		if sn == 0 {
+			return fmt.Errorf("%w: audit path too long", ErrInvalidProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			hash = NodeHash(sibling, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = NodeHash(hash, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("%w: audit path too short", ErrInvalidProof)
+	}
+	if !bytes.Equal(hash, root) {
+		return fmt.Errorf("%w: root hash differs", ErrInvalidProof)
+	}
+	return nil
+}
+
+// VerifyConsistency checks that proof shows the tree with size leaves and root to extend the tree with oldSize
+// leaves and oldRoot (RFC 9162, 2.1.4.2).
+func VerifyConsistency(oldSize uint64, size uint64, oldRoot []byte, root []byte, proof [][]byte) error {
+	switch {
+	case oldSize < 1 || oldSize > size:
+		return ErrInvalidRange
+	case oldSize == size:
+		if len(proof) != 0 || !bytes.Equal(oldRoot, root) {
+			return fmt.Errorf("%w: trees of equal size differ", ErrInvalidProof)
+		}
+		return nil
+	}
+	if oldSize&(oldSize-1) == 0 {
+		// The old tree is a complete subtree of the new one; its root is the start of the proof.
+		proof = append([][]byte{oldRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return fmt.Errorf("%w: empty proof", ErrInvalidProof)
+	}
+	fn, sn := oldSize-1, size-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	oldHash, newHash := proof[0], proof[0]
+	for _, node := range proof[1:] {
+		if sn == 0 {
+			return fmt.Errorf("%w: proof too long", ErrInvalidProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			oldHash = NodeHash(node, oldHash)
+			newHash = NodeHash(node, newHash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			newHash = NodeHash(newHash, node)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("%w: proof too short", ErrInvalidProof)
+	}
+	if !bytes.Equal(oldHash, oldRoot) || !bytes.Equal(newHash, root) {
+		return fmt.Errorf("%w: root hash differs", ErrInvalidProof)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestRoot_MatchesRFC6962(t *testing.T) {
+	// Test vectors of the Certificate Transparency implementations, over the leaves below.
+	leaves := [][]byte{
+		{},
+		{0x00},
+		{0x10},
+		{0x20, 0x21},
+		{0x30, 0x31},
+		{0x40, 0x41, 0x42, 0x43},
+		{0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57},
+		{0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f},
+	}
+	roots := []string{
+		"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+		"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+		"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+		"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+	}
+	for i, expected := range roots {
+		if root := hex.EncodeToString(Root(leaves[:i+1])); root != expected {
+			t.Errorf("root of %d leaves is %s, expected %s", i+1, root, expected)
+		}
+	}
+}
+
+func TestRoot_DependsOnOrder(t *testing.T) {
+	if bytes.Equal(Root([][]byte{[]byte("a"), []byte("b")}), Root([][]byte{[]byte("b"), []byte("a")})) {
+		t.Error("roots of reordered leaves are equal")
+	}
+	if bytes.Equal(Root([][]byte{[]byte("a")}), LeafHash([]byte("b"))) {
+		t.Error("root of a single leaf does not depend on the leaf")
+	}
+}
+
+func testTree(size int) (*Tree, [][]byte) {
+	var tree Tree
+	leaves := make([][]byte, size)
+	for i := range leaves {
+		leaves[i] = []byte{byte(i), byte(i >> 8)}
+		tree.Append(leaves[i])
+	}
+	return &tree, leaves
+}
+
+func TestTree_RootsMatchRoot(t *testing.T) {
+	tree, leaves := testTree(70)
+	for size := 0; size <= tree.Size(); size++ {
+		root, err := tree.RootAt(size)
+		if err != nil || !bytes.Equal(root, Root(leaves[:size])) {
+			t.Fatal("Root of size", size, "differs:", err)
+		}
+	}
+	if _, err := tree.RootAt(71); err != ErrInvalidRange {
+		t.Error("Root beyond the tree must be rejected, got", err)
+	}
+}
+
+func TestTree_InclusionProofs(t *testing.T) {
+	tree, _ := testTree(33)
+	for size := 1; size <= tree.Size(); size++ {
+		root, _ := tree.RootAt(size)
+		for index := 0; index < size; index++ {
+			proof, err := tree.InclusionProof(index, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leafHash, _ := tree.LeafHashAt(index)
+			if err := VerifyInclusion(leafHash, uint64(index), uint64(size), proof, root); err != nil {
+				t.Fatal("Proof of leaf", index, "in tree of size", size, "does not verify:", err)
+			}
+			if err := VerifyInclusion(LeafHash([]byte("forged")), uint64(index), uint64(size), proof, root); err == nil {
+				t.Fatal("Proof of leaf", index, "must not verify another leaf")
+			}
+			if size > 1 {
+				if err := VerifyInclusion(leafHash, uint64(index), uint64(size), proof[1:], root); err == nil {
+					t.Fatal("Truncated proof of leaf", index, "must not verify")
+				}
+			}
+		}
+	}
+	if _, err := tree.InclusionProof(5, 5); err != ErrInvalidRange {
+		t.Error("Leaf beyond the size must be rejected, got", err)
+	}
+}
+
+func TestTree_ConsistencyProofs(t *testing.T) {
+	tree, _ := testTree(33)
+	for size := 1; size <= tree.Size(); size++ {
+		root, _ := tree.RootAt(size)
+		for oldSize := 1; oldSize <= size; oldSize++ {
+			oldRoot, _ := tree.RootAt(oldSize)
+			proof, err := tree.ConsistencyProof(oldSize, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyConsistency(uint64(oldSize), uint64(size), oldRoot, root, proof); err != nil {
+				t.Fatal("Proof from", oldSize, "to", size, "does not verify:", err)
+			}
+			if oldSize < size {
+				forged := LeafHash([]byte("forged"))
+				if err := VerifyConsistency(uint64(oldSize), uint64(size), forged, root, proof); err == nil {
+					t.Fatal("Proof from", oldSize, "to", size, "must not verify another old root")
+				}
+			}
+		}
+	}
+	if _, err := tree.ConsistencyProof(0, 5); err != ErrInvalidRange {
+		t.Error("Proof from an empty tree must be rejected, got", err)
+	}
+}
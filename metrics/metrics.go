@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds all metrics of the signing service in its own registry,
+// so that several servers (e.g. in tests) do not share state.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	signRequests  *prometheus.CounterVec
+	signDuration  *prometheus.HistogramVec
+	keyGeneration *prometheus.HistogramVec
+	devices       *prometheus.GaugeVec
+	lockWait      *prometheus.HistogramVec
+	httpRequests  *prometheus.CounterVec
+	httpDuration  *prometheus.HistogramVec
+}
+
+// New creates and registers all metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		signRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "signing_sign_requests_total",
+			Help: "Number of sign requests per device algorithm and outcome.",
+		}, []string{"algorithm", "outcome"}),
+		signDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "signing_sign_duration_seconds",
+			Help:    "Duration of signing operations per device algorithm, including waiting for the device.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"algorithm"}),
+		keyGeneration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "signing_key_generation_duration_seconds",
+			Help:    "Duration of key pair generation for new devices per algorithm.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"algorithm"}),
+		devices: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "signing_devices",
+			Help: "Number of signature devices per algorithm.",
+		}, []string{"algorithm"}),
+		lockWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "signing_device_lock_wait_seconds",
+			Help:    "Time a sign operation waited to acquire the device lock, per algorithm.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 12),
+		}, []string{"algorithm"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "signing_http_requests_total",
+			Help: "Number of HTTP requests per route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "signing_http_request_duration_seconds",
+			Help:    "Duration of HTTP requests per route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route"}),
+	}
+
+	m.registry.MustRegister(
+		m.signRequests,
+		m.signDuration,
+		m.keyGeneration,
+		m.devices,
+		m.lockWait,
+		m.httpRequests,
+		m.httpDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveSign records the outcome and duration of a sign request.
+func (m *Metrics) ObserveSign(algorithm string, err error, duration time.Duration) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	m.signRequests.WithLabelValues(algorithm, outcome).Inc()
+	m.signDuration.WithLabelValues(algorithm).Observe(duration.Seconds())
+}
+
+// ObserveKeyGeneration records how long generating a key pair took.
+func (m *Metrics) ObserveKeyGeneration(algorithm string, duration time.Duration) {
+	m.keyGeneration.WithLabelValues(algorithm).Observe(duration.Seconds())
+}
+
+// DeviceAdded increments the number of devices of the algorithm.
+func (m *Metrics) DeviceAdded(algorithm string) {
+	m.devices.WithLabelValues(algorithm).Inc()
+}
+
+// ObserveLockWait records how long a sign operation waited for the device lock.
+// It implements domain.Observer.
+func (m *Metrics) ObserveLockWait(algorithm string, wait time.Duration) {
+	m.lockWait.WithLabelValues(algorithm).Observe(wait.Seconds())
+}
+
+// InstrumentHandler counts the requests of a route by method and status code and records their duration.
+func (m *Metrics) InstrumentHandler(route string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: response, status: http.StatusOK}
+		handler.ServeHTTP(recorder, request)
+
+		m.httpRequests.WithLabelValues(route, request.Method, strconv.Itoa(recorder.status)).Inc()
+		m.httpDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap gives http.ResponseController access to the original ResponseWriter.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
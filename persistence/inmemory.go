@@ -1,52 +1,1080 @@
 package persistence
 
 import (
+	"bytes"
+	"context"
+	gocrypto "crypto"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/auth"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
 	"github.com/google/uuid"
-	"sync"
 )
 
 var db *InMemoryDB
 
-// InMemoryDB is a struct that holds a devices map.
+// InMemoryDB is a struct that holds a devices map, the organizations owning them and the journal of their transactions.
+// All device queries are scoped to an organization.
+// When opened with OpenInMemoryDB, every change is written to a write-ahead log before it is acknowledged.
 type InMemoryDB struct {
-	data map[uuid.UUID]*domain.SignatureDevice
-	mu   sync.RWMutex
+	data          map[uuid.UUID]*domain.SignatureDevice
+	transactions  map[uuid.UUID][]domain.Transaction
+	organizations map[uuid.UUID]*domain.Organization
+	// states holds the committed state of every device, independent of the device objects and their locks.
+	states map[uuid.UUID]domain.DeviceState
+	leases map[uuid.UUID]domain.Lease
+	// fencingTokens holds the highest fencing token issued for every leased device. Unlike the leases, it is
+	// written to the write-ahead log, so that tokens never go backwards across restarts.
+	fencingTokens map[uuid.UUID]uint64
+	// migrated holds the devices migrated to another instance, imported the highest counter of every device
+	// imported from a backup.
+	migrated map[uuid.UUID]bool
+	imported map[uuid.UUID]uint64
+	// suspended holds the devices suspended by an admin.
+	suspended map[uuid.UUID]bool
+	// anchored lists the transactions of the anchoring tree in order; anchoredCount holds how many transactions
+	// of the journal of each device are anchored.
+	anchored      []domain.AnchorRef
+	anchoredCount map[uuid.UUID]int
+	treeHeads     []domain.TreeHead
+	// certificates holds the certificate chain of every certified device, its own certificate first.
+	certificates map[uuid.UUID]domain.CertificateChain
+	// apiKeys holds the stored API keys.
+	apiKeys map[uuid.UUID]auth.StoredKey
+	// auditEntries holds the entries of the audit log in the order they were appended.
+	auditEntries []audit.Entry
+	closed       bool
+	mu           sync.RWMutex
+
+	wal           *wal
+	snapshotMu    sync.Mutex
+	stopSnapshots chan struct{}
+	logger        *slog.Logger
 }
 
+// ErrClosed is returned by Ping and writes once the database has been closed.
+var ErrClosed = errors.New("database closed")
+
 // GetInMemoryDB returns the instance of InMemoryDB
 func GetInMemoryDB() *InMemoryDB {
+	db = newInMemoryDB()
+	db.organizations[domain.DefaultOrganizationId] = &domain.Organization{Id: domain.DefaultOrganizationId, Name: "default"}
+	return db
+}
 
-	db = &InMemoryDB{
-		data: make(map[uuid.UUID]*domain.SignatureDevice),
+func newInMemoryDB() *InMemoryDB {
+	return &InMemoryDB{
+		data:          make(map[uuid.UUID]*domain.SignatureDevice),
+		transactions:  make(map[uuid.UUID][]domain.Transaction),
+		organizations: make(map[uuid.UUID]*domain.Organization),
+		states:        make(map[uuid.UUID]domain.DeviceState),
+		leases:        make(map[uuid.UUID]domain.Lease),
+		fencingTokens: make(map[uuid.UUID]uint64),
+		migrated:      make(map[uuid.UUID]bool),
+		imported:      make(map[uuid.UUID]uint64),
+		suspended:     make(map[uuid.UUID]bool),
+		apiKeys:       make(map[uuid.UUID]auth.StoredKey),
+		anchoredCount: make(map[uuid.UUID]int),
+		certificates:  make(map[uuid.UUID]domain.CertificateChain),
+		logger:        logging.Discard(),
 	}
-	return db
 }
 
-// Set sets a device in the in-memory database.
-func (db *InMemoryDB) Set(key uuid.UUID, device *domain.SignatureDevice) {
+// WALConfig configures the durability of an InMemoryDB.
+type WALConfig struct {
+	// Dir holds the snapshot and the segments of the write-ahead log. It is created if missing.
+	Dir string
+	// SnapshotInterval is the period of snapshots, after which the log is compacted. Zero disables periodic snapshots.
+	SnapshotInterval time.Duration
+	// Logger receives failures of periodic snapshots. A nil logger discards them.
+	Logger *slog.Logger
+	// DeviceOptions are applied to the devices rebuilt from the log.
+	DeviceOptions []domain.DeviceOption
+}
+
+// OpenInMemoryDB rebuilds the database from the snapshot and write-ahead log in config.Dir
+// and logs all further changes there. Private keys are stored unencrypted, so Dir must be protected.
+func OpenInMemoryDB(config WALConfig) (*InMemoryDB, error) {
+	if err := os.MkdirAll(config.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	db = newInMemoryDB()
+	if config.Logger != nil {
+		db.logger = config.Logger
+	}
+	if err := db.replay(config.Dir, append([]domain.DeviceOption{domain.WithJournal(db)}, config.DeviceOptions...)); err != nil {
+		return nil, err
+	}
+
+	if _, exists := db.organizations[domain.DefaultOrganizationId]; !exists {
+		if err := db.SetOrganization(&domain.Organization{Id: domain.DefaultOrganizationId, Name: "default"}); err != nil {
+			db.wal.close()
+			return nil, err
+		}
+	}
+
+	if config.SnapshotInterval > 0 {
+		db.stopSnapshots = make(chan struct{})
+		go db.snapshotPeriodically(config.SnapshotInterval)
+	}
+	return db, nil
+}
+
+// replay loads the snapshot, applies all later records of the log and opens a new segment for further changes.
+func (db *InMemoryDB) replay(dir string, options []domain.DeviceOption) error {
+	s, err := readSnapshot(dir)
+	if err != nil {
+		return err
+	}
+	for _, organization := range s.Organizations {
+		db.organizations[organization.Id] = &domain.Organization{Id: organization.Id, Name: organization.Name}
+	}
+	devices := make(map[uuid.UUID]deviceRecord)
+	for _, device := range s.Devices {
+		devices[device.Id] = device
+	}
+	for i := range s.Transactions {
+		transaction, err := s.Transactions[i].transaction()
+		if err != nil {
+			return err
+		}
+		db.transactions[transaction.DeviceId] = append(db.transactions[transaction.DeviceId], transaction)
+	}
+	for _, head := range s.TreeHeads {
+		db.treeHeads = append(db.treeHeads, head.treeHead())
+	}
+	if err := db.appendAnchored(anchorRefs(s.Anchored)); err != nil {
+		return fmt.Errorf("%w: snapshot: %v", ErrCorrupted, err)
+	}
+	for _, certificate := range s.Certificates {
+		db.certificates[certificate.DeviceId] = certificate.certificateChain()
+	}
+	db.auditEntries = s.AuditEntries
+	for _, fencing := range s.FencingTokens {
+		db.fencingTokens[fencing.DeviceId] = fencing.Token
+	}
+	for _, id := range s.Migrated {
+		db.migrated[id] = true
+	}
+	for _, imported := range s.Imported {
+		db.imported[imported.DeviceId] = imported.Counter
+	}
+	for _, id := range s.Suspended {
+		db.suspended[id] = true
+	}
+	for _, r := range s.APIKeys {
+		key, err := r.storedKey()
+		if err != nil {
+			return fmt.Errorf("%w: snapshot: %v", ErrCorrupted, err)
+		}
+		db.apiKeys[key.Id] = key
+	}
+
+	sequence := s.Sequence
+	paths, err := segments(dir)
+	if err != nil {
+		return err
+	}
+	for i, path := range paths {
+		err := readSegment(path, i == len(paths)-1, func(r record) error {
+			if r.Sequence <= sequence {
+				// Already contained in the snapshot; the segment was not yet removed after it was written.
+				return nil
+			}
+			if r.Sequence != sequence+1 {
+				return fmt.Errorf("%w: expected record %d, found %d", ErrCorrupted, sequence+1, r.Sequence)
+			}
+			sequence = r.Sequence
+
+			switch {
+			case r.Organization != nil:
+				db.organizations[r.Organization.Id] = &domain.Organization{Id: r.Organization.Id, Name: r.Organization.Name}
+			case r.Device != nil:
+				devices[r.Device.Id] = *r.Device
+			case r.Transaction != nil:
+				r.Batch = []transactionRecord{*r.Transaction}
+			case r.Timestamp != nil:
+				if err := db.attachTimestamp(r.Timestamp.DeviceId, r.Timestamp.timestamp()); err != nil {
+					return fmt.Errorf("%w: record %d: %v", ErrCorrupted, r.Sequence, err)
+				}
+			case r.Anchor != nil:
+				if err := db.appendAnchored(anchorRefs(r.Anchor.Transactions)); err != nil {
+					return fmt.Errorf("%w: record %d: %v", ErrCorrupted, r.Sequence, err)
+				}
+				db.treeHeads = append(db.treeHeads, r.Anchor.TreeHead.treeHead())
+			case r.Certificate != nil:
+				if _, exists := devices[r.Certificate.DeviceId]; !exists {
+					return fmt.Errorf("%w: record %d certifies unknown device", ErrCorrupted, r.Sequence)
+				}
+				db.certificates[r.Certificate.DeviceId] = r.Certificate.certificateChain()
+			case r.Audit != nil:
+				db.auditEntries = append(db.auditEntries, *r.Audit)
+			case r.Fencing != nil:
+				db.fencingTokens[r.Fencing.DeviceId] = r.Fencing.Token
+			case r.Key != nil:
+				device, exists := devices[r.Key.DeviceId]
+				if !exists {
+					return fmt.Errorf("%w: record %d rotates the key of an unknown device", ErrCorrupted, r.Sequence)
+				}
+				device.Algorithm = r.Key.Algorithm
+				device.PrivateKey = r.Key.PrivateKey
+				device.RetiredKeys = r.Key.RetiredKeys
+				devices[device.Id] = device
+			case r.Migration != nil:
+				if _, exists := devices[r.Migration.DeviceId]; !exists {
+					return fmt.Errorf("%w: record %d migrates unknown device", ErrCorrupted, r.Sequence)
+				}
+				db.migrated[r.Migration.DeviceId] = true
+			case r.Suspension != nil:
+				if _, exists := devices[r.Suspension.DeviceId]; !exists {
+					return fmt.Errorf("%w: record %d suspends unknown device", ErrCorrupted, r.Sequence)
+				}
+				if r.Suspension.Suspended {
+					db.suspended[r.Suspension.DeviceId] = true
+				} else {
+					delete(db.suspended, r.Suspension.DeviceId)
+				}
+			case r.APIKey != nil:
+				key, err := r.APIKey.storedKey()
+				if err != nil {
+					return fmt.Errorf("%w: record %d: %v", ErrCorrupted, r.Sequence, err)
+				}
+				db.apiKeys[key.Id] = key
+			case r.APIKeyDeletion != nil:
+				delete(db.apiKeys, r.APIKeyDeletion.Id)
+			case r.Import != nil:
+				id := r.Import.Device.Id
+				devices[id] = r.Import.Device
+				for i := range r.Import.Transactions {
+					transaction, err := r.Import.Transactions[i].transaction()
+					if err != nil {
+						return err
+					}
+					db.transactions[id] = append(db.transactions[id], transaction)
+				}
+				delete(db.migrated, id)
+				db.imported[id] = r.Import.Device.SignatureCounter
+			}
+			for _, transaction := range r.Batch {
+				device, exists := devices[transaction.DeviceId]
+				if !exists {
+					return fmt.Errorf("%w: record %d signed by unknown device", ErrCorrupted, r.Sequence)
+				}
+				device.SignatureCounter = transaction.Counter + 1
+				migrated, err := transaction.transaction()
+				if err != nil {
+					return err
+				}
+				device.LastSignature = migrated.Signature
+				device.SignatureVersion = int(domain.CurrentSignatureVersion)
+				device.Version++
+				devices[device.Id] = device
+				db.transactions[transaction.DeviceId] = append(db.transactions[transaction.DeviceId], migrated)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, record := range devices {
+		signer, err := crypto.SignerFromPrivateKey(record.Algorithm, record.PrivateKey, db.logger)
+		if err != nil {
+			return fmt.Errorf("%w: device %s: %v", ErrCorrupted, record.Id, err)
+		}
+		lastSig, err := record.lastSignature()
+		if err != nil {
+			return err
+		}
+		retiredKeys, err := crypto.ParsePublicKeys(record.RetiredKeys)
+		if err != nil {
+			return fmt.Errorf("%w: retired keys of device %s: %v", ErrCorrupted, record.Id, err)
+		}
+		state := domain.DeviceState{Counter: record.SignatureCounter, LastSig: lastSig, Version: record.Version}
+		deviceOptions := append([]domain.DeviceOption{domain.WithDataFormat(record.dataFormat()), domain.WithRetiredKeys(retiredKeys)}, options...)
+		db.data[record.Id] = domain.RestoreSignatureDevice(record.Id, record.OrganizationId, record.Label, signer, state, deviceOptions...)
+		db.states[record.Id] = state
+	}
+
+	db.wal = &wal{dir: dir, sequence: sequence}
+	return db.wal.openSegment(sequence + 1)
+}
+
+// Snapshot writes the complete state to disk and removes the log segments it contains.
+func (db *InMemoryDB) Snapshot() error {
+	db.snapshotMu.Lock()
+	defer db.snapshotMu.Unlock()
+	return db.snapshot()
+}
+
+// snapshot implements Snapshot. The caller must hold db.snapshotMu.
+func (db *InMemoryDB) snapshot() error {
+	if db.wal == nil {
+		return nil
+	}
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return ErrClosed
+	}
+	s, err := db.snapshotLocked()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	compacted, err := db.wal.rotate()
+	db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := writeSnapshot(db.wal.dir, s); err != nil {
+		return err
+	}
+	for _, path := range compacted {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return syncDir(db.wal.dir)
+}
+
+// snapshotLocked copies the state. The caller must hold db.mu.
+func (db *InMemoryDB) snapshotLocked() (*snapshot, error) {
+	s := &snapshot{Sequence: db.wal.sequence}
+	for _, organization := range db.organizations {
+		s.Organizations = append(s.Organizations, organizationRecord{organization.Id, organization.Name})
+	}
+	for id, device := range db.data {
+		record, err := newDeviceRecord(device, db.states[id])
+		if err != nil {
+			return nil, err
+		}
+		s.Devices = append(s.Devices, *record)
+		for _, transaction := range db.transactions[id] {
+			s.Transactions = append(s.Transactions, *newTransactionRecord(transaction))
+		}
+	}
+	s.Anchored = newAnchorRefRecords(db.anchored)
+	for _, head := range db.treeHeads {
+		s.TreeHeads = append(s.TreeHeads, newTreeHeadRecord(head))
+	}
+	for id, chain := range db.certificates {
+		s.Certificates = append(s.Certificates, certificateRecord{DeviceId: id, Chain: chain.Chain, Attached: chain.Attached})
+	}
+	s.AuditEntries = db.auditEntries
+	for id, token := range db.fencingTokens {
+		s.FencingTokens = append(s.FencingTokens, fencingRecord{DeviceId: id, Token: token})
+	}
+	for id := range db.migrated {
+		s.Migrated = append(s.Migrated, id)
+	}
+	for id, counter := range db.imported {
+		s.Imported = append(s.Imported, importedRecord{DeviceId: id, Counter: counter})
+	}
+	for id := range db.suspended {
+		s.Suspended = append(s.Suspended, id)
+	}
+	for _, key := range db.apiKeys {
+		s.APIKeys = append(s.APIKeys, newAPIKeyRecord(key))
+	}
+	return s, nil
+}
+
+func (db *InMemoryDB) snapshotPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.Snapshot(); err != nil {
+				db.logger.Error("Writing snapshot failed", "error", err)
+			}
+		case <-db.stopSnapshots:
+			return
+		}
+	}
+}
+
+// log appends a record to the write-ahead log, if there is one. The caller must hold db.mu.
+func (db *InMemoryDB) log(r record) error {
+	if db.closed {
+		return ErrClosed
+	}
+	if db.wal == nil {
+		return nil
+	}
+	return db.wal.append(r)
+}
+
+// Set sets a device in the in-memory database, replacing the committed state with the current state of the device.
+// The version of the committed state is advanced beyond all previous versions, so that other instances of the
+// device notice the change with their next signature.
+func (db *InMemoryDB) Set(device *domain.SignatureDevice) error {
+	state := device.State()
+	r, err := db.deviceRecord(device, state)
+	if err != nil {
+		return err
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	db.data[key] = device
+	if stored, exists := db.states[device.Id]; exists && stored.Version >= state.Version {
+		state.Version = stored.Version + 1
+		if r.Device != nil {
+			r.Device.Version = state.Version
+		}
+	}
+	if err := db.log(r); err != nil {
+		return err
+	}
+	db.data[device.Id] = device
+	db.states[device.Id] = state
+	return nil
+}
+
+// deviceRecord prepares the log record of a device. Key material is only marshaled if there is a log.
+func (db *InMemoryDB) deviceRecord(device *domain.SignatureDevice, state domain.DeviceState) (record, error) {
+	if db.wal == nil {
+		return record{}, nil
+	}
+	deviceRecord, err := newDeviceRecord(device, state)
+	return record{Device: deviceRecord}, err
+}
+
+// ErrExists is returned by Insert if a device with the same id is already stored.
+var ErrExists = errors.New("device already exists")
+
+// Insert adds a device unless a device with the same id exists in any organization.
+func (db *InMemoryDB) Insert(device *domain.SignatureDevice) error {
+	state := device.State()
+	r, err := db.deviceRecord(device, state)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, exists := db.data[device.Id]; exists {
+		return ErrExists
+	}
+	if err := db.log(r); err != nil {
+		return err
+	}
+	db.data[device.Id] = device
+	db.states[device.Id] = state
+	return nil
+}
+
+// ImportDevice stores a device restored from a backup at its current state. The journal of the backup must continue
+// the stored journal of the device, or start at counter 0 for a new device, up to the restored state, so that the
+// journal has no gaps; otherwise the import is rejected with domain.ErrBrokenChain. States that do not advance the
+// counter beyond the stored state and all previous imports of the device are rejected with domain.ErrStaleState.
+// A migrated device signs again once a later state was imported.
+func (db *InMemoryDB) ImportDevice(device *domain.SignatureDevice, journal []domain.Transaction) error {
+	state := device.State()
+	r, err := db.deviceRecord(device, state)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if imported, exists := db.imported[device.Id]; exists && state.Counter <= imported {
+		return fmt.Errorf("%w: restoring counter %d, counter %d was imported before", domain.ErrStaleState, state.Counter, imported)
+	}
+	previous := domain.DeviceState{LastSig: device.Id[:]}
+	if stored, exists := db.data[device.Id]; exists {
+		if stored.OrganizationId != device.OrganizationId {
+			return ErrExists
+		}
+		previous = db.states[device.Id]
+		if state.Counter <= previous.Counter {
+			return fmt.Errorf("%w: restoring counter %d, device is at %d", domain.ErrStaleState, state.Counter, previous.Counter)
+		}
+	}
+	var transactions []domain.Transaction
+	for _, transaction := range journal {
+		if transaction.Counter >= previous.Counter {
+			transactions = append(transactions, transaction)
+		}
+	}
+	if err := domain.VerifyContinuation(previous, device, transactions); err != nil {
+		return err
+	}
+	lastSig := previous.LastSig
+	if len(transactions) > 0 {
+		lastSig = transactions[len(transactions)-1].Signature
+	}
+	if previous.Counter+uint64(len(transactions)) != state.Counter || !bytes.Equal(lastSig, state.LastSig) {
+		return fmt.Errorf("%w: journal of the backup ends before counter %d", domain.ErrBrokenChain, state.Counter)
+	}
+
+	state.Version = previous.Version + uint64(len(transactions))
+	if r.Device != nil {
+		r.Device.Version = state.Version
+		imported := &importRecord{Device: *r.Device, Transactions: make([]transactionRecord, len(transactions))}
+		for i, transaction := range transactions {
+			imported.Transactions[i] = *newTransactionRecord(transaction)
+		}
+		r = record{Import: imported}
+	}
+	if err := db.log(r); err != nil {
+		return err
+	}
+	db.data[device.Id] = device
+	db.states[device.Id] = state
+	db.transactions[device.Id] = append(db.transactions[device.Id], transactions...)
+	delete(db.migrated, device.Id)
+	db.imported[device.Id] = state.Counter
+	return nil
 }
 
-// Get retrieves the device associated with the specified key.
-func (db *InMemoryDB) Get(key uuid.UUID) (*domain.SignatureDevice, bool) {
+// RecordKey stores the key of a device after a rotation together with the public keys of its previous versions.
+// Migrated devices are refused with domain.ErrMigrated. It implements domain.KeyRecorder.
+func (db *InMemoryDB) RecordKey(deviceId uuid.UUID, signer crypto.Signer, retiredKeys []gocrypto.PublicKey) error {
+	var r record
+	if db.wal != nil {
+		privateKey, err := crypto.MarshalPrivateKey(signer)
+		if err != nil {
+			return err
+		}
+		encoded, err := crypto.MarshalPublicKeys(retiredKeys)
+		if err != nil {
+			return err
+		}
+		r.Key = &keyRecord{DeviceId: deviceId, Algorithm: signer.GetAlgorithm(), PrivateKey: privateKey, RetiredKeys: encoded}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, exists := db.states[deviceId]; !exists {
+		return ErrNotFound
+	}
+	if db.migrated[deviceId] {
+		return fmt.Errorf("%w: device %s", domain.ErrMigrated, deviceId)
+	}
+	return db.log(r)
+}
+
+// MigrateDevice marks a device as migrated to another instance, provided its state still has the given version.
+// The device signs no more transactions until a later state of it is imported again.
+func (db *InMemoryDB) MigrateDevice(deviceId uuid.UUID, version uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	state, exists := db.states[deviceId]
+	if !exists {
+		return ErrNotFound
+	}
+	if db.migrated[deviceId] {
+		return fmt.Errorf("%w: device %s", domain.ErrMigrated, deviceId)
+	}
+	if db.suspended[deviceId] {
+		return fmt.Errorf("%w: device %s", domain.ErrSuspended, deviceId)
+	}
+	if state.Version != version {
+		return fmt.Errorf("%w: device %s is at version %d", domain.ErrVersionConflict, deviceId, state.Version)
+	}
+	if err := db.log(record{Migration: &migrationRecord{DeviceId: deviceId}}); err != nil {
+		return err
+	}
+	db.migrated[deviceId] = true
+	return nil
+}
+
+// SuspendDevice suspends a device, or resumes it if suspended is false. A suspended device signs no transactions
+// and cannot be migrated, but its key can still be rotated, e.g. after it was compromised.
+func (db *InMemoryDB) SuspendDevice(deviceId uuid.UUID, suspended bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, exists := db.states[deviceId]; !exists {
+		return ErrNotFound
+	}
+	if db.suspended[deviceId] == suspended {
+		return nil
+	}
+	if err := db.log(record{Suspension: &suspensionRecord{DeviceId: deviceId, Suspended: suspended}}); err != nil {
+		return err
+	}
+	if suspended {
+		db.suspended[deviceId] = true
+	} else {
+		delete(db.suspended, deviceId)
+	}
+	return nil
+}
+
+// Suspended reports whether a device is suspended.
+func (db *InMemoryDB) Suspended(deviceId uuid.UUID) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.suspended[deviceId]
+}
+
+// Get retrieves the device associated with the specified key, if it belongs to the organization.
+func (db *InMemoryDB) Get(organizationId uuid.UUID, key uuid.UUID) (*domain.SignatureDevice, bool) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 	value, ok := db.data[key]
-	return value, ok
+	if !ok || value.OrganizationId != organizationId {
+		return nil, false
+	}
+	return value, true
 }
 
-// GetAll retrieves all devices.
-func (db *InMemoryDB) GetAll() []*domain.SignatureDevice {
+// GetAll retrieves all devices of the organization.
+func (db *InMemoryDB) GetAll(organizationId uuid.UUID) []*domain.SignatureDevice {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
 	var allDevices []*domain.SignatureDevice
 	for _, value := range db.data {
-		allDevices = append(allDevices, value)
+		if value.OrganizationId == organizationId {
+			allDevices = append(allDevices, value)
+		}
 	}
 
 	return allDevices
 }
+
+// ErrNotFound is returned for devices that are not stored.
+var ErrNotFound = errors.New("device not found")
+
+// CommitTransaction records a single transaction, see CommitTransactions.
+func (db *InMemoryDB) CommitTransaction(transaction domain.Transaction, version uint64) (uint64, error) {
+	return db.CommitTransactions([]domain.Transaction{transaction}, version)
+}
+
+// CommitTransactions records consecutive transactions in the journal of their device and advances the committed
+// state of the device past the last of them, if the state still has the given version and the transactions continue
+// its counter. Transactions of leased devices must carry the fencing token of the current lease, migrated
+// devices are refused with domain.ErrMigrated and suspended ones with domain.ErrSuspended. It implements
+// domain.Journal. With a write-ahead log, the transactions are durable when CommitTransactions returns; they are
+// logged as one record, so a crash never keeps only some of them.
+func (db *InMemoryDB) CommitTransactions(transactions []domain.Transaction, version uint64) (uint64, error) {
+	if len(transactions) == 0 {
+		return 0, domain.ErrEmptyBatch
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	deviceId := transactions[0].DeviceId
+	state, exists := db.states[deviceId]
+	if !exists {
+		return 0, ErrNotFound
+	}
+	if db.migrated[deviceId] {
+		return 0, fmt.Errorf("%w: device %s", domain.ErrMigrated, deviceId)
+	}
+	if db.suspended[deviceId] {
+		return 0, fmt.Errorf("%w: device %s", domain.ErrSuspended, deviceId)
+	}
+	lease, leased := db.leases[deviceId]
+	for i, transaction := range transactions {
+		if leased && transaction.FencingToken != lease.Token {
+			return 0, fmt.Errorf("%w: token %d, current lease has token %d", domain.ErrFenced, transaction.FencingToken, lease.Token)
+		}
+		if state.Version != version || transaction.DeviceId != deviceId || transaction.Counter != state.Counter+uint64(i) {
+			return 0, fmt.Errorf("%w: device %s is at version %d", domain.ErrVersionConflict, deviceId, state.Version)
+		}
+	}
+
+	r := record{Transaction: newTransactionRecord(transactions[0])}
+	if len(transactions) > 1 {
+		r = record{Batch: make([]transactionRecord, 0, len(transactions))}
+		for _, transaction := range transactions {
+			r.Batch = append(r.Batch, *newTransactionRecord(transaction))
+		}
+	}
+	if err := db.log(r); err != nil {
+		return 0, err
+	}
+	last := transactions[len(transactions)-1]
+	version += uint64(len(transactions))
+	db.transactions[deviceId] = append(db.transactions[deviceId], transactions...)
+	db.states[deviceId] = domain.DeviceState{Counter: last.Counter + 1, LastSig: last.Signature, Version: version}
+	return version, nil
+}
+
+// DeviceState returns the committed state of a device. It implements domain.Journal.
+func (db *InMemoryDB) DeviceState(deviceId uuid.UUID) (domain.DeviceState, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	state, exists := db.states[deviceId]
+	if !exists {
+		return domain.DeviceState{}, ErrNotFound
+	}
+	return state, nil
+}
+
+// AcquireLease renews the lease of owner on a device or takes over an expired lease with a new fencing token.
+// It implements domain.LeaseStore. Leases are coordination state and are not written to the write-ahead log, but
+// new fencing tokens are, so that a lease taken after a restart still fences off the owners from before.
+func (db *InMemoryDB) AcquireLease(deviceId uuid.UUID, owner string, address string, ttl time.Duration) (domain.Lease, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	lease, exists := db.leases[deviceId]
+	if exists && now.Before(lease.ExpiresAt) && lease.Owner != owner {
+		return lease, domain.ErrLeaseHeld
+	}
+	if !exists || !now.Before(lease.ExpiresAt) {
+		// A new owner, or the previous owner after its lease expired: fence off everything signed under the old token.
+		token := db.fencingTokens[deviceId] + 1
+		if err := db.log(record{Fencing: &fencingRecord{DeviceId: deviceId, Token: token}}); err != nil {
+			return domain.Lease{}, err
+		}
+		db.fencingTokens[deviceId] = token
+		lease.Token = token
+	}
+	lease.DeviceId = deviceId
+	lease.Owner = owner
+	lease.Address = address
+	lease.ExpiresAt = now.Add(ttl)
+	db.leases[deviceId] = lease
+	return lease, nil
+}
+
+// ReleaseLeases expires all leases of owner. The fencing tokens are kept, so the next owner gets a higher one.
+// It implements domain.LeaseStore.
+func (db *InMemoryDB) ReleaseLeases(owner string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for id, lease := range db.leases {
+		if lease.Owner == owner {
+			lease.ExpiresAt = time.Time{}
+			db.leases[id] = lease
+		}
+	}
+	return nil
+}
+
+// GetTransactions retrieves the journal of a device in counter order, if the device belongs to the organization.
+func (db *InMemoryDB) GetTransactions(organizationId uuid.UUID, deviceId uuid.UUID) []domain.Transaction {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if device, ok := db.data[deviceId]; !ok || device.OrganizationId != organizationId {
+		return nil
+	}
+	transactions := make([]domain.Transaction, len(db.transactions[deviceId]))
+	copy(transactions, db.transactions[deviceId])
+	return transactions
+}
+
+// GetTransaction retrieves the transaction of a device with the given counter, if the device belongs to the organization.
+func (db *InMemoryDB) GetTransaction(organizationId uuid.UUID, deviceId uuid.UUID, counter uint64) (domain.Transaction, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if device, ok := db.data[deviceId]; !ok || device.OrganizationId != organizationId {
+		return domain.Transaction{}, false
+	}
+	i, found := db.transactionIndex(deviceId, counter)
+	if !found {
+		return domain.Transaction{}, false
+	}
+	return db.transactions[deviceId][i], true
+}
+
+// transactionIndex returns the position of a transaction in the journal of its device, which is ordered by counter
+// but need not start at 0. The caller must hold db.mu.
+func (db *InMemoryDB) transactionIndex(deviceId uuid.UUID, counter uint64) (int, bool) {
+	transactions := db.transactions[deviceId]
+	i := sort.Search(len(transactions), func(i int) bool {
+		return transactions[i].Counter >= counter
+	})
+	return i, i < len(transactions) && transactions[i].Counter == counter
+}
+
+// ErrTransactionNotFound is returned for transactions that are not in the journal.
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// AttachTimestamp stores a time-stamp token with the transactions it covers, which must have been committed.
+func (db *InMemoryDB) AttachTimestamp(deviceId uuid.UUID, timestamp domain.Timestamp) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	first, last, err := db.timestampedRange(deviceId, &timestamp)
+	if err != nil {
+		return err
+	}
+	if err := db.log(record{Timestamp: newTimestampRecord(deviceId, &timestamp)}); err != nil {
+		return err
+	}
+	db.setTimestamp(deviceId, &timestamp, first, last)
+	return nil
+}
+
+// attachTimestamp implements AttachTimestamp without logging, for replaying the log.
+func (db *InMemoryDB) attachTimestamp(deviceId uuid.UUID, timestamp *domain.Timestamp) error {
+	first, last, err := db.timestampedRange(deviceId, timestamp)
+	if err != nil {
+		return err
+	}
+	db.setTimestamp(deviceId, timestamp, first, last)
+	return nil
+}
+
+// timestampedRange returns the positions of the first and last transaction covered by a token in the journal of
+// the device. The caller must hold db.mu.
+func (db *InMemoryDB) timestampedRange(deviceId uuid.UUID, timestamp *domain.Timestamp) (int, int, error) {
+	transactions := db.transactions[deviceId]
+	first, found := db.transactionIndex(deviceId, timestamp.FirstCounter)
+	last := first + timestamp.Count - 1
+	if !found || timestamp.Count < 1 || last >= len(transactions) ||
+		transactions[last].Counter != timestamp.FirstCounter+uint64(timestamp.Count)-1 {
+		return 0, 0, fmt.Errorf("%w: transactions %d to %d of device %s", ErrTransactionNotFound, timestamp.FirstCounter,
+			timestamp.FirstCounter+uint64(timestamp.Count)-1, deviceId)
+	}
+	return first, last, nil
+}
+
+// setTimestamp stores a token with the transactions at positions first to last. The caller must hold db.mu.
+func (db *InMemoryDB) setTimestamp(deviceId uuid.UUID, timestamp *domain.Timestamp, first int, last int) {
+	for i := first; i <= last; i++ {
+		db.transactions[deviceId][i].Timestamp = timestamp
+	}
+}
+
+// PendingAnchors returns the transactions of all devices that are not anchored yet, ordered by device id and counter.
+func (db *InMemoryDB) PendingAnchors() []domain.Transaction {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	deviceIds := make([]uuid.UUID, 0, len(db.transactions))
+	for id, transactions := range db.transactions {
+		if db.anchoredCount[id] < len(transactions) {
+			deviceIds = append(deviceIds, id)
+		}
+	}
+	sort.Slice(deviceIds, func(i, j int) bool {
+		return bytes.Compare(deviceIds[i][:], deviceIds[j][:]) < 0
+	})
+	var pending []domain.Transaction
+	for _, id := range deviceIds {
+		pending = append(pending, db.transactions[id][db.anchoredCount[id]:]...)
+	}
+	return pending
+}
+
+// AppendAnchor appends transactions to the anchoring tree together with the tree head over all anchored
+// transactions. Every device's transactions must continue after its anchored ones, in counter order.
+func (db *InMemoryDB) AppendAnchor(transactions []domain.Transaction, head domain.TreeHead) error {
+	refs := make([]domain.AnchorRef, len(transactions))
+	for i, transaction := range transactions {
+		refs[i] = domain.AnchorRef{DeviceId: transaction.DeviceId, Counter: transaction.Counter}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if head.Size != uint64(len(db.anchored)+len(refs)) {
+		return fmt.Errorf("tree head of size %d does not cover %d anchored transactions", head.Size, len(db.anchored)+len(refs))
+	}
+	if err := db.checkAnchorable(refs); err != nil {
+		return err
+	}
+	if err := db.log(record{Anchor: &anchorRecord{Transactions: newAnchorRefRecords(refs), TreeHead: newTreeHeadRecord(head)}}); err != nil {
+		return err
+	}
+	db.anchor(refs)
+	db.treeHeads = append(db.treeHeads, head)
+	return nil
+}
+
+// checkAnchorable checks that refs continue the anchored transactions of their devices. The caller must hold db.mu.
+func (db *InMemoryDB) checkAnchorable(refs []domain.AnchorRef) error {
+	next := make(map[uuid.UUID]int)
+	for _, ref := range refs {
+		position, seen := next[ref.DeviceId]
+		if !seen {
+			position = db.anchoredCount[ref.DeviceId]
+		}
+		transactions := db.transactions[ref.DeviceId]
+		if position >= len(transactions) || transactions[position].Counter != ref.Counter {
+			return fmt.Errorf("%w: transaction %d of device %s is not the next to anchor", ErrTransactionNotFound, ref.Counter, ref.DeviceId)
+		}
+		next[ref.DeviceId] = position + 1
+	}
+	return nil
+}
+
+// appendAnchored appends refs to the anchored transactions after checking them, for replaying the log.
+func (db *InMemoryDB) appendAnchored(refs []domain.AnchorRef) error {
+	if err := db.checkAnchorable(refs); err != nil {
+		return err
+	}
+	db.anchor(refs)
+	return nil
+}
+
+// anchor appends refs that were checked to the anchored transactions. The caller must hold db.mu.
+func (db *InMemoryDB) anchor(refs []domain.AnchorRef) {
+	for _, ref := range refs {
+		db.anchored = append(db.anchored, ref)
+		db.anchoredCount[ref.DeviceId]++
+	}
+}
+
+// Anchors returns the anchored transactions in the order of the anchoring tree and all tree heads published so far.
+func (db *InMemoryDB) Anchors() ([]domain.Transaction, []domain.TreeHead) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	transactions := make([]domain.Transaction, len(db.anchored))
+	for i, ref := range db.anchored {
+		position, _ := db.transactionIndex(ref.DeviceId, ref.Counter)
+		transactions[i] = db.transactions[ref.DeviceId][position]
+	}
+	heads := make([]domain.TreeHead, len(db.treeHeads))
+	copy(heads, db.treeHeads)
+	return transactions, heads
+}
+
+// SetCertificateChain replaces the certificate chain of a device.
+func (db *InMemoryDB) SetCertificateChain(deviceId uuid.UUID, chain domain.CertificateChain) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, exists := db.data[deviceId]; !exists {
+		return ErrNotFound
+	}
+	if err := db.log(record{Certificate: &certificateRecord{DeviceId: deviceId, Chain: chain.Chain, Attached: chain.Attached}}); err != nil {
+		return err
+	}
+	db.certificates[deviceId] = chain
+	return nil
+}
+
+// GetCertificateChain retrieves the certificate chain of a device, if the device belongs to the organization and
+// was certified.
+func (db *InMemoryDB) GetCertificateChain(organizationId uuid.UUID, deviceId uuid.UUID) (domain.CertificateChain, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if device, ok := db.data[deviceId]; !ok || device.OrganizationId != organizationId {
+		return domain.CertificateChain{}, false
+	}
+	chain, ok := db.certificates[deviceId]
+	return chain, ok
+}
+
+// AppendAuditEntry records an entry of the audit log, see audit.Store.
+func (db *InMemoryDB) AppendAuditEntry(entry audit.Entry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.log(record{Audit: &entry}); err != nil {
+		return err
+	}
+	db.auditEntries = append(db.auditEntries, entry)
+	return nil
+}
+
+// AuditEntries returns all recorded entries of the audit log in the order they were appended.
+func (db *InMemoryDB) AuditEntries() []audit.Entry {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	entries := make([]audit.Entry, len(db.auditEntries))
+	copy(entries, db.auditEntries)
+	return entries
+}
+
+// PutAPIKey stores an API key after it was created or changed. It implements auth.Store.
+func (db *InMemoryDB) PutAPIKey(key auth.StoredKey) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	r := newAPIKeyRecord(key)
+	if err := db.log(record{APIKey: &r}); err != nil {
+		return err
+	}
+	db.apiKeys[key.Id] = key
+	return nil
+}
+
+// DeleteAPIKey removes a revoked API key. It implements auth.Store.
+func (db *InMemoryDB) DeleteAPIKey(id uuid.UUID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.log(record{APIKeyDeletion: &apiKeyDeletionRecord{Id: id}}); err != nil {
+		return err
+	}
+	delete(db.apiKeys, id)
+	return nil
+}
+
+// APIKeys returns all stored API keys. It implements auth.Store.
+func (db *InMemoryDB) APIKeys() []auth.StoredKey {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	keys := make([]auth.StoredKey, 0, len(db.apiKeys))
+	for _, key := range db.apiKeys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// SetOrganization sets an organization in the in-memory database.
+func (db *InMemoryDB) SetOrganization(organization *domain.Organization) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.log(record{Organization: &organizationRecord{organization.Id, organization.Name}}); err != nil {
+		return err
+	}
+	db.organizations[organization.Id] = organization
+	return nil
+}
+
+// GetOrganization retrieves the organization with the specified id.
+func (db *InMemoryDB) GetOrganization(id uuid.UUID) (*domain.Organization, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	value, ok := db.organizations[id]
+	return value, ok
+}
+
+// GetOrganizations retrieves all organizations.
+func (db *InMemoryDB) GetOrganizations() []*domain.Organization {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var organizations []*domain.Organization
+	for _, value := range db.organizations {
+		organizations = append(organizations, value)
+	}
+
+	return organizations
+}
+
+// Ping checks that the database is available.
+func (db *InMemoryDB) Ping(ctx context.Context) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return ctx.Err()
+}
+
+// Close releases the database. With a write-ahead log, a final snapshot is written
+// so that the next start does not need to replay the log.
+func (db *InMemoryDB) Close() error {
+	db.snapshotMu.Lock()
+	defer db.snapshotMu.Unlock()
+	if err := db.Ping(context.Background()); err != nil {
+		return nil
+	}
+	if db.stopSnapshots != nil {
+		close(db.stopSnapshots)
+	}
+	snapshotErr := db.snapshot()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.closed = true
+	if db.wal != nil {
+		return errors.Join(snapshotErr, db.wal.close())
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package persistence
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+func TestInMemoryDB_CommitTransactionCompareAndSwap(t *testing.T) {
+	db := GetInMemoryDB()
+	signer, _ := crypto.SignerFactory("ECC")
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "", signer, domain.WithJournal(db))
+	db.Set(device)
+
+	transaction := domain.Transaction{DeviceId: device.Id, Counter: 0, Signature: []byte("first")}
+	version, err := db.CommitTransaction(transaction, 0)
+	if err != nil || version != 1 {
+		t.Fatal("Commit with the current version must succeed, got", version, err)
+	}
+	if _, err := db.CommitTransaction(transaction, 0); !errors.Is(err, domain.ErrVersionConflict) {
+		t.Error("Commit with a stale version must conflict, got", err)
+	}
+	transaction.Counter = 5
+	if _, err := db.CommitTransaction(transaction, 1); !errors.Is(err, domain.ErrVersionConflict) {
+		t.Error("Commit that skips counters must conflict, got", err)
+	}
+}
+
+// Two replicas share the database, but each holds its own instance of the device with its own lock.
+func TestInMemoryDB_ReplicasNeverReuseCounters(t *testing.T) {
+	db := GetInMemoryDB()
+	signer, _ := crypto.SignerFactory("RSA")
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "", signer, domain.WithJournal(db))
+	db.Set(device)
+
+	replica := domain.RestoreSignatureDevice(device.Id, device.OrganizationId, device.Label, signer, device.State(), domain.WithJournal(db))
+
+	var wg sync.WaitGroup
+	for _, instance := range []*domain.SignatureDevice{device, replica} {
+		wg.Add(1)
+		go func(instance *domain.SignatureDevice) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				instance.SignData([]byte("data"))
+			}
+		}(instance)
+	}
+	wg.Wait()
+
+	transactions := db.GetTransactions(domain.DefaultOrganizationId, device.Id)
+	for i, transaction := range transactions {
+		if transaction.Counter != uint64(i) {
+			t.Fatal("Counter issued twice or skipped at", i, "got", transaction.Counter)
+		}
+	}
+	state, _ := db.DeviceState(device.Id)
+	if state.Counter != uint64(len(transactions)) || state.Version != uint64(len(transactions)) {
+		t.Error("Committed state does not match the journal:", state.Counter, state.Version, len(transactions))
+	}
+}
+
+func TestInMemoryDB_Leases(t *testing.T) {
+	db := GetInMemoryDB()
+	signer, _ := crypto.SignerFactory("ECC")
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "", signer, domain.WithJournal(db))
+	db.Set(device)
+
+	lease, err := db.AcquireLease(device.Id, "a", "http://a", time.Hour)
+	if err != nil || lease.Token != 1 {
+		t.Fatal("Free device must be leased, got", lease, err)
+	}
+	if renewed, err := db.AcquireLease(device.Id, "a", "http://a", time.Hour); err != nil || renewed.Token != 1 || !renewed.ExpiresAt.After(lease.ExpiresAt) {
+		t.Error("Owner must renew its lease with the same token, got", renewed, err)
+	}
+	if held, err := db.AcquireLease(device.Id, "b", "http://b", time.Hour); !errors.Is(err, domain.ErrLeaseHeld) || held.Owner != "a" {
+		t.Error("Lease held by another replica must not be acquired, got", held, err)
+	}
+
+	db.ReleaseLeases("a")
+	takeover, err := db.AcquireLease(device.Id, "b", "http://b", time.Hour)
+	if err != nil || takeover.Token != 2 {
+		t.Fatal("Released lease must be taken over with a new token, got", takeover, err)
+	}
+	transaction := domain.Transaction{DeviceId: device.Id, Counter: 0, Signature: []byte("late"), FencingToken: lease.Token}
+	if _, err := db.CommitTransaction(transaction, 0); !errors.Is(err, domain.ErrFenced) {
+		t.Error("Commit of the previous owner must be fenced, got", err)
+	}
+	transaction.FencingToken = takeover.Token
+	if _, err := db.CommitTransaction(transaction, 0); err != nil {
+		t.Error("Commit of the owner must succeed, got", err)
+	}
+}
+
+func TestInMemoryDB_MigrateAndImportDevice(t *testing.T) {
+	source := GetInMemoryDB()
+	signer, _ := crypto.SignerFactory("ECC")
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "", signer, domain.WithJournal(source))
+	source.Set(device)
+	device.SignData([]byte("first"))
+	device.SignData([]byte("second"))
+	state, _ := source.DeviceState(device.Id)
+	journal := source.GetTransactions(domain.DefaultOrganizationId, device.Id)
+
+	if err := source.MigrateDevice(device.Id, state.Version-1); !errors.Is(err, domain.ErrVersionConflict) {
+		t.Error("Device that signed since its state was read must not be migrated, got", err)
+	}
+	if err := source.MigrateDevice(device.Id, state.Version); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := device.SignData([]byte("late")); !errors.Is(err, domain.ErrMigrated) {
+		t.Error("Migrated device must not sign, got", err)
+	}
+
+	target := GetInMemoryDB()
+	restored := domain.RestoreSignatureDevice(device.Id, device.OrganizationId, device.Label, signer, state, domain.WithJournal(target))
+	if err := target.ImportDevice(restored, journal[1:]); !errors.Is(err, domain.ErrBrokenChain) {
+		t.Error("Import with a gap in the journal must be rejected, got", err)
+	}
+	if err := target.ImportDevice(restored, journal); err != nil {
+		t.Fatal(err)
+	}
+	if transactions := target.GetTransactions(domain.DefaultOrganizationId, device.Id); len(transactions) != 2 {
+		t.Error("Import must store the journal, got", len(transactions), "transactions")
+	}
+	if err := target.ImportDevice(restored, journal); !errors.Is(err, domain.ErrStaleState) {
+		t.Error("Import of the same state again must be rejected, got", err)
+	}
+	if _, _, err := restored.SignData([]byte("third")); err != nil {
+		t.Error("Imported device must sign, got", err)
+	}
+}
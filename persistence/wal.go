@@ -0,0 +1,571 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/auth"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// ErrCorrupted is returned when the write-ahead log or the snapshot cannot be replayed.
+var ErrCorrupted = errors.New("write-ahead log corrupted")
+
+const (
+	snapshotFile  = "snapshot.json"
+	segmentPrefix = "wal-"
+	segmentSuffix = ".log"
+)
+
+// record is one change to the database. Exactly one of the pointers or Batch is set.
+type record struct {
+	Sequence     uint64              `json:"seq"`
+	Organization *organizationRecord `json:"organization,omitempty"`
+	Device       *deviceRecord       `json:"device,omitempty"`
+	Transaction  *transactionRecord  `json:"transaction,omitempty"`
+	// Batch holds consecutive transactions of one device that are only valid together.
+	Batch []transactionRecord `json:"batch,omitempty"`
+	// Timestamp attaches a time-stamp token to transactions recorded before.
+	Timestamp *timestampRecord `json:"timestamp,omitempty"`
+	// Anchor appends transactions recorded before to the anchoring tree.
+	Anchor *anchorRecord `json:"anchor,omitempty"`
+	// Certificate replaces the certificate chain of a device recorded before.
+	Certificate *certificateRecord `json:"certificate,omitempty"`
+	// Audit appends an entry to the audit log.
+	Audit *audit.Entry `json:"audit,omitempty"`
+	// Fencing raises the highest fencing token issued for a device lease.
+	Fencing *fencingRecord `json:"fencing,omitempty"`
+	// Migration marks a device as migrated to another instance.
+	Migration *migrationRecord `json:"migration,omitempty"`
+	// Import stores a device restored from a backup with the transactions that continue its journal.
+	Import *importRecord `json:"import,omitempty"`
+	// Key replaces the key of a device recorded before after a rotation.
+	Key *keyRecord `json:"key,omitempty"`
+	// Suspension suspends or resumes a device.
+	Suspension *suspensionRecord `json:"suspension,omitempty"`
+	// APIKey stores an API key after it was created or changed.
+	APIKey *apiKeyRecord `json:"api_key,omitempty"`
+	// APIKeyDeletion revokes an API key recorded before.
+	APIKeyDeletion *apiKeyDeletionRecord `json:"api_key_deletion,omitempty"`
+}
+
+type organizationRecord struct {
+	Id   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+// deviceRecord holds a device with its key pair and state. It is written on creation and whenever
+// the state of a device is replaced, e.g. by a restore.
+type deviceRecord struct {
+	Id               uuid.UUID `json:"id"`
+	OrganizationId   uuid.UUID `json:"organization_id"`
+	Label            string    `json:"label"`
+	Algorithm        string    `json:"algorithm"`
+	PrivateKey       []byte    `json:"private_key"`
+	SignatureCounter uint64    `json:"signature_counter"`
+	LastSignature    []byte    `json:"last_signature"`
+	Version          uint64    `json:"version"`
+	DataFormat       string    `json:"data_format,omitempty"`
+	// SignatureVersion is the encoding of LastSignature. Records without it hold the base64 text returned by
+	// signers before signatures were raw bytes.
+	SignatureVersion int `json:"signature_version,omitempty"`
+	// RetiredKeys holds the PKIX encoded public keys of the previous key versions, oldest first.
+	RetiredKeys [][]byte `json:"retired_keys,omitempty"`
+}
+
+// transactionRecord is a signature of a device. It advances the counter of the device to Counter+1
+// and increments the version of its state.
+type transactionRecord struct {
+	OrganizationId uuid.UUID `json:"organization_id"`
+	DeviceId       uuid.UUID `json:"device_id"`
+	Counter        uint64    `json:"counter"`
+	SignedData     []byte    `json:"signed_data"`
+	Signature      []byte    `json:"signature"`
+	// SignatureVersion is the version of the transaction. Records without it were written before signatures
+	// were raw bytes and hold the signature as base64 text.
+	SignatureVersion int `json:"signature_version,omitempty"`
+	// Timestamp is the token covering the transaction. Tokens obtained after a transaction was logged are
+	// attached by a record of their own.
+	Timestamp *timestampRecord `json:"timestamp,omitempty"`
+	// KeyVersion is the version of the device key that signed the transaction, 0 before keys were rotated.
+	KeyVersion int `json:"key_version,omitempty"`
+}
+
+// timestampRecord is a time-stamp token over transactions FirstCounter to FirstCounter+Count-1 of a device.
+type timestampRecord struct {
+	DeviceId     uuid.UUID `json:"device_id"`
+	Token        []byte    `json:"token"`
+	FirstCounter uint64    `json:"first_counter"`
+	Count        int       `json:"count"`
+}
+
+func newTimestampRecord(deviceId uuid.UUID, timestamp *domain.Timestamp) *timestampRecord {
+	if timestamp == nil {
+		return nil
+	}
+	return &timestampRecord{DeviceId: deviceId, Token: timestamp.Token, FirstCounter: timestamp.FirstCounter, Count: timestamp.Count}
+}
+
+func (r *timestampRecord) timestamp() *domain.Timestamp {
+	return &domain.Timestamp{Token: r.Token, FirstCounter: r.FirstCounter, Count: r.Count}
+}
+
+// anchorRecord appends transactions to the anchoring tree and publishes the tree head over all anchored transactions.
+type anchorRecord struct {
+	Transactions []anchorRefRecord `json:"transactions"`
+	TreeHead     treeHeadRecord    `json:"tree_head"`
+}
+
+type anchorRefRecord struct {
+	DeviceId uuid.UUID `json:"device_id"`
+	Counter  uint64    `json:"counter"`
+}
+
+type treeHeadRecord struct {
+	Size      uint64    `json:"size"`
+	RootHash  []byte    `json:"root_hash"`
+	Timestamp time.Time `json:"timestamp"`
+	Signature []byte    `json:"signature"`
+}
+
+func newAnchorRefRecords(refs []domain.AnchorRef) []anchorRefRecord {
+	records := make([]anchorRefRecord, len(refs))
+	for i, ref := range refs {
+		records[i] = anchorRefRecord{DeviceId: ref.DeviceId, Counter: ref.Counter}
+	}
+	return records
+}
+
+func anchorRefs(records []anchorRefRecord) []domain.AnchorRef {
+	refs := make([]domain.AnchorRef, len(records))
+	for i, record := range records {
+		refs[i] = domain.AnchorRef{DeviceId: record.DeviceId, Counter: record.Counter}
+	}
+	return refs
+}
+
+func newTreeHeadRecord(head domain.TreeHead) treeHeadRecord {
+	return treeHeadRecord{Size: head.Size, RootHash: head.RootHash, Timestamp: head.Timestamp, Signature: head.Signature}
+}
+
+func (r treeHeadRecord) treeHead() domain.TreeHead {
+	return domain.TreeHead{Size: r.Size, RootHash: r.RootHash, Timestamp: r.Timestamp, Signature: r.Signature}
+}
+
+// certificateRecord is the DER encoded certificate chain of a device, its own certificate first.
+type certificateRecord struct {
+	DeviceId uuid.UUID `json:"device_id"`
+	Chain    [][]byte  `json:"chain"`
+	Attached bool      `json:"attached,omitempty"`
+}
+
+func (r certificateRecord) certificateChain() domain.CertificateChain {
+	return domain.CertificateChain{Chain: r.Chain, Attached: r.Attached}
+}
+
+// fencingRecord is the highest fencing token issued for leases on a device. Leases themselves are not logged; after
+// a restart the next lease continues above Token, so replicas holding a lease from before are still fenced off.
+type fencingRecord struct {
+	DeviceId uuid.UUID `json:"device_id"`
+	Token    uint64    `json:"token"`
+}
+
+// keyRecord is the key of a device after a rotation, with the public keys of all previous versions.
+type keyRecord struct {
+	DeviceId    uuid.UUID `json:"device_id"`
+	Algorithm   string    `json:"algorithm"`
+	PrivateKey  []byte    `json:"private_key"`
+	RetiredKeys [][]byte  `json:"retired_keys"`
+}
+
+// migrationRecord marks a device that was backed up to move to another instance. It no longer signs until a
+// later state of the device is imported again.
+type migrationRecord struct {
+	DeviceId uuid.UUID `json:"device_id"`
+}
+
+// suspensionRecord suspends a device, or resumes it if Suspended is false.
+type suspensionRecord struct {
+	DeviceId  uuid.UUID `json:"device_id"`
+	Suspended bool      `json:"suspended"`
+}
+
+// apiKeyRecord is an API key with the SHA-256 hash of its secret; the secret itself is never stored.
+type apiKeyRecord struct {
+	Id             uuid.UUID   `json:"id"`
+	OrganizationId uuid.UUID   `json:"organization_id"`
+	Name           string      `json:"name,omitempty"`
+	Permissions    []string    `json:"permissions"`
+	AllDevices     bool        `json:"all_devices,omitempty"`
+	DeviceIds      []uuid.UUID `json:"device_ids,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+	SecretHash     []byte      `json:"secret_hash"`
+}
+
+func newAPIKeyRecord(key auth.StoredKey) apiKeyRecord {
+	r := apiKeyRecord{
+		Id:             key.Id,
+		OrganizationId: key.OrganizationId,
+		Name:           key.Name,
+		AllDevices:     key.AllDevices,
+		DeviceIds:      key.DeviceIds,
+		CreatedAt:      key.CreatedAt,
+		SecretHash:     key.SecretHash[:],
+	}
+	for _, permission := range key.Permissions {
+		r.Permissions = append(r.Permissions, string(permission))
+	}
+	return r
+}
+
+func (r apiKeyRecord) storedKey() (auth.StoredKey, error) {
+	key := auth.StoredKey{APIKey: auth.APIKey{
+		Id:             r.Id,
+		OrganizationId: r.OrganizationId,
+		Name:           r.Name,
+		AllDevices:     r.AllDevices,
+		DeviceIds:      r.DeviceIds,
+		CreatedAt:      r.CreatedAt,
+	}}
+	for _, name := range r.Permissions {
+		permission, err := auth.ParsePermission(name)
+		if err != nil {
+			return auth.StoredKey{}, fmt.Errorf("api key %s: %v", r.Id, err)
+		}
+		key.Permissions = append(key.Permissions, permission)
+	}
+	if len(r.SecretHash) != len(key.SecretHash) {
+		return auth.StoredKey{}, fmt.Errorf("api key %s: secret hash has %d bytes", r.Id, len(r.SecretHash))
+	}
+	copy(key.SecretHash[:], r.SecretHash)
+	return key, nil
+}
+
+// apiKeyDeletionRecord revokes an API key.
+type apiKeyDeletionRecord struct {
+	Id uuid.UUID `json:"id"`
+}
+
+// importRecord is a device restored from a backup. Device holds the imported state, Transactions the transactions
+// between the previous state of the device, if it existed, and the imported state.
+type importRecord struct {
+	Device       deviceRecord        `json:"device"`
+	Transactions []transactionRecord `json:"transactions"`
+}
+
+// importedRecord is the highest counter a device was imported with.
+type importedRecord struct {
+	DeviceId uuid.UUID `json:"device_id"`
+	Counter  uint64    `json:"counter"`
+}
+
+// snapshot is the complete state of the database after the record with Sequence.
+type snapshot struct {
+	Sequence      uint64               `json:"seq"`
+	Organizations []organizationRecord `json:"organizations"`
+	Devices       []deviceRecord       `json:"devices"`
+	Transactions  []transactionRecord  `json:"transactions"`
+	// Anchored lists the anchored transactions in the order of the anchoring tree.
+	Anchored     []anchorRefRecord   `json:"anchored,omitempty"`
+	TreeHeads    []treeHeadRecord    `json:"tree_heads,omitempty"`
+	Certificates []certificateRecord `json:"certificates,omitempty"`
+	// AuditEntries lists the entries of the audit log in the order they were appended.
+	AuditEntries []audit.Entry `json:"audit_entries,omitempty"`
+	// FencingTokens holds the highest fencing token of every device that was ever leased.
+	FencingTokens []fencingRecord `json:"fencing_tokens,omitempty"`
+	// Migrated lists the devices migrated to another instance.
+	Migrated []uuid.UUID `json:"migrated,omitempty"`
+	// Imported holds the highest counter of every device imported from a backup.
+	Imported []importedRecord `json:"imported,omitempty"`
+	// Suspended lists the suspended devices.
+	Suspended []uuid.UUID `json:"suspended,omitempty"`
+	// APIKeys holds all API keys that were not revoked.
+	APIKeys []apiKeyRecord `json:"api_keys,omitempty"`
+}
+
+func newDeviceRecord(device *domain.SignatureDevice, state domain.DeviceState) (*deviceRecord, error) {
+	signer, _ := device.Key()
+	privateKey, err := crypto.MarshalPrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	retiredKeys, err := crypto.MarshalPublicKeys(device.RetiredKeys())
+	if err != nil {
+		return nil, err
+	}
+	return &deviceRecord{
+		Id:               device.Id,
+		OrganizationId:   device.OrganizationId,
+		Label:            device.Label,
+		Algorithm:        signer.GetAlgorithm(),
+		PrivateKey:       privateKey,
+		RetiredKeys:      retiredKeys,
+		SignatureCounter: state.Counter,
+		LastSignature:    state.LastSig,
+		Version:          state.Version,
+		DataFormat:       string(device.DataFormat),
+		SignatureVersion: int(domain.CurrentSignatureVersion),
+	}, nil
+}
+
+// dataFormat returns the data format of the device. Records without one were written before devices could
+// select a format and sign in v1.
+func (r *deviceRecord) dataFormat() domain.DataFormat {
+	if r.DataFormat == "" {
+		return domain.DataFormatV1
+	}
+	return domain.DataFormat(r.DataFormat)
+}
+
+// lastSignature returns the raw last signature of the device, decoding records without signature version.
+func (r *deviceRecord) lastSignature() ([]byte, error) {
+	if r.SignatureVersion != 0 {
+		return r.LastSignature, nil
+	}
+	lastSig, err := base64.StdEncoding.DecodeString(string(r.LastSignature))
+	if err != nil {
+		return nil, fmt.Errorf("%w: last signature of device %s: %v", ErrCorrupted, r.Id, err)
+	}
+	return lastSig, nil
+}
+
+func newTransactionRecord(transaction domain.Transaction) *transactionRecord {
+	version := transaction.SignatureVersion
+	if version == 0 {
+		version = domain.CurrentSignatureVersion
+	}
+	return &transactionRecord{
+		OrganizationId:   transaction.OrganizationId,
+		DeviceId:         transaction.DeviceId,
+		Counter:          transaction.Counter,
+		SignedData:       transaction.SignedData,
+		Signature:        transaction.Signature,
+		SignatureVersion: int(version),
+		Timestamp:        newTimestampRecord(transaction.DeviceId, transaction.Timestamp),
+		KeyVersion:       transaction.KeyVersion,
+	}
+}
+
+// transaction returns the transaction of the record. Records without signature version are migrated to
+// SignatureVersion1 transactions with decoded signatures.
+func (r *transactionRecord) transaction() (domain.Transaction, error) {
+	transaction := domain.Transaction{
+		OrganizationId:   r.OrganizationId,
+		DeviceId:         r.DeviceId,
+		Counter:          r.Counter,
+		SignedData:       r.SignedData,
+		Signature:        r.Signature,
+		SignatureVersion: domain.SignatureVersion(r.SignatureVersion),
+		KeyVersion:       r.KeyVersion,
+	}
+	if r.Timestamp != nil {
+		transaction.Timestamp = r.Timestamp.timestamp()
+	}
+	if r.SignatureVersion == 0 {
+		signature, err := base64.StdEncoding.DecodeString(string(r.Signature))
+		if err != nil {
+			return domain.Transaction{}, fmt.Errorf("%w: signature %d of device %s: %v", ErrCorrupted, r.Counter, r.DeviceId, err)
+		}
+		transaction.Signature = signature
+		transaction.SignatureVersion = domain.SignatureVersion1
+	}
+	return transaction, nil
+}
+
+// wal appends records to segment files in a directory. Every append is fsynced before it returns.
+// Each line holds the CRC-32 of a record followed by the record as JSON.
+type wal struct {
+	dir      string
+	file     *os.File
+	sequence uint64
+}
+
+func segmentName(firstSequence uint64) string {
+	return fmt.Sprintf("%s%020d%s", segmentPrefix, firstSequence, segmentSuffix)
+}
+
+// segments lists the segment files of dir in the order they were written.
+func segments(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"+segmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// openSegment starts a new segment whose first record will have the given sequence.
+func (w *wal) openSegment(firstSequence uint64) error {
+	file, err := os.OpenFile(filepath.Join(w.dir, segmentName(firstSequence)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(w.dir); err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	return nil
+}
+
+func (w *wal) append(r record) error {
+	r.Sequence = w.sequence + 1
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	entry := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(line), line)
+	if _, err := w.file.WriteString(entry); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.sequence = r.Sequence
+	return nil
+}
+
+// rotate closes the current segment and starts a new one. It returns the closed segments,
+// which only hold records up to the current sequence.
+func (w *wal) rotate() ([]string, error) {
+	closed, err := segments(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.file.Close(); err != nil {
+		return nil, err
+	}
+	return closed, w.openSegment(w.sequence + 1)
+}
+
+func (w *wal) close() error {
+	return w.file.Close()
+}
+
+// readSegment calls apply for every record of a segment. An incomplete record at the end of the
+// last segment is the result of a crash during an append that was never acknowledged; it is cut off.
+func readSegment(path string, last bool, apply func(record) error) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		r, parseErr := parseRecord(line, err == nil)
+		if parseErr != nil {
+			tail := err == io.EOF || isLastLine(reader)
+			if !last || !tail {
+				return fmt.Errorf("%w: %s at offset %d: %v", ErrCorrupted, filepath.Base(path), offset, parseErr)
+			}
+			if err := file.Truncate(offset); err != nil {
+				return err
+			}
+			return file.Sync()
+		}
+		if err := apply(r); err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func isLastLine(reader *bufio.Reader) bool {
+	_, err := reader.Peek(1)
+	return err == io.EOF
+}
+
+func parseRecord(line []byte, complete bool) (record, error) {
+	var r record
+	if !complete {
+		return r, errors.New("incomplete record")
+	}
+	checksum, content, found := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !found {
+		return r, errors.New("missing checksum")
+	}
+	expected, err := strconv.ParseUint(string(checksum), 16, 32)
+	if err != nil || uint32(expected) != crc32.ChecksumIEEE(content) {
+		return r, errors.New("checksum mismatch")
+	}
+	if err := json.Unmarshal(content, &r); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// writeSnapshot atomically replaces the snapshot of dir.
+func writeSnapshot(dir string, s *snapshot) error {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	temporary := filepath.Join(dir, snapshotFile+".tmp")
+	file, err := os.OpenFile(temporary, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temporary, filepath.Join(dir, snapshotFile)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func readSnapshot(dir string) (*snapshot, error) {
+	content, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	if err := json.Unmarshal(content, &s); err != nil {
+		return nil, fmt.Errorf("%w: snapshot: %v", ErrCorrupted, err)
+	}
+	return &s, nil
+}
+
+// syncDir makes created, renamed and removed files of dir durable.
+func syncDir(dir string) error {
+	directory, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+	return directory.Sync()
+}
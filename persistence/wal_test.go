@@ -0,0 +1,457 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/auth"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+func openTestDB(t *testing.T, dir string) *InMemoryDB {
+	db, err := OpenInMemoryDB(WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func createTestDevice(t *testing.T, db *InMemoryDB, organization *domain.Organization, algorithm string, options ...domain.DeviceOption) *domain.SignatureDevice {
+	signer, _ := crypto.SignerFactory(algorithm)
+	device := domain.NewSignatureDevice(organization.Id, algorithm+" device", signer, append(options, domain.WithJournal(db))...)
+	if err := db.Set(device); err != nil {
+		t.Fatal(err)
+	}
+	return device
+}
+
+func assertSameState(t *testing.T, expected *InMemoryDB, actual *InMemoryDB) {
+	for _, organization := range expected.GetOrganizations() {
+		restoredOrganization, exists := actual.GetOrganization(organization.Id)
+		if !exists || restoredOrganization.Name != organization.Name {
+			t.Error("Organization not restored:", organization.Name)
+		}
+		for _, device := range expected.GetAll(organization.Id) {
+			restored, exists := actual.Get(organization.Id, device.Id)
+			if !exists {
+				t.Fatal("Device not restored:", device.Id)
+			}
+			if restored.Label != device.Label || restored.DataFormat != device.DataFormat ||
+				restored.SignatureCounter != device.SignatureCounter || !bytes.Equal(restored.LastSig, device.LastSig) {
+				t.Error("Device state differs after replay:", restored.SignatureCounter, device.SignatureCounter)
+			}
+			if !reflect.DeepEqual(actual.GetTransactions(organization.Id, device.Id), expected.GetTransactions(organization.Id, device.Id)) {
+				t.Error("Journal differs after replay for device", device.Id)
+			}
+
+			data, signature, err := restored.SignData([]byte("after replay"))
+			if err != nil || !device.Signer.VerifySignature(data, signature) {
+				t.Error("Restored device must sign with the original key.")
+			}
+		}
+	}
+}
+
+func TestWAL_ReplayRebuildsState(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	organization := domain.NewOrganization("tenant")
+	if err := db.SetOrganization(organization); err != nil {
+		t.Fatal(err)
+	}
+	rsaDevice := createTestDevice(t, db, organization, "RSA")
+	eccDevice := createTestDevice(t, db, organization, "ECC", domain.WithDataFormat(domain.DataFormatV2))
+	rsaDevice.SignData([]byte("before snapshot"))
+	eccDevice.SignData([]byte("before snapshot"))
+
+	if err := db.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	rsaDevice.SignData([]byte("after snapshot"))
+	rsaDevice.SignData([]byte("after snapshot, again"))
+	if err := rsaDevice.Restore(10, []byte("restored")); err != nil {
+		t.Fatal(err)
+	}
+	db.Set(rsaDevice)
+	rsaDevice.SignData([]byte("after restore"))
+	if _, err := eccDevice.SignBatch([][]byte{[]byte("batch"), []byte("batch, again")}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first database is not closed, as if the process crashed.
+	replayed := openTestDB(t, dir)
+	defer replayed.Close()
+	if replayed.states[eccDevice.Id].Counter != 3 || replayed.states[eccDevice.Id].Version != 3 {
+		t.Error("Batch must be replayed completely, got", replayed.states[eccDevice.Id])
+	}
+	if replayed.states[rsaDevice.Id].Counter != 11 {
+		t.Error("Persisted counter must be restored, got", replayed.states[rsaDevice.Id].Counter)
+	}
+	assertSameState(t, db, replayed)
+}
+
+func TestWAL_ReplaysTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	device := createTestDevice(t, db, &domain.Organization{Id: domain.DefaultOrganizationId}, "ECC")
+	device.SignData([]byte("single"))
+	if err := db.AttachTimestamp(device.Id, domain.Timestamp{Token: []byte("single token"), FirstCounter: 0, Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	device.SignBatch([][]byte{[]byte("a"), []byte("b")}, 0)
+	if err := db.AttachTimestamp(device.Id, domain.Timestamp{Token: []byte("batch token"), FirstCounter: 1, Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AttachTimestamp(device.Id, domain.Timestamp{Token: []byte("too long"), FirstCounter: 2, Count: 2}); !errors.Is(err, ErrTransactionNotFound) {
+		t.Error("Token for unknown transactions must be rejected, got", err)
+	}
+
+	replayed := openTestDB(t, dir)
+	defer replayed.Close()
+	for counter, token := range []string{"single token", "batch token", "batch token"} {
+		transaction, exists := replayed.GetTransaction(domain.DefaultOrganizationId, device.Id, uint64(counter))
+		if !exists || transaction.Timestamp == nil || string(transaction.Timestamp.Token) != token {
+			t.Error("Token of transaction", counter, "not replayed:", transaction.Timestamp)
+		}
+	}
+	assertSameState(t, db, replayed)
+}
+
+func TestWAL_ReplaysCertificateChains(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	organization := &domain.Organization{Id: domain.DefaultOrganizationId}
+	snapshotted := createTestDevice(t, db, organization, "ECC")
+	logged := createTestDevice(t, db, organization, "RSA")
+	if err := db.SetCertificateChain(snapshotted.Id, domain.CertificateChain{Chain: [][]byte{[]byte("first"), []byte("root")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCertificateChain(logged.Id, domain.CertificateChain{Chain: [][]byte{[]byte("second")}, Attached: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCertificateChain(uuid.New(), domain.CertificateChain{Chain: [][]byte{[]byte("unknown")}}); !errors.Is(err, ErrNotFound) {
+		t.Error("Chain of an unknown device must be rejected, got", err)
+	}
+
+	replayed := openTestDB(t, dir)
+	defer replayed.Close()
+	for id, expected := range map[uuid.UUID]string{snapshotted.Id: "first", logged.Id: "second"} {
+		chain, exists := replayed.GetCertificateChain(domain.DefaultOrganizationId, id)
+		if !exists || string(chain.Chain[0]) != expected || chain.Attached != (id == logged.Id) {
+			t.Error("Certificate chain of device", id, "not replayed:", chain)
+		}
+	}
+}
+
+func TestWAL_ReplaysAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	log, err := audit.NewStoredLog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Append(audit.Entry{OrganizationId: domain.DefaultOrganizationId, Action: "device.create", Outcome: audit.OutcomeSuccess})
+	if err := db.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	log.Append(audit.Entry{OrganizationId: domain.DefaultOrganizationId, Action: "device.sign", Outcome: audit.OutcomeSuccess})
+
+	replayed := openTestDB(t, dir)
+	defer replayed.Close()
+	restored, err := audit.NewStoredLog(replayed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := restored.Query(domain.DefaultOrganizationId, audit.Filter{})
+	if len(entries) != 2 || !bytes.Equal(entries[1].Hash, log.Query(domain.DefaultOrganizationId, audit.Filter{})[1].Hash) {
+		t.Fatal("Audit chain not replayed:", entries)
+	}
+	if err := audit.Verify(entries); err != nil {
+		t.Error("Replayed audit chain must verify:", err)
+	}
+}
+
+func TestWAL_FencingTokensSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	organization := &domain.Organization{Id: domain.DefaultOrganizationId}
+	snapshotted := createTestDevice(t, db, organization, "ECC")
+	logged := createTestDevice(t, db, organization, "ECC")
+	db.AcquireLease(snapshotted.Id, "a", "http://a", time.Hour)
+	db.ReleaseLeases("a")
+	db.AcquireLease(snapshotted.Id, "b", "http://b", time.Hour)
+	if err := db.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	db.AcquireLease(logged.Id, "b", "http://b", time.Hour)
+
+	// Reopened without Close, like after a crash: the leases are lost, the tokens are not.
+	replayed := openTestDB(t, dir)
+	defer replayed.Close()
+	if lease, err := replayed.AcquireLease(snapshotted.Id, "c", "http://c", time.Hour); err != nil || lease.Token != 3 {
+		t.Error("Token of the snapshot must continue after a restart, got", lease, err)
+	}
+	if lease, err := replayed.AcquireLease(logged.Id, "c", "http://c", time.Hour); err != nil || lease.Token != 2 {
+		t.Error("Token of the log must continue after a restart, got", lease, err)
+	}
+}
+
+func TestWAL_ReplaysMigrationsAndImports(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	organization := &domain.Organization{Id: domain.DefaultOrganizationId}
+	migrated := createTestDevice(t, db, organization, "ECC")
+	migrated.SignData([]byte("before migration"))
+	if err := db.MigrateDevice(migrated.Id, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	source := GetInMemoryDB()
+	signer, _ := crypto.SignerFactory("RSA")
+	device := domain.NewSignatureDevice(domain.DefaultOrganizationId, "imported", signer, domain.WithJournal(source))
+	source.Set(device)
+	device.SignData([]byte("on the source"))
+	restored := domain.RestoreSignatureDevice(device.Id, device.OrganizationId, device.Label, signer, device.State(), domain.WithJournal(db))
+	if err := db.ImportDevice(restored, source.GetTransactions(domain.DefaultOrganizationId, device.Id)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first database is not closed, as if the process crashed.
+	replayed := openTestDB(t, dir)
+	defer replayed.Close()
+	if !replayed.migrated[migrated.Id] {
+		t.Error("Migration must be replayed.")
+	}
+	if replayed.imported[device.Id] != 1 || len(replayed.transactions[device.Id]) != 1 || replayed.states[device.Id].Counter != 1 {
+		t.Error("Import must be replayed with its journal, got", replayed.imported[device.Id], replayed.states[device.Id])
+	}
+	if err := replayed.ImportDevice(restored, source.GetTransactions(domain.DefaultOrganizationId, device.Id)); !errors.Is(err, domain.ErrStaleState) {
+		t.Error("Stale import must be rejected after a restart, got", err)
+	}
+}
+
+func TestWAL_ReplaysSuspensions(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	organization := &domain.Organization{Id: domain.DefaultOrganizationId}
+	snapshotted := createTestDevice(t, db, organization, "ECC")
+	resumed := createTestDevice(t, db, organization, "ECC")
+	for _, device := range []*domain.SignatureDevice{snapshotted, resumed} {
+		if err := db.SuspendDevice(device.Id, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := snapshotted.SignData([]byte("while suspended")); !errors.Is(err, domain.ErrSuspended) {
+		t.Fatal("Suspended device must not sign, got", err)
+	}
+	if err := db.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SuspendDevice(resumed.Id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first database is not closed, as if the process crashed.
+	replayed := openTestDB(t, dir)
+	defer replayed.Close()
+	if !replayed.Suspended(snapshotted.Id) || replayed.Suspended(resumed.Id) {
+		t.Error("Suspensions must be replayed, got", replayed.Suspended(snapshotted.Id), replayed.Suspended(resumed.Id))
+	}
+	if err := replayed.MigrateDevice(snapshotted.Id, 1); !errors.Is(err, domain.ErrSuspended) {
+		t.Error("Suspended device must not be migrated, got", err)
+	}
+}
+
+func TestWAL_ReplaysAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	keys := auth.NewStoredKeyStore(db)
+	bootstrapSecret := "bootstrap secret"
+	keys.Add(auth.APIKey{Permissions: []auth.Permission{auth.PermissionAdmin}}, bootstrapSecret)
+	kept, keptSecret, _ := keys.Create(auth.APIKey{Name: "terminal", Permissions: []auth.Permission{auth.PermissionRead}})
+	deviceId := uuid.New()
+	if _, err := keys.Update(kept.Id, []auth.Permission{auth.PermissionSign}, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.GrantDevice(kept.Id, deviceId); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	revoked, revokedSecret, _ := keys.Create(auth.APIKey{Permissions: []auth.Permission{auth.PermissionRead}})
+	if err := keys.Delete(revoked.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first database is not closed, as if the process crashed.
+	replayed := openTestDB(t, dir)
+	defer replayed.Close()
+	replayedKeys := auth.NewStoredKeyStore(replayed)
+	key, ok := replayedKeys.Authenticate(keptSecret)
+	if !ok || key.Id != kept.Id || key.Name != "terminal" || !key.HasPermission(auth.PermissionSign) ||
+		key.HasPermission(auth.PermissionRead) || !key.CanAccessDevice(deviceId) {
+		t.Error("API key must be replayed with its changes, got", key)
+	}
+	if _, ok := replayedKeys.Authenticate(revokedSecret); ok {
+		t.Error("Revoked API key must stay revoked after replay.")
+	}
+	if _, ok := replayedKeys.Authenticate(bootstrapSecret); ok {
+		t.Error("Keys added from the configuration must not be stored.")
+	}
+}
+
+func TestWAL_ReplaysKeyRotations(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	organization := &domain.Organization{Id: domain.DefaultOrganizationId}
+	device := createTestDevice(t, db, organization, "ECC")
+	device.SignData([]byte("first key"))
+	rotate := func() {
+		signer, _ := crypto.SignerFactory("ECC")
+		if _, err := device.RotateKey(signer); err != nil {
+			t.Fatal(err)
+		}
+		device.SignData([]byte("rotated key"))
+	}
+	rotate()
+	if err := db.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	rotate()
+
+	// The first database is not closed, as if the process crashed.
+	replayed := openTestDB(t, dir)
+	defer replayed.Close()
+	restored, _ := replayed.Get(organization.Id, device.Id)
+	if restored.KeyVersion() != 3 || !sameCurrentKey(restored, device) {
+		t.Fatal("Rotated key must be replayed, got version", restored.KeyVersion())
+	}
+	if err := domain.VerifyChain(device.Id, restored, replayed.GetTransactions(organization.Id, device.Id)); err != nil {
+		t.Error("Chain signed by all key versions must verify after replay:", err)
+	}
+	assertSameState(t, db, replayed)
+}
+
+// sameCurrentKey reports whether two devices sign with the same current key.
+func sameCurrentKey(a, b *domain.SignatureDevice) bool {
+	publicKey, _ := a.PublicKey(a.KeyVersion())
+	other, _ := b.PublicKey(b.KeyVersion())
+	return reflect.DeepEqual(publicKey, other)
+}
+
+func TestWAL_CloseCompactsLog(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	device := createTestDevice(t, db, &domain.Organization{Id: domain.DefaultOrganizationId}, "ECC")
+	for i := 0; i < 5; i++ {
+		device.SignData([]byte("data"))
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, _ := segments(dir)
+	if len(paths) != 1 {
+		t.Fatal("Expected only the current segment after compaction, got", paths)
+	}
+	if info, _ := os.Stat(paths[0]); info.Size() != 0 {
+		t.Error("Current segment must be empty after the final snapshot.")
+	}
+
+	replayed := openTestDB(t, dir)
+	defer replayed.Close()
+	assertSameState(t, db, replayed)
+}
+
+func TestWAL_TornRecord(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	device := createTestDevice(t, db, &domain.Organization{Id: domain.DefaultOrganizationId}, "RSA")
+	device.SignData([]byte("data"))
+
+	paths, _ := segments(dir)
+	file, _ := os.OpenFile(paths[len(paths)-1], os.O_WRONLY|os.O_APPEND, 0)
+	file.WriteString(`1234abcd {"seq":4,"transa`)
+	file.Close()
+
+	replayed := openTestDB(t, dir)
+	assertSameState(t, db, replayed)
+	replayed.Close()
+
+	corrupted := t.TempDir()
+	db = openTestDB(t, corrupted)
+	createTestDevice(t, db, &domain.Organization{Id: domain.DefaultOrganizationId}, "RSA")
+	paths, _ = segments(corrupted)
+	content, _ := os.ReadFile(paths[0])
+	content[10] ^= 0xff
+	os.WriteFile(filepath.Join(corrupted, filepath.Base(paths[0])), append(content, content...), 0o600)
+	if _, err := OpenInMemoryDB(WALConfig{Dir: corrupted}); !errors.Is(err, ErrCorrupted) {
+		t.Error("A corrupted record before the end of the log must be reported, got", err)
+	}
+}
+
+// A log written while signers returned base64 text is migrated on replay and its chain stays verifiable.
+func TestWAL_MigratesBase64Signatures(t *testing.T) {
+	dir := t.TempDir()
+	signer, _ := crypto.SignerFactory("RSA")
+	privateKey, _ := crypto.MarshalPrivateKey(signer)
+	id := uuid.New()
+
+	legacyLog := &wal{dir: dir}
+	if err := legacyLog.openSegment(1); err != nil {
+		t.Fatal(err)
+	}
+	lastSig := []byte(base64.StdEncoding.EncodeToString(id[:]))
+	legacyLog.append(record{Device: &deviceRecord{Id: id, Label: "legacy", Algorithm: "RSA", PrivateKey: privateKey, LastSignature: lastSig}})
+	for counter := uint64(0); counter < 2; counter++ {
+		signedData := domain.SecuredData{Format: domain.DataFormatV1, Counter: counter, Data: []byte("legacy"), LastSig: lastSig}.Bytes()
+		signature, _ := signer.Sign(signedData)
+		lastSig = []byte(base64.StdEncoding.EncodeToString(signature))
+		legacyLog.append(record{Transaction: &transactionRecord{DeviceId: id, Counter: counter, SignedData: signedData, Signature: lastSig}})
+	}
+	legacyLog.close()
+
+	db := openTestDB(t, dir)
+	device, _ := db.Get(uuid.Nil, id)
+	if _, _, err := device.SignData([]byte("migrated")); err != nil {
+		t.Fatal(err)
+	}
+	transactions := db.GetTransactions(uuid.Nil, id)
+	if transactions[0].SignatureVersion != domain.SignatureVersion1 || transactions[2].SignatureVersion != domain.SignatureVersion2 {
+		t.Error("Legacy transactions must keep their version, got", transactions[0].SignatureVersion, transactions[2].SignatureVersion)
+	}
+	if err := domain.VerifyChain(id, device, transactions); err != nil {
+		t.Error("Migrated chain must verify:", err)
+	}
+
+	// The migration is persisted with the next snapshot.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened := openTestDB(t, dir)
+	defer reopened.Close()
+	reopenedDevice, _ := reopened.Get(uuid.Nil, id)
+	if err := domain.VerifyChain(id, reopenedDevice, reopened.GetTransactions(uuid.Nil, id)); err != nil {
+		t.Error("Chain must verify after compaction:", err)
+	}
+}
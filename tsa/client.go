@@ -0,0 +1,89 @@
+// Package tsa obtains and verifies RFC 3161 time-stamp tokens, which prove that data existed at the time
+// attested by a third-party time-stamp authority (TSA).
+package tsa
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/digitorus/timestamp"
+)
+
+const (
+	// RequestContentType is the media type of time-stamp requests.
+	RequestContentType = "application/timestamp-query"
+	// ResponseContentType is the media type of time-stamp responses.
+	ResponseContentType = "application/timestamp-reply"
+)
+
+// maxResponseSize limits the size of responses read from a time-stamp authority.
+const maxResponseSize = 1 << 20
+
+// ErrMismatch is returned if a token does not cover the expected message or request.
+var ErrMismatch = errors.New("time-stamp token does not match")
+
+// Client requests time-stamp tokens from a time-stamp authority over HTTP.
+type Client struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the time-stamp authority at url whose requests time out after timeout.
+func NewClient(url string, timeout time.Duration) *Client {
+	return &Client{URL: url, HTTPClient: &http.Client{Timeout: timeout}}
+}
+
+// Timestamp requests a token over the SHA-256 hash of message. The token includes the certificate of the
+// authority and is checked against the request before it is returned in DER encoding.
+func (c *Client) Timestamp(ctx context.Context, message []byte) ([]byte, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	query, err := timestamp.CreateRequest(bytes.NewReader(message), &timestamp.RequestOptions{
+		Hash:         crypto.SHA256,
+		Certificates: true,
+		Nonce:        nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", RequestContentType)
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("time-stamp authority responded with %s", response.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := timestamp.ParseResponse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time-stamp response: %w", err)
+	}
+	if token.Nonce == nil || token.Nonce.Cmp(nonce) != 0 {
+		return nil, fmt.Errorf("%w: nonce differs from the request", ErrMismatch)
+	}
+	if _, err := Verify(token.RawToken, message); err != nil {
+		return nil, err
+	}
+	return token.RawToken, nil
+}
@@ -0,0 +1,105 @@
+package tsa
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/digitorus/timestamp"
+)
+
+// Stub is a local time-stamp authority for tests and development. It grants every request with a token signed by
+// a self-signed certificate generated on creation. Its tokens must not be trusted.
+type Stub struct {
+	Certificate *x509.Certificate
+	key         crypto.Signer
+	// Now returns the time attested by tokens.
+	Now func() time.Time
+}
+
+// NewStub creates a stub authority with a new key and certificate.
+func NewStub() (*Stub, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	// RFC 3161 requires the extended key usage of a TSA to be critical, which x509 does not mark it as.
+	extKeyUsage, err := asn1.Marshal([]asn1.ObjectIdentifier{oidTimeStamping})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "Stub Time-Stamp Authority"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtraExtensions:       []pkix.Extension{{Id: oidExtKeyUsage, Critical: true, Value: extKeyUsage}},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Stub{Certificate: certificate, key: key, Now: time.Now}, nil
+}
+
+// ServeHTTP answers time-stamp requests posted as application/timestamp-query.
+func (s *Stub) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(response, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxResponseSize))
+	if err != nil {
+		http.Error(response, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+	query, err := timestamp.ParseRequest(body)
+	if err != nil {
+		http.Error(response, "Invalid time-stamp request", http.StatusBadRequest)
+		return
+	}
+
+	token := timestamp.Timestamp{
+		HashAlgorithm:     query.HashAlgorithm,
+		HashedMessage:     query.HashedMessage,
+		Time:              s.Now().UTC().Truncate(time.Second),
+		Accuracy:          time.Second,
+		Nonce:             query.Nonce,
+		Policy:            stubPolicy,
+		AddTSACertificate: query.Certificates,
+	}
+	reply, err := token.CreateResponseWithOpts(s.Certificate, s.key, crypto.SHA256)
+	if err != nil {
+		http.Error(response, "Failed to create time-stamp", http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", ResponseContentType)
+	response.Write(reply)
+}
+
+var (
+	oidExtKeyUsage  = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidTimeStamping = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+)
+
+// stubPolicy is the policy of all stub tokens, below the example enterprise number of RFC 5612.
+var stubPolicy = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 32473, 1}
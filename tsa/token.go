@@ -0,0 +1,37 @@
+package tsa
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/digitorus/timestamp"
+)
+
+// Verify checks that a DER encoded token covers message and returns the time it attests. The signature of the
+// token is verified against the certificate it contains; whether that certificate belongs to a trusted
+// authority is left to the caller.
+func Verify(token []byte, message []byte) (time.Time, error) {
+	parsed, err := timestamp.Parse(token)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time-stamp token: %w", err)
+	}
+	if !parsed.HashAlgorithm.Available() {
+		return time.Time{}, fmt.Errorf("invalid time-stamp token: unsupported hash algorithm")
+	}
+	hash := parsed.HashAlgorithm.New()
+	hash.Write(message)
+	if !bytes.Equal(parsed.HashedMessage, hash.Sum(nil)) {
+		return time.Time{}, fmt.Errorf("%w: message imprint differs", ErrMismatch)
+	}
+	return parsed.Time, nil
+}
+
+// Time returns the time attested by a DER encoded token without checking which message it covers.
+func Time(token []byte) (time.Time, error) {
+	parsed, err := timestamp.Parse(token)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time-stamp token: %w", err)
+	}
+	return parsed.Time, nil
+}
@@ -0,0 +1,89 @@
+package tsa
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func startStub(t *testing.T) (*Stub, *Client) {
+	t.Helper()
+	stub, err := NewStub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return stub, NewClient(server.URL, 5*time.Second)
+}
+
+func TestClient_TimestampsMessage(t *testing.T) {
+	stub, client := startStub(t)
+	attested := time.Date(2026, 10, 19, 8, 30, 15, 0, time.UTC)
+	stub.Now = func() time.Time { return attested }
+
+	token, err := client.Timestamp(context.Background(), []byte("signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at, err := Verify(token, []byte("signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !at.Equal(attested) {
+		t.Errorf("token attests %v, expected %v", at, attested)
+	}
+	if at, err := Time(token); err != nil || !at.Equal(attested) {
+		t.Errorf("Time returned %v, %v", at, err)
+	}
+}
+
+func TestVerify_RejectsOtherMessage(t *testing.T) {
+	_, client := startStub(t)
+	token, err := client.Timestamp(context.Background(), []byte("signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(token, []byte("other signature")); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+	token[len(token)-1] ^= 0xff
+	if _, err := Verify(token, []byte("signature")); err == nil {
+		t.Error("expected tampered token to be rejected")
+	}
+}
+
+func TestClient_FailsOnErrorResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		http.Error(response, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if _, err := NewClient(server.URL, time.Second).Timestamp(context.Background(), []byte("signature")); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestStub_RejectsInvalidRequests(t *testing.T) {
+	stub, err := NewStub()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	stub.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: expected status %d, got %d", http.StatusMethodNotAllowed, recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	stub.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("empty request: expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}